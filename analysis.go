@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Centipawns a move may lose against the engine's preferred move before it is annotated as each class.
+	inaccuracyLoss = 50
+	mistakeLoss = 100
+	blunderLoss = 300
+	// Evaluations are capped at this many centipawns when working out the loss of a move, since once a game is won it
+	// hardly matters by how much, and a mate that takes a move longer is no mistake.
+	maxAnalysisEval = 1000
+	// Longest line of movetext in exported PGN.
+	pgnLineLength = 80
+)
+
+// How a move compares with the engine's preferred move.
+type MoveClass int
+const (
+	GoodMove MoveClass = iota
+	Inaccuracy
+	Mistake
+	Blunder
+)
+var moveClassName = map[MoveClass]string{
+	GoodMove: "Good move",
+	Inaccuracy: "Inaccuracy",
+	Mistake: "Mistake",
+	Blunder: "Blunder",
+}
+var moveClassAnnotation = map[MoveClass]string{
+	GoodMove: "",
+	Inaccuracy: "?!",
+	Mistake: "?",
+	Blunder: "??",
+}
+// Numeric Annotation Glyphs, which stand for the annotations in PGN.
+var moveClassNAG = map[MoveClass]int{
+	Inaccuracy: 6,
+	Mistake: 2,
+	Blunder: 4,
+}
+func (c MoveClass) String() string {
+	return moveClassName[c]
+}
+
+// Returns the annotation of the class as written after a move, e.g. "??" for a blunder, or "" for a good move.
+func (c MoveClass) Annotation() string {
+	return moveClassAnnotation[c]
+}
+
+// Classifies a move by the centipawns it loses against the best move.
+func classifyMove(loss int) MoveClass {
+	switch {
+	case loss >= blunderLoss:
+		return Blunder
+	case loss >= mistakeLoss:
+		return Mistake
+	case loss >= inaccuracyLoss:
+		return Inaccuracy
+	}
+	return GoodMove
+}
+
+// A move of an annotated game. Evaluations are from the point of view of the player making the move, with mates
+// scored as in the search.
+type AnnotatedMove struct {
+	move ValidMove
+	san string
+	// Human readable text of the move, as shown in the history. See Game.ExecuteValidMove.
+	text string
+	color Color
+	fullmoveNumber int
+	// Evaluation of the position before the move, which is that of the engine's preferred move, and of the position
+	// after the move played. Only set once the game is analysed.
+	before int
+	after int
+	best ValidMove
+	bestSAN string
+	class MoveClass
+}
+
+// A game's moves in notation, and once analysed, with the engine's verdict on each of them.
+type AnnotatedGame struct {
+	// FEN of the position the game started from, or "" for the standard starting position.
+	startFEN string
+	white string
+	black string
+	date time.Time
+	// PGN result, "*" while the game goes on.
+	result string
+	moves []AnnotatedMove
+	analysed bool
+}
+
+// Returns the moves of the game without analysis, e.g. for exporting it as it is. The players are named "?".
+func NewAnnotatedGame(g *Game) *AnnotatedGame {
+	a := &AnnotatedGame{startFEN: g.startFEN, white: "?", black: "?", date: time.Now(), result: "*"}
+	if result, _, over := g.Result(); over {
+		a.result = result
+	}
+	_, moveTexts := g.replay(len(g.moves)-g.setupMoves, func(replayed *Game, vm ValidMove) {
+		am := AnnotatedMove{move: vm, san: replayed.SAN(vm), color: replayed.currentPlayer, fullmoveNumber: replayed.fullmoveNumber}
+		a.moves = append(a.moves, am)
+	})
+	for i, text := range moveTexts {
+		a.moves[i].text = text
+	}
+	return a
+}
+
+// Searches every position of the game within the limits, and annotates each move by how much worse it is than the
+// move the searcher prefers. progress, if not nil, is called after each position with the number searched so far and
+// in total. The searcher should not play from an opening book, since book moves come without an evaluation.
+func AnalyzeGame(ctx context.Context, searcher Searcher, g *Game, limits SearchLimits, progress func(done int, total int)) (*AnnotatedGame, error) {
+	a := NewAnnotatedGame(g)
+	pos := g.initialGame()
+	total := len(a.moves) + 1
+	scores := make([]int, total)
+	for i := range total {
+		result, err := searcher.Search(ctx, pos, limits)
+		if errors.Is(err, errNoLegalMoves) {
+			err = nil
+			result.score = 0
+			if inCheck(pos.currentPlayer, pos.board) {
+				result.score = -mateScore
+			}
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		scores[i] = result.score
+		if i < len(a.moves) {
+			a.moves[i].best = result.move
+			a.moves[i].bestSAN = pos.SAN(result.move)
+			pos.ExecuteValidMove(a.moves[i].move)
+		}
+		if progress != nil {
+			progress(i + 1, total)
+		}
+	}
+
+	for i := range a.moves {
+		m := &a.moves[i]
+		m.before = scores[i]
+		m.after = -scores[i+1]
+		if m.move.asMove() != m.best.asMove() {
+			loss := clampEval(m.before) - clampEval(m.after)
+			m.class = classifyMove(loss)
+		}
+	}
+	a.analysed = true
+	return a, nil
+}
+
+// Caps the score at maxAnalysisEval either way.
+func clampEval(score int) int {
+	return max(-maxAnalysisEval, min(score, maxAnalysisEval))
+}
+
+// Returns the line shown in the history for the move: its text, annotation and, if it is annotated, the engine's
+// preferred move.
+func (m AnnotatedMove) HistoryText() string {
+	if m.class == GoodMove {
+		return m.text
+	}
+	return fmt.Sprintf("%v %v (best %v)", m.text, m.class.Annotation(), m.bestSAN)
+}
+
+// Writes the game in Portable Game Notation. Once analysed, annotated moves are marked with NAGs, and every move is
+// followed by a comment with the evaluation after it, in the [%eval] form most tools read, and the engine's preferred
+// move where it differs.
+func (a *AnnotatedGame) WritePGN(w io.Writer) error {
+	var sb strings.Builder
+	tags := [][2]string{
+		{"Event", "Casual game"},
+		{"Site", "?"},
+		{"Date", a.date.Format("2006.01.02")},
+		{"Round", "-"},
+		{"White", a.white},
+		{"Black", a.black},
+		{"Result", a.result},
+	}
+	if a.startFEN != "" {
+		tags = append(tags, [2]string{"SetUp", "1"}, [2]string{"FEN", a.startFEN})
+	}
+	if a.analysed {
+		tags = append(tags, [2]string{"Annotator", uciEngineName})
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(&sb, "[%v %q]\n", tag[0], tag[1])
+	}
+	sb.WriteString("\n")
+
+	var tokens []string
+	afterComment := false
+	for i, m := range a.moves {
+		// Move numbers are kept on the same line as their move.
+		if m.color == White {
+			tokens = append(tokens, fmt.Sprintf("%v. %v", m.fullmoveNumber, m.san))
+		} else if i == 0 || afterComment {
+			tokens = append(tokens, fmt.Sprintf("%v... %v", m.fullmoveNumber, m.san))
+		} else {
+			tokens = append(tokens, m.san)
+		}
+		afterComment = false
+		if !a.analysed {
+			continue
+		}
+		if m.class != GoodMove {
+			tokens = append(tokens, fmt.Sprintf("$%v", moveClassNAG[m.class]))
+		}
+		comment := pgnEval(-m.after, m.color.Opponent())
+		if m.class != GoodMove {
+			comment += fmt.Sprintf(" %v. %v was best.", m.class, m.bestSAN)
+		}
+		comment = strings.TrimSpace(comment)
+		if comment == "" {
+			continue
+		}
+		// Comments are split into words so that long ones can be wrapped.
+		tokens = append(tokens, strings.Fields("{" + comment + "}")...)
+		afterComment = true
+	}
+	tokens = append(tokens, a.result)
+
+	line := 0
+	for _, token := range tokens {
+		if line > 0 && line + 1 + len(token) > pgnLineLength {
+			sb.WriteString("\n")
+			line = 0
+		} else if line > 0 {
+			sb.WriteString(" ")
+			line++
+		}
+		sb.WriteString(token)
+		line += len(token)
+	}
+	sb.WriteString("\n\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Reads the first game of PGN text and plays its moves, from the position of its FEN tag if it has one. Moves may also
+// be given in coordinate notation. Comments, variations, NAGs and move numbers are skipped, and the game ends at its
+// result or the next game's tags. Returns the game and its tags.
+func ReadPGN(r io.Reader) (*Game, map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	text := string(data)
+	tags := make(map[string]string)
+	var g *Game
+	// Variations nest, and their moves are not played.
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		var end int
+		switch {
+		case c == '{':
+			end = strings.IndexByte(text[i:], '}')
+		case c == ';' || (c == '%' && (i == 0 || text[i-1] == '\n')):
+			end = strings.IndexByte(text[i:], '\n')
+		case c == '(':
+			depth++
+			continue
+		case c == ')':
+			depth--
+			continue
+		case c == '[' && depth == 0:
+			if g != nil {
+				return g, tags, nil
+			}
+			end = strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("PGN tag %q is not closed", text[i:])
+			}
+			name, value, _ := strings.Cut(strings.TrimSpace(text[i+1 : i+end]), " ")
+			if unquoted, err := strconv.Unquote(strings.TrimSpace(value)); err == nil {
+				value = unquoted
+			}
+			tags[name] = value
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			continue
+		default:
+			end = strings.IndexAny(text[i:], " \t\r\n{;()[")
+			if end < 0 {
+				end = len(text) - i
+			}
+			token := text[i : i+end]
+			if g == nil {
+				if g, err = pgnStartingGame(tags); err != nil {
+					return nil, nil, err
+				}
+			}
+			if pgnResults[token] {
+				return g, tags, nil
+			}
+			// Move numbers may be written right before their move, as in "12.e4".
+			token = strings.TrimLeft(token, "0123456789")
+			token = strings.TrimLeft(token, ".")
+			if depth > 0 || token == "" || token[0] == '$' {
+				i += end - 1
+				continue
+			}
+			m, err := parseMoveText(g, token)
+			if err != nil {
+				return nil, nil, fmt.Errorf("PGN move %v %q: %w", g.fullmoveNumber, token, err)
+			}
+			g.ExecuteValidMove(m)
+			i += end - 1
+			continue
+		}
+		if end < 0 {
+			// comments may run to the end of the text
+			end = len(text) - i
+		}
+		i += end
+	}
+	if g == nil {
+		if len(tags) == 0 {
+			return nil, nil, errors.New("no PGN game found")
+		}
+		g, err = pgnStartingGame(tags)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return g, tags, nil
+}
+
+// PGN game termination markers.
+var pgnResults = map[string]bool{"1-0": true, "0-1": true, "1/2-1/2": true, "*": true}
+
+// Returns the game a PGN game starts from: the position of its FEN tag, or the standard starting position.
+func pgnStartingGame(tags map[string]string) (*Game, error) {
+	if fen, found := tags["FEN"]; found {
+		return NewGameFromFEN(fen)
+	}
+	return NewGame(), nil
+}
+
+// Formats a score of the player to move as an [%eval] command. Returns "" for a position that is already checkmate.
+func pgnEval(score int, toMove Color) string {
+	eval := whiteEval(score, toMove)
+	if eval == "" {
+		return ""
+	}
+	return fmt.Sprintf("[%%eval %v]", eval)
+}
+
+// Formats a score of the player to move from White's point of view in pawns, e.g. "-0.35", or as "#N" for a mate in
+// N moves, negative if Black mates. Returns "" for a position that is already checkmate.
+func whiteEval(score int, toMove Color) string {
+	sign := 1
+	if toMove == Black {
+		sign = -1
+	}
+	switch {
+	case score >= mateThreshold:
+		return fmt.Sprintf("#%v", sign * (mateScore - score + 1) / 2)
+	case score <= -mateThreshold && score > -mateScore:
+		return fmt.Sprintf("#%v", -sign * (mateScore + score) / 2)
+	case score <= -mateScore:
+		return ""
+	}
+	return fmt.Sprintf("%.2f", float64(sign * score) / 100)
+}
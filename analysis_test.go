@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyMove(t *testing.T) {
+	var tests = []struct{
+		loss int
+		want MoveClass
+	}{
+		{-20, GoodMove},
+		{0, GoodMove},
+		{49, GoodMove},
+		{50, Inaccuracy},
+		{99, Inaccuracy},
+		{100, Mistake},
+		{299, Mistake},
+		{300, Blunder},
+		{2 * maxAnalysisEval, Blunder},
+	}
+
+	for _, tt := range tests {
+		if got := classifyMove(tt.loss); got != tt.want {
+			t.Errorf("classifyMove(%v) got %v, want %v", tt.loss, got, tt.want)
+		}
+	}
+}
+
+func TestPGNEval(t *testing.T) {
+	var tests = []struct{
+		score int
+		toMove Color
+		want string
+	}{
+		{35, White, "[%eval 0.35]"},
+		{35, Black, "[%eval -0.35]"},
+		{-120, Black, "[%eval 1.20]"},
+		{mateScore - 1, White, "[%eval #1]"},
+		{mateScore - 3, Black, "[%eval #-2]"},
+		{-mateScore + 2, White, "[%eval #-1]"},
+		{-mateScore, White, ""},
+	}
+
+	for _, tt := range tests {
+		if got := pgnEval(tt.score, tt.toMove); got != tt.want {
+			t.Errorf("pgnEval(%v, %v) got %q, want %q", tt.score, tt.toMove, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeGame(t *testing.T) {
+	g := NewGame()
+	playMoves(t, g, "e2e4", "e7e5", "f1c4", "b8c6", "d1h5", "g8f6", "h5f7")
+	calls := 0
+	a, err := AnalyzeGame(context.Background(), NewEngine(), g, SearchLimits{depth: 4}, func(done int, total int) {
+		calls++
+		if done != calls || total != 8 {
+			t.Errorf("progress got %v of %v, want %v of 8", done, total, calls)
+		}
+	})
+	if err != nil {
+		t.Fatalf("AnalyzeGame returned error %v", err)
+	}
+	if len(a.moves) != 7 || calls != 8 {
+		t.Fatalf("AnalyzeGame got %v moves after %v progress calls, want 7 after 8", len(a.moves), calls)
+	}
+	blunder := a.moves[5]
+	if blunder.san != "Nf6" || blunder.class != Blunder || blunder.bestSAN == "Nf6" {
+		t.Errorf("got %v %v with %v best, want Nf6 as a blunder", blunder.san, blunder.class, blunder.bestSAN)
+	}
+	if got := blunder.HistoryText(); !strings.HasSuffix(got, " ?? (best " + blunder.bestSAN + ")") {
+		t.Errorf("HistoryText got %q", got)
+	}
+	if mate := a.moves[6]; mate.san != "Qxf7#" || mate.class != GoodMove {
+		t.Errorf("got %v %v, want Qxf7# as a good move", mate.san, mate.class)
+	}
+	if a.result != "1-0" {
+		t.Errorf("result got %q, want 1-0", a.result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AnalyzeGame(ctx, NewEngine(), g, SearchLimits{depth: 4}, nil); err == nil {
+		t.Errorf("AnalyzeGame with a cancelled context got no error")
+	}
+}
+
+func TestWritePGN(t *testing.T) {
+	g := NewGame()
+	playMoves(t, g, "e2e4", "e7e5", "f1c4", "b8c6", "d1h5", "g8f6", "h5f7")
+	a := NewAnnotatedGame(g)
+	a.white = "Alice"
+	a.date = time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := a.WritePGN(&buf); err != nil {
+		t.Fatalf("WritePGN returned error %v", err)
+	}
+	want := `[Event "Casual game"]
+[Site "?"]
+[Date "2024.03.09"]
+[Round "-"]
+[White "Alice"]
+[Black "?"]
+[Result "1-0"]
+
+1. e4 e5 2. Bc4 Nc6 3. Qh5 Nf6 4. Qxf7# 1-0
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WritePGN got\n%v\nwant\n%v", got, want)
+	}
+
+	a, err := AnalyzeGame(context.Background(), NewEngine(), g, SearchLimits{depth: 4}, nil)
+	if err != nil {
+		t.Fatalf("AnalyzeGame returned error %v", err)
+	}
+	buf.Reset()
+	if err := a.WritePGN(&buf); err != nil {
+		t.Fatalf("WritePGN returned error %v", err)
+	}
+	got := buf.String()
+	// Lines may wrap anywhere between tokens.
+	words := strings.Join(strings.Fields(got), " ")
+	for _, s := range []string{`[Annotator "go-chess"]`, "3... Nf6 $4 {[%eval #1] Blunder.", "4. Qxf7# 1-0"} {
+		if !strings.Contains(words, s) {
+			t.Errorf("WritePGN got\n%v\nwhich does not contain %q", got, s)
+		}
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > pgnLineLength {
+			t.Errorf("WritePGN line %q is longer than %v", line, pgnLineLength)
+		}
+	}
+
+	g, err = NewGameFromFEN("4k3/8/8/8/8/8/4P3/4K3 b - - 0 12")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	playMoves(t, g, "e8d7", "e2e4")
+	buf.Reset()
+	if err := NewAnnotatedGame(g).WritePGN(&buf); err != nil {
+		t.Fatalf("WritePGN returned error %v", err)
+	}
+	for _, s := range []string{`[SetUp "1"]`, `[FEN "4k3/8/8/8/8/8/4P3/4K3 b - - 0 12"]`, "12... Kd7 13. e4 *"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("WritePGN got\n%v\nwhich does not contain %q", buf.String(), s)
+		}
+	}
+}
+
+func TestReadPGN(t *testing.T) {
+	var tests = []struct{
+		name string
+		pgn string
+		wantFEN string
+		wantTags map[string]string
+		wantErr bool
+	}{
+		{
+			"tags and comments",
+			"[Event \"Casual game\"]\n[White \"Al \\\"the\\\" ice\"]\n\n1. e4 {[%eval 0.30]\n[%clk 0:05:00]} e5 ; a rest of line comment\n" +
+				"2. Nf3 (2. f4 exf4 (2... d5) 3. Nf3) 2... Nc6 $1 3.Bb5 1-0\n\n[Event \"Next game\"]\n\n1. d4 *\n",
+			"r1bqkbnr/pppp1ppp/2n5/1B2p3/4P3/5N2/PPPP1PPP/RNBQK2R b KQkq - 3 3",
+			map[string]string{"Event": "Casual game", "White": "Al \"the\" ice"},
+			false,
+		},
+		{
+			"set up position",
+			"[SetUp \"1\"]\n[FEN \"4k3/8/8/8/8/8/4P3/4K3 b - - 0 12\"]\n\n12... Kd7 13. e4 *",
+			"8/3k4/8/8/4P3/8/8/4K3 b - - 0 13",
+			map[string]string{"SetUp": "1", "FEN": "4k3/8/8/8/8/8/4P3/4K3 b - - 0 12"},
+			false,
+		},
+		{"moves only", "e2e4 e5 2. g1f3", "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2", map[string]string{}, false},
+		{"invalid move", "1. e4 e4", "", nil, true},
+		{"empty", "\n", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, tags, err := ReadPGN(strings.NewReader(tt.pgn))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadPGN got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadPGN returned error %v", err)
+			}
+			if got := g.FEN(); got != tt.wantFEN {
+				t.Errorf("ReadPGN got position %q, want %q", got, tt.wantFEN)
+			}
+			if !maps.Equal(tags, tt.wantTags) {
+				t.Errorf("ReadPGN got tags %v, want %v", tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestReadPGNRoundTrip(t *testing.T) {
+	g := NewGame()
+	playMoves(t, g, "e2e4", "e7e5", "f1c4", "b8c6", "d1h5", "g8f6", "h5f7")
+	a, err := AnalyzeGame(context.Background(), NewEngine(), g, SearchLimits{depth: 3}, nil)
+	if err != nil {
+		t.Fatalf("AnalyzeGame returned error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.WritePGN(&buf); err != nil {
+		t.Fatalf("WritePGN returned error %v", err)
+	}
+	read, _, err := ReadPGN(&buf)
+	if err != nil {
+		t.Fatalf("ReadPGN returned error %v", err)
+	}
+	if read.FEN() != g.FEN() {
+		t.Errorf("ReadPGN got %q, want %q", read.FEN(), g.FEN())
+	}
+	if result, _, _ := read.Result(); result != "1-0" {
+		t.Errorf("ReadPGN got result %q, want 1-0", result)
+	}
+}
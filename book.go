@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand/v2"
+	"os"
+	"slices"
+)
+
+// Size of an entry in a Polyglot book: key, move, weight and learn data, all big-endian.
+const polyglotEntrySize = 16
+
+// Polyglot numbers pieces black pawn, white pawn, black knight, white knight and so on up to white king.
+var polyglotPieceKinds = map[PieceType]int{
+	Pawn: 0,
+	Knight: 1,
+	Bishop: 2,
+	Rook: 3,
+	Queen: 4,
+	King: 5,
+}
+
+// Promotion piece types in the order of the move encoding, where 0 is no promotion.
+var polyglotPromotions = []PieceType{Pawn, Knight, Bishop, Rook, Queen}
+
+type polyglotEntry struct {
+	key uint64
+	move uint16
+	weight uint16
+}
+
+// OpeningBook is a Polyglot opening book, which maps positions by their Polyglot key to weighted moves.
+type OpeningBook struct {
+	// Sorted by key, as in the file.
+	entries []polyglotEntry
+}
+
+// A book move for a position, with its weight relative to the other book moves.
+type BookMove struct {
+	move ValidMove
+	weight int
+}
+
+// Loads a Polyglot book file.
+func LoadOpeningBook(path string) (*OpeningBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	book, err := ReadOpeningBook(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("book %v: %w", path, err)
+	}
+	return book, nil
+}
+
+// Reads a Polyglot book. Entries that are out of order are sorted, so that lookups work for books written by tools
+// that do not sort.
+func ReadOpeningBook(r io.Reader) (*OpeningBook, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) % polyglotEntrySize != 0 {
+		return nil, fmt.Errorf("size %v is not a multiple of the %v byte entry size", len(data), polyglotEntrySize)
+	}
+	book := OpeningBook{entries: make([]polyglotEntry, 0, len(data) / polyglotEntrySize)}
+	for i := 0; i < len(data); i += polyglotEntrySize {
+		book.entries = append(book.entries, polyglotEntry{
+			key: binary.BigEndian.Uint64(data[i:]),
+			move: binary.BigEndian.Uint16(data[i+8:]),
+			weight: binary.BigEndian.Uint16(data[i+10:]),
+		})
+	}
+	slices.SortStableFunc(book.entries, func(a, b polyglotEntry) int {
+		if a.key < b.key {
+			return -1
+		} else if a.key > b.key {
+			return 1
+		}
+		return 0
+	})
+	return &book, nil
+}
+
+// Returns the book moves for the game's current position, highest weight first. Entries for moves that are not
+// valid in the position are left out.
+func (b *OpeningBook) Moves(g *Game) []BookMove {
+	key := polyglotKey(g.currentPlayer, g.board, g.moves)
+	i, _ := slices.BinarySearchFunc(b.entries, key, func(e polyglotEntry, key uint64) int {
+		if e.key < key {
+			return -1
+		} else if e.key > key {
+			return 1
+		}
+		return 0
+	})
+	var moves []BookMove
+	for ; i < len(b.entries) && b.entries[i].key == key; i++ {
+		if m, found := polyglotMove(b.entries[i].move, g); found {
+			moves = append(moves, BookMove{m, int(b.entries[i].weight)})
+		}
+	}
+	slices.SortStableFunc(moves, func(a, b BookMove) int {
+		return b.weight - a.weight
+	})
+	return moves
+}
+
+// Picks one of the book moves for the game's current position at random, each with a chance in proportion to its
+// weight. Returns false if the position is not in the book.
+func (b *OpeningBook) PickMove(g *Game) (ValidMove, bool) {
+	moves := b.Moves(g)
+	total := 0
+	for _, m := range moves {
+		total += m.weight
+	}
+	if total == 0 {
+		return ValidMove{}, false
+	}
+	n := rand.IntN(total)
+	for _, m := range moves {
+		n -= m.weight
+		if n < 0 {
+			return m.move, true
+		}
+	}
+	panic("unreachable")
+}
+
+// Returns the Polyglot key of the position with the given color to move. It follows the same scheme as zobristHash,
+// but with the standard Polyglot keys, so that published books can be looked up.
+func polyglotKey(color Color, b Board, gameMoves []Move) uint64 {
+	var key uint64
+	for c := range b.players {
+		for pt, pieces := range b.players[c].pieces {
+			kind := polyglotPieceKinds[PieceType(pt)] * 2
+			if Color(c) == White {
+				kind++
+			}
+			for pieces != 0 {
+				key ^= polyglotRandom[64 * kind + bits.TrailingZeros64(pieces)]
+				pieces &= pieces - 1
+			}
+		}
+	}
+	rights := castlingRights(b, gameMoves)
+	for i, right := range []int{whiteKingside, whiteQueenside, blackKingside, blackQueenside} {
+		if rights & right != 0 {
+			key ^= polyglotRandom[768 + i]
+		}
+	}
+	if file, found := enPassantFile(color, b, gameMoves); found {
+		key ^= polyglotRandom[772 + file]
+	}
+	if color == White {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+// Decodes a Polyglot move into the valid move it stands for. Castling is encoded as the king moving onto its rook.
+func polyglotMove(encoded uint16, g *Game) (ValidMove, bool) {
+	dest := CartesianCoord{int(encoded & 7), int(encoded >> 3 & 7)}
+	from := CartesianCoord{int(encoded >> 6 & 7), int(encoded >> 9 & 7)}
+	promotionIndex := int(encoded >> 12 & 7)
+	if promotionIndex >= len(polyglotPromotions) {
+		return ValidMove{}, false
+	}
+	if p, found := GetCoord(from, g.board); found && p.pieceType == King && from.X == 4 {
+		if rook, found := GetCoord(dest, g.board); found && rook.pieceType == Rook && rook.color == p.color {
+			if dest.X == 7 {
+				dest.X = 6
+			} else if dest.X == 0 {
+				dest.X = 2
+			}
+		}
+	}
+	return g.FindValidMove(from, dest, polyglotPromotions[promotionIndex])
+}
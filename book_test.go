@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPolyglotKey(t *testing.T) {
+	// Keys published with the Polyglot book format.
+	var tests = []struct{
+		moves []string
+		want uint64
+	}{
+		{nil, 0x463b96181691fc9c},
+		{[]string{"e2e4"}, 0x823c9b50fd114196},
+		{[]string{"e2e4", "d7d5"}, 0x0756b94461c50fb0},
+		{[]string{"e2e4", "d7d5", "e4e5"}, 0x662fafb965db29d4},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5"}, 0x22a48b5a8e47ff78},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2"}, 0x652a607ca3f242c1},
+		{[]string{"e2e4", "d7d5", "e4e5", "f7f5", "e1e2", "e8f7"}, 0x00fdd303c946bdd9},
+		{[]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4"}, 0x3c8123ea7b067637},
+		{[]string{"a2a4", "b7b5", "h2h4", "b5b4", "c2c4", "b4c3", "a1a3"}, 0x5c3f9b829b279560},
+	}
+
+	for _, tt := range tests {
+		g := NewGame()
+		playMoves(t, g, tt.moves...)
+		if got := polyglotKey(g.currentPlayer, g.board, g.moves); got != tt.want {
+			t.Errorf("polyglotKey after %v got %016x, want %016x", tt.moves, got, tt.want)
+		}
+	}
+}
+
+// Encodes a move as in a Polyglot book. See polyglotMove.
+func polyglotEncodeMove(m ValidMove) uint16 {
+	dest := m.dest
+	if m.specialMove == Castling && dest.X == 6 {
+		dest.X = 7
+	} else if m.specialMove == Castling && dest.X == 2 {
+		dest.X = 0
+	}
+	encoded := uint16(dest.X) | uint16(dest.Y) << 3 | uint16(m.piece.cc.X) << 6 | uint16(m.piece.cc.Y) << 9
+	return encoded | uint16(slices.Index(polyglotPromotions, m.promotion)) << 12
+}
+
+// A book entry for writing test books: the moves leading to the position, and the book move with its weight.
+type testBookEntry struct {
+	moves []string
+	move string
+	weight uint16
+}
+
+// Writes the entries as a Polyglot book, in the given order rather than sorted.
+func writeTestBook(t *testing.T, entries []testBookEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, e := range entries {
+		g := NewGame()
+		playMoves(t, g, e.moves...)
+		m, err := g.ParseCoordMove(e.move)
+		if err != nil {
+			t.Fatalf("ParseCoordMove returned error %v", err)
+		}
+		entry := make([]byte, polyglotEntrySize)
+		binary.BigEndian.PutUint64(entry, polyglotKey(g.currentPlayer, g.board, g.moves))
+		binary.BigEndian.PutUint16(entry[8:], polyglotEncodeMove(m))
+		binary.BigEndian.PutUint16(entry[10:], e.weight)
+		buf.Write(entry)
+	}
+	return buf.Bytes()
+}
+
+func TestOpeningBook(t *testing.T) {
+	castling := []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6"}
+	data := writeTestBook(t, []testBookEntry{
+		{nil, "d2d4", 10},
+		{castling, "e1g1", 1},
+		{nil, "e2e4", 30},
+		{[]string{"e2e4"}, "c7c5", 5},
+		{nil, "g1f3", 0},
+	})
+	path := filepath.Join(t.TempDir(), "book.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile returned error %v", err)
+	}
+	book, err := LoadOpeningBook(path)
+	if err != nil {
+		t.Fatalf("LoadOpeningBook returned error %v", err)
+	}
+
+	var tests = []struct{
+		name string
+		moves []string
+		want []string
+	}{
+		{"start", nil, []string{"e2e4", "d2d4", "g1f3"}},
+		{"reply", []string{"e2e4"}, []string{"c7c5"}},
+		{"castling", castling, []string{"e1g1"}},
+		{"out of book", []string{"a2a3"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame()
+			playMoves(t, g, tt.moves...)
+			var got []string
+			for _, m := range book.Moves(g) {
+				got = append(got, m.move.asMove().CoordNotation())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Moves got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	counts := make(map[string]int)
+	for range 400 {
+		m, found := book.PickMove(NewGame())
+		if !found {
+			t.Fatalf("PickMove found no move")
+		}
+		counts[m.asMove().CoordNotation()]++
+	}
+	if counts["g1f3"] != 0 || counts["e2e4"] < counts["d2d4"] || counts["d2d4"] == 0 {
+		t.Errorf("PickMove counts %v do not follow the weights", counts)
+	}
+	g := NewGame()
+	playMoves(t, g, "a2a3")
+	if _, found := book.PickMove(g); found {
+		t.Errorf("PickMove found a move out of book")
+	}
+
+	if _, err := ReadOpeningBook(bytes.NewReader(data[:20])); err == nil {
+		t.Errorf("ReadOpeningBook of truncated book got no error")
+	}
+}
+
+func TestEngineBookMoves(t *testing.T) {
+	book, err := ReadOpeningBook(bytes.NewReader(writeTestBook(t, []testBookEntry{{nil, "b1c3", 1}})))
+	if err != nil {
+		t.Fatalf("ReadOpeningBook returned error %v", err)
+	}
+	e := NewEngine()
+	e.SetBook(book)
+
+	result, err := e.Search(context.Background(), NewGame(), SearchLimits{depth: 2})
+	if err != nil {
+		t.Fatalf("Search returned error %v", err)
+	}
+	if got := result.move.asMove().CoordNotation(); got != "b1c3" || result.nodes != 0 {
+		t.Errorf("Search in book got %v after %v nodes, want b1c3 without searching", got, result.nodes)
+	}
+
+	g := NewGame()
+	playMoves(t, g, "b1c3")
+	result, err = e.Search(context.Background(), g, SearchLimits{depth: 2})
+	if err != nil {
+		t.Fatalf("Search returned error %v", err)
+	}
+	if result.nodes == 0 {
+		t.Errorf("Search out of book did not search")
+	}
+}
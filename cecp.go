@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State of a Chess Engine Communication Protocol (xboard) session. As with UCI, commands are read on one goroutine
+// while the engine thinks on another. The thinking goroutine makes the engine's move itself, so the game is only
+// touched by the reading goroutine while no search runs.
+type cecpSession struct {
+	out io.Writer
+	outMutex sync.Mutex
+
+	engine *Engine
+	game *Game
+	// In force mode the engine only checks and records moves, and never thinks.
+	force bool
+	engineColor Color
+	post bool
+
+	// Time control from level, st and sd. Zero values mean no limit of that kind.
+	movesPerSession int
+	increment time.Duration
+	moveTime time.Duration
+	depth int
+	// Remaining time of the engine and its opponent, from time and otim.
+	clock time.Duration
+	opponentClock time.Duration
+
+	// Set while the engine thinks. If discard is set when the search returns, its move is not made.
+	cancel context.CancelFunc
+	done chan struct{}
+	discardMutex sync.Mutex
+	discard bool
+}
+
+// Runs the engine as a CECP (xboard, protocol version 2) engine, reading commands from in and writing responses to
+// out until the quit command or the end of the input.
+func RunCECP(in io.Reader, out io.Writer, engine *Engine) error {
+	s := cecpSession{
+		out: out,
+		engine: engine,
+	}
+	s.newGame()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if s.handle(scanner.Text()) {
+			return nil
+		}
+	}
+
+	// At the end of the input let a running search make its move.
+	if s.done != nil {
+		<-s.done
+	}
+	return scanner.Err()
+}
+
+// Handles one command line, and reports whether it was quit.
+func (s *cecpSession) handle(line string) bool {
+	line = strings.TrimSpace(line)
+	command, args, _ := strings.Cut(line, " ")
+	switch command {
+	case "xboard", "accepted", "rejected", "random", "computer", "name", "hard", "easy", "ics":
+		// nothing to do
+	case "protover":
+		s.send("feature myname=\"%v\" ping=1 setboard=1 usermove=1 san=0 time=1 draw=0 sigint=0 sigterm=0 " +
+			"reuse=1 analyze=0 colors=0 smp=1 variants=\"normal\" done=1", uciEngineName)
+	case "ping":
+		s.send("pong %v", args)
+	case "new":
+		s.stopThinking(false)
+		s.newGame()
+	case "setboard":
+		s.stopThinking(false)
+		g, err := NewGameFromFEN(args)
+		if err != nil {
+			s.send("tellusererror Illegal position: %v", err)
+			return false
+		}
+		s.game = g
+	case "force":
+		s.stopThinking(false)
+		s.force = true
+	case "go":
+		s.stopThinking(false)
+		s.force = false
+		s.engineColor = s.game.currentPlayer
+		s.startThinking()
+	case "playother":
+		s.stopThinking(false)
+		s.force = false
+		s.engineColor = s.game.currentPlayer.Opponent()
+	case "?":
+		s.stopThinking(true)
+	case "usermove":
+		s.stopThinking(false)
+		s.userMove(args)
+	case "undo":
+		s.stopThinking(false)
+		s.game.Undo()
+	case "remove":
+		s.stopThinking(false)
+		s.game.Undo()
+		s.game.Undo()
+	case "result":
+		s.stopThinking(false)
+		s.force = true
+	case "level":
+		s.level(args)
+	case "st":
+		seconds, err := strconv.ParseFloat(args, 64)
+		if err != nil || seconds <= 0 {
+			s.send("Error (invalid st): %v", line)
+			return false
+		}
+		s.moveTime = time.Duration(seconds * float64(time.Second))
+	case "sd":
+		depth, err := strconv.Atoi(args)
+		if err != nil || depth <= 0 {
+			s.send("Error (invalid sd): %v", line)
+			return false
+		}
+		s.depth = depth
+	case "cores":
+		cores, err := strconv.Atoi(args)
+		if err != nil || cores <= 0 {
+			s.send("Error (invalid cores): %v", line)
+			return false
+		}
+		s.stopThinking(false)
+		s.engine.SetThreads(cores)
+	case "time", "otim":
+		centiseconds, err := strconv.Atoi(args)
+		if err != nil {
+			s.send("Error (invalid %v): %v", command, line)
+			return false
+		}
+		if command == "time" {
+			s.clock = time.Duration(centiseconds) * 10 * time.Millisecond
+		} else {
+			s.opponentClock = time.Duration(centiseconds) * 10 * time.Millisecond
+		}
+	case "post":
+		s.post = true
+	case "nopost":
+		s.post = false
+	case "quit":
+		s.stopThinking(false)
+		return true
+	default:
+		s.send("Error (unknown command): %v", command)
+	}
+	return false
+}
+
+func (s *cecpSession) send(format string, args ...any) {
+	s.outMutex.Lock()
+	defer s.outMutex.Unlock()
+	fmt.Fprintf(s.out, format + "\n", args...)
+}
+
+// Resets to the standard starting position with the engine playing Black, as the protocol requires for new.
+func (s *cecpSession) newGame() {
+	s.game = NewGame()
+	s.force = false
+	s.engineColor = Black
+	s.moveTime = 0
+	s.depth = 0
+	s.engine.Clear()
+}
+
+// Handles "level MPS BASE INC". BASE is in minutes, or minutes:seconds; INC is in seconds.
+func (s *cecpSession) level(args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 {
+		s.send("Error (invalid level): level %v", args)
+		return
+	}
+	movesPerSession, err := strconv.Atoi(fields[0])
+	if err != nil || movesPerSession < 0 {
+		s.send("Error (invalid level): level %v", args)
+		return
+	}
+	minutes, seconds, _ := strings.Cut(fields[1], ":")
+	base, err := strconv.Atoi(minutes)
+	if err != nil {
+		s.send("Error (invalid level): level %v", args)
+		return
+	}
+	baseSeconds := 0
+	if seconds != "" {
+		if baseSeconds, err = strconv.Atoi(seconds); err != nil {
+			s.send("Error (invalid level): level %v", args)
+			return
+		}
+	}
+	increment, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || increment < 0 {
+		s.send("Error (invalid level): level %v", args)
+		return
+	}
+	s.movesPerSession = movesPerSession
+	s.increment = time.Duration(increment * float64(time.Second))
+	s.moveTime = 0
+	// Until the GUI sends time and otim, both players have the base time.
+	s.clock = time.Duration(base) * time.Minute + time.Duration(baseSeconds) * time.Second
+	s.opponentClock = s.clock
+}
+
+// Makes the opponent's move, and starts the engine thinking if it is now its turn.
+func (s *cecpSession) userMove(args string) {
+	m, err := s.game.ParseCoordMove(args)
+	if err != nil {
+		s.send("Illegal move: %v", args)
+		return
+	}
+	s.game.ExecuteValidMove(m)
+	if s.sendResult() {
+		return
+	}
+	if !s.force && s.game.currentPlayer == s.engineColor {
+		s.startThinking()
+	}
+}
+
+// Sends the result if the game has ended, and reports whether it has.
+func (s *cecpSession) sendResult() bool {
+	result, reason, over := s.game.Result()
+	if over {
+		s.send("%v {%v}", result, reason)
+	}
+	return over
+}
+
+// Returns the search limits for the engine's next move under the current time control.
+func (s *cecpSession) limits() SearchLimits {
+	limits := SearchLimits{
+		depth: s.depth,
+		moveTime: s.moveTime,
+	}
+	if s.moveTime == 0 && s.clock > 0 {
+		limits.clock[s.engineColor] = s.clock
+		limits.clock[s.engineColor.Opponent()] = s.opponentClock
+		limits.increment[s.engineColor] = s.increment
+		limits.increment[s.engineColor.Opponent()] = s.increment
+		if s.movesPerSession > 0 {
+			limits.movesToGo = s.movesPerSession - (s.game.fullmoveNumber - 1) % s.movesPerSession
+		}
+	}
+	return limits
+}
+
+// Starts the engine thinking about the current position. When the search returns, its move is made and sent unless
+// it was discarded by stopThinking.
+func (s *cecpSession) startThinking() {
+	if len(s.game.LegalMoves()) == 0 {
+		s.sendResult()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.cancel = cancel
+	s.done = done
+	s.discard = false
+	post := s.post
+	s.engine.onIteration = func(result SearchResult) {
+		if post {
+			s.send("%v", cecpThinking(result))
+		}
+	}
+
+	g := s.game
+	limits := s.limits()
+	go func() {
+		defer close(done)
+		result, err := s.engine.Search(ctx, g.Clone(), limits)
+		s.discardMutex.Lock()
+		defer s.discardMutex.Unlock()
+		if s.discard || err != nil {
+			return
+		}
+		g.ExecuteValidMove(result.move)
+		s.send("move %v", result.move.asMove().CoordNotation())
+		s.sendResult()
+	}()
+}
+
+// Stops the engine thinking, if it is, and waits for the search to return. If play is set the engine makes the best
+// move found so far, otherwise the search is discarded.
+func (s *cecpSession) stopThinking(play bool) {
+	if s.cancel == nil {
+		return
+	}
+	s.discardMutex.Lock()
+	s.discard = !play
+	s.discardMutex.Unlock()
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+	s.done = nil
+}
+
+// Formats a search iteration as thinking output: depth, score in centipawns, time in centiseconds, nodes and the
+// principal variation.
+func cecpThinking(result SearchResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v %v %v %v", result.depth, result.score, result.elapsed.Milliseconds() / 10, result.nodes)
+	for _, m := range result.pv {
+		sb.WriteString(" " + m.CoordNotation())
+	}
+	return sb.String()
+}
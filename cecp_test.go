@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCECP(t *testing.T) {
+	var tests = []struct{
+		name string
+		input string
+		// Every line must appear in the output, which must not contain any of unwantedLines.
+		wantLines []string
+		unwantedLines []string
+		// If set, the output must have exactly this many engine moves.
+		wantMoveCount int
+	}{
+		{
+			"handshake",
+			"xboard\nprotover 2\nping 7\nquit\n",
+			[]string{`feature myname="go-chess"`, "usermove=1", "smp=1", "setboard=1", "done=1", "pong 7"},
+			nil,
+			0,
+		},
+		{
+			"engine replies as black",
+			"new\nsd 2\nusermove e2e4\n",
+			nil,
+			[]string{"Illegal move", "Error"},
+			1,
+		},
+		{
+			"go after force",
+			"new\nforce\nusermove e2e4\nusermove e7e5\nsd 1\ngo\n",
+			nil,
+			[]string{"Illegal move", "Error"},
+			1,
+		},
+		{
+			"force does not move",
+			"new\nforce\nusermove e2e4\nusermove e7e5\n",
+			nil,
+			nil,
+			0,
+		},
+		{
+			"illegal move",
+			"new\nforce\nusermove e2e5\nusermove e7e5\n",
+			[]string{"Illegal move: e2e5", "Illegal move: e7e5"},
+			nil,
+			0,
+		},
+		{
+			"mate",
+			"new\nforce\nsetboard 7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1\nsd 3\ngo\n",
+			[]string{"move a1a8", "1-0 {White mates}"},
+			nil,
+			1,
+		},
+		{
+			"mated by user",
+			"new\nforce\nusermove f2f3\nusermove e7e5\nusermove g2g4\nplayother\nusermove d8h4\n",
+			[]string{"0-1 {Black mates}"},
+			nil,
+			0,
+		},
+		{
+			"undo and remove",
+			"new\nforce\nusermove e2e4\nusermove e7e5\nremove\nusermove e2e4\nundo\nusermove d2d4\nusermove d7d5\n",
+			nil,
+			[]string{"Illegal move"},
+			0,
+		},
+		{
+			"setboard errors",
+			"new\nforce\nsetboard 8/8/8 w - - 0 1\n",
+			[]string{"tellusererror Illegal position"},
+			nil,
+			0,
+		},
+		{
+			"time control",
+			"new\nlevel 40 0:30 0\ntime 3000\notim 3000\nusermove e2e4\n",
+			nil,
+			[]string{"Error"},
+			1,
+		},
+		{
+			"cores",
+			"new\ncores 3\nsd 3\nusermove e2e4\ncores x\n",
+			[]string{"Error (invalid cores): cores x"},
+			[]string{"Illegal move"},
+			1,
+		},
+		{
+			"thinking output",
+			"new\npost\nsd 2\nusermove e2e4\n",
+			[]string{"\n2 "},
+			nil,
+			1,
+		},
+		{
+			"unknown command",
+			"new\nbogus\nlevel 40 x 0\n",
+			[]string{"Error (unknown command): bogus", "Error (invalid level): level 40 x 0"},
+			nil,
+			0,
+		},
+	}
+
+	moveLine := regexp.MustCompile(`(?m)^move [a-h][1-8][a-h][1-8][qrbn]?$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := RunCECP(strings.NewReader(tt.input), &out, NewEngine()); err != nil {
+				t.Fatalf("RunCECP returned error %v", err)
+			}
+			output := out.String()
+			for _, line := range tt.wantLines {
+				if !strings.Contains(output, line) {
+					t.Errorf("output %q does not contain %q", output, line)
+				}
+			}
+			for _, line := range tt.unwantedLines {
+				if strings.Contains(output, line) {
+					t.Errorf("output %q contains %q", output, line)
+				}
+			}
+			if got := len(moveLine.FindAllString(output, -1)); got != tt.wantMoveCount {
+				t.Errorf("output %q has %v moves, want %v", output, got, tt.wantMoveCount)
+			}
+		})
+	}
+}
+
+func TestCECPMoveNow(t *testing.T) {
+	in, inWriter := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- RunCECP(in, &out, NewEngine())
+	}()
+
+	io.WriteString(inWriter, "new\nst 60\nusermove e2e4\n")
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(inWriter, "?\nquit\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RunCECP returned error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("engine did not move now")
+	}
+	if !strings.Contains(out.String(), "move ") {
+		t.Errorf("output %q has no move", out.String())
+	}
+}
+
+func TestCECPLimits(t *testing.T) {
+	var tests = []struct{
+		name string
+		commands []string
+		fullmoveNumber int
+		want SearchLimits
+	}{
+		{"none", nil, 1, SearchLimits{}},
+		{"depth", []string{"sd 4"}, 1, SearchLimits{depth: 4}},
+		{"fixed time", []string{"st 2.5"}, 1, SearchLimits{moveTime: 2500 * time.Millisecond}},
+		{
+			"incremental",
+			[]string{"level 0 2 12", "time 6000", "otim 9000"},
+			10,
+			SearchLimits{
+				clock: [2]time.Duration{90 * time.Second, time.Minute},
+				increment: [2]time.Duration{12 * time.Second, 12 * time.Second},
+			},
+		},
+		{
+			"moves per session",
+			[]string{"level 40 5 0"},
+			45,
+			SearchLimits{clock: [2]time.Duration{5 * time.Minute, 5 * time.Minute}, movesToGo: 36},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			s := cecpSession{out: &out, engine: NewEngine()}
+			s.newGame()
+			for _, c := range tt.commands {
+				s.handle(c)
+			}
+			s.game.fullmoveNumber = tt.fullmoveNumber
+			if got := s.limits(); got != tt.want {
+				t.Errorf("limits got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
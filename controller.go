@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+)
+
+// Controller decides the moves of one side of a game: a human at the keyboard, an engine, or a player elsewhere on
+// the network. The bitboards of a side are its Player, which is why this is not called that.
+type Controller interface {
+	// Returns the move to play in the current position of the game, waiting for it as long as it takes unless ctx is
+	// cancelled. The game is not changed.
+	NextMove(ctx context.Context, g *Game) (ValidMove, error)
+}
+
+// MoveObserver is implemented by controllers that need to know of every move played, whoever played it.
+type MoveObserver interface {
+	// Called after the move was played, with the game after it.
+	MovePlayed(g *Game, m ValidMove)
+}
+
+// Plays the game on until it is over, asking the controller of the side to move, indexed by Color, for each move.
+// onMove, if not nil, is called after each move with the move and its text, see Game.ExecuteValidMove. An error is
+// returned if a controller fails, or gives a move that is not valid, or if ctx is cancelled.
+func PlayGame(ctx context.Context, g *Game, controllers [2]Controller, onMove func(m ValidMove, text string)) error {
+	for {
+		if _, _, over := g.Result(); over {
+			return nil
+		}
+		color := g.currentPlayer
+		m, err := controllers[color].NextMove(ctx, g.Clone())
+		if err != nil {
+			return fmt.Errorf("%v move: %w", color, err)
+		}
+		valid, found := g.FindValidMove(m.piece.cc, m.dest, m.promotion)
+		if !found || valid.piece != m.piece {
+			return fmt.Errorf("%v move %v is not valid", color, m.asMove().CoordNotation())
+		}
+		text, _ := g.ExecuteValidMove(valid)
+		for _, c := range controllers {
+			if observer, ok := c.(MoveObserver); ok {
+				observer.MovePlayed(g, valid)
+			}
+		}
+		if onMove != nil {
+			onMove(valid, text)
+		}
+	}
+}
+
+// HumanController plays the moves a human enters, which are handed to it with Submit while it waits for one.
+type HumanController struct {
+	moves chan ValidMove
+}
+
+func NewHumanController() *HumanController {
+	return &HumanController{moves: make(chan ValidMove)}
+}
+
+// Waits for a move to be submitted.
+func (h *HumanController) NextMove(ctx context.Context, g *Game) (ValidMove, error) {
+	select {
+	case m := <-h.moves:
+		return m, nil
+	case <-ctx.Done():
+		return ValidMove{}, ctx.Err()
+	}
+}
+
+// Hands the move entered to the controller. Returns false, dropping the move, if the controller is not waiting for one.
+func (h *HumanController) Submit(m ValidMove) bool {
+	select {
+	case h.moves <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// EngineController plays the moves a searcher finds within limits, such as those of the built-in engine.
+type EngineController struct {
+	searcher Searcher
+	limits SearchLimits
+	// Called with the game and the result of each search, e.g. to show the evaluation. May be nil.
+	report func(g *Game, result SearchResult)
+}
+
+func NewEngineController(searcher Searcher, limits SearchLimits) *EngineController {
+	return &EngineController{searcher: searcher, limits: limits}
+}
+
+func (e *EngineController) NextMove(ctx context.Context, g *Game) (ValidMove, error) {
+	result, err := e.searcher.Search(ctx, g, e.limits)
+	if err != nil {
+		return ValidMove{}, err
+	}
+	if e.report != nil {
+		e.report(g, result)
+	}
+	return result.move, nil
+}
+
+// UCIController plays the moves of an external UCI engine, which it runs as a subprocess.
+type UCIController struct {
+	EngineController
+	engine *UCIEngine
+}
+
+// Starts the engine binary at path with args, to search each move within the limits. The controller must be closed.
+func StartUCIController(limits SearchLimits, path string, args ...string) (*UCIController, error) {
+	engine, err := StartUCIEngine(path, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &UCIController{EngineController: EngineController{searcher: engine, limits: limits}, engine: engine}, nil
+}
+
+// Stops the engine.
+func (u *UCIController) Close() error {
+	return u.engine.Close()
+}
+
+// RandomController plays a random legal move, e.g. for testing, or as the weakest possible opponent.
+type RandomController struct{}
+
+func (RandomController) NextMove(ctx context.Context, g *Game) (ValidMove, error) {
+	moves := g.LegalMoves()
+	if len(moves) == 0 {
+		return ValidMove{}, errNoLegalMoves
+	}
+	return moves[rand.IntN(len(moves))], nil
+}
+
+var errConnectionClosed = errors.New("connection closed by the other player")
+
+// NetworkController plays the moves of a player on the other end of a connection, who plays with the same program.
+// Both ends start from the same position and send each other the moves of their own side, one per line in coordinate
+// notation.
+type NetworkController struct {
+	conn io.ReadWriter
+	reader *bufio.Reader
+	// Number of moves of the game the other end already knows of: those it sent, and those sent to it.
+	known int
+}
+
+func NewNetworkController(conn io.ReadWriter) *NetworkController {
+	return &NetworkController{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Waits for a connection from the other player on the address, e.g. ":7000", and plays their moves.
+func ListenNetworkController(address string) (*NetworkController, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewNetworkController(conn), nil
+}
+
+// Connects to the other player waiting at the address, e.g. "host:7000", and plays their moves.
+func DialNetworkController(address string) (*NetworkController, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetworkController(conn), nil
+}
+
+// Waits for the other player's move. If ctx is cancelled while waiting, the connection is closed, since a line may
+// have been partly read.
+func (n *NetworkController) NextMove(ctx context.Context, g *Game) (ValidMove, error) {
+	type read struct {
+		line string
+		err error
+	}
+	lines := make(chan read, 1)
+	go func() {
+		line, err := n.reader.ReadString('\n')
+		lines <- read{line, err}
+	}()
+	var r read
+	select {
+	case r = <-lines:
+	case <-ctx.Done():
+		n.Close()
+		return ValidMove{}, ctx.Err()
+	}
+	if errors.Is(r.err, io.EOF) {
+		return ValidMove{}, errConnectionClosed
+	}
+	if r.err != nil {
+		return ValidMove{}, r.err
+	}
+	m, err := g.ParseCoordMove(strings.TrimSpace(r.line))
+	if err != nil {
+		return ValidMove{}, fmt.Errorf("other player sent %w", err)
+	}
+	n.known = len(g.moves) + 1
+	return m, nil
+}
+
+// Sends the move to the other player, unless it is the one they sent.
+func (n *NetworkController) MovePlayed(g *Game, m ValidMove) {
+	if len(g.moves) <= n.known {
+		return
+	}
+	n.known = len(g.moves)
+	// A failed write shows up as a closed connection when the other player's move is read.
+	fmt.Fprintln(n.conn, m.asMove().CoordNotation())
+}
+
+// Closes the connection, if it can be closed.
+func (n *NetworkController) Close() error {
+	if closer, ok := n.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Returns a controller from its spec: "human" for the human controller given, "random" for RandomController,
+// "listen=address" or "dial=address" for a player over the network, or an engine spec as for NewMatchSearcher, to
+// search each move within the limits. Controllers that hold a connection or an external engine implement io.Closer
+// and must be closed.
+func NewController(spec string, human Controller, limits SearchLimits, threads int, tablebases *Tablebases) (Controller, error) {
+	kind, value, _ := strings.Cut(spec, "=")
+	switch kind {
+	case "human":
+		return human, nil
+	case "random":
+		return RandomController{}, nil
+	case "listen", "dial":
+		connect := ListenNetworkController
+		if kind == "dial" {
+			connect = DialNetworkController
+		}
+		network, err := connect(value)
+		if err != nil {
+			return nil, err
+		}
+		return network, nil
+	case "uci":
+		args := strings.Fields(value)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("player %q has no path", spec)
+		}
+		external, err := StartUCIController(limits, args[0], args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return external, nil
+	}
+	searcher, err := NewMatchSearcher(spec, threads, tablebases)
+	if err != nil {
+		return nil, fmt.Errorf("player %q, want human, random, listen=address, dial=address or an engine: %w", spec, err)
+	}
+	return NewEngineController(searcher, limits), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPlayGame(t *testing.T) {
+	var tests = []struct{
+		name string
+		white Controller
+		black Controller
+		wantResult string
+	}{
+		{
+			"engines", NewEngineController(&scriptedSearcher{moves: []string{"f2f3", "g2g4"}}, SearchLimits{}),
+			NewEngineController(&scriptedSearcher{moves: []string{"e7e5", "d8h4"}}, SearchLimits{}), "0-1",
+		},
+		{"engine and random", NewEngineController(NewEngine(), SearchLimits{depth: 1}), RandomController{}, ""},
+		{"random", RandomController{}, RandomController{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame()
+			played := 0
+			err := PlayGame(context.Background(), g, [2]Controller{tt.white, tt.black}, func(m ValidMove, text string) {
+				played++
+			})
+			if err != nil {
+				t.Fatalf("PlayGame returned error %v", err)
+			}
+			result, _, over := g.Result()
+			if !over {
+				t.Fatalf("PlayGame returned before the game was over")
+			}
+			if tt.wantResult != "" && result != tt.wantResult {
+				t.Errorf("result got %v, want %v", result, tt.wantResult)
+			}
+			if played != len(g.moves) {
+				t.Errorf("onMove called %v times, want %v", played, len(g.moves))
+			}
+		})
+	}
+}
+
+func TestPlayGameInvalidMove(t *testing.T) {
+	// Black answers with a move that is only valid for White.
+	other := NewGame()
+	m, err := other.ParseCoordMove("e2e4")
+	if err != nil {
+		t.Fatalf("ParseCoordMove returned error %v", err)
+	}
+	black := NewHumanController()
+	go func() {
+		for !black.Submit(m) {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err = PlayGame(context.Background(), NewGame(), [2]Controller{RandomController{}, black}, nil)
+	if err == nil {
+		t.Errorf("PlayGame got no error for an invalid move")
+	}
+}
+
+func TestHumanController(t *testing.T) {
+	human := NewHumanController()
+	g := NewGame()
+	m, err := g.ParseCoordMove("e2e4")
+	if err != nil {
+		t.Fatalf("ParseCoordMove returned error %v", err)
+	}
+	if human.Submit(m) {
+		t.Errorf("Submit got true while no move was expected")
+	}
+
+	moves := make(chan ValidMove)
+	go func() {
+		got, _ := human.NextMove(context.Background(), g)
+		moves <- got
+	}()
+	for !human.Submit(m) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := <-moves; got.asMove() != m.asMove() {
+		t.Errorf("NextMove got %v, want %v", got.asMove().CoordNotation(), m.asMove().CoordNotation())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := human.NextMove(ctx, g); err == nil {
+		t.Errorf("NextMove got no error after cancel")
+	}
+}
+
+func TestNetworkController(t *testing.T) {
+	// Both ends play random moves for their own side against the other end.
+	whiteConn, blackConn := net.Pipe()
+	games := [2]*Game{NewGame(), NewGame()}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- PlayGame(context.Background(), games[White], [2]Controller{RandomController{}, NewNetworkController(whiteConn)}, nil)
+	}()
+	go func() {
+		errs <- PlayGame(context.Background(), games[Black], [2]Controller{NewNetworkController(blackConn), RandomController{}}, nil)
+	}()
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("PlayGame returned error %v", err)
+		}
+	}
+	if games[White].FEN() != games[Black].FEN() || len(games[White].moves) != len(games[Black].moves) {
+		t.Errorf("ends got different games %q and %q", games[White].FEN(), games[Black].FEN())
+	}
+
+	// The other end leaving ends the game with an error.
+	whiteConn, blackConn = net.Pipe()
+	blackConn.Close()
+	err := PlayGame(context.Background(), NewGame(), [2]Controller{NewNetworkController(whiteConn), RandomController{}}, nil)
+	if err == nil {
+		t.Errorf("PlayGame got no error after the other end closed the connection")
+	}
+}
+
+func TestNewController(t *testing.T) {
+	human := NewHumanController()
+	var tests = []struct{
+		spec string
+		want string
+	}{
+		{"human", "*main.HumanController"},
+		{"random", "main.RandomController"},
+		{"builtin", "*main.EngineController"},
+		{"skill=3", "*main.EngineController"},
+		{"uci=", ""},
+		{"skill=x", ""},
+		{"nobody", ""},
+	}
+	for _, tt := range tests {
+		c, err := NewController(tt.spec, human, SearchLimits{depth: 1}, 1, nil)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NewController(%q) got no error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewController(%q) returned error %v", tt.spec, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", c); got != tt.want {
+			t.Errorf("NewController(%q) got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
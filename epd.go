@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// A test position from an EPD (Extended Position Description) suite such as WAC or STS, with the moves that solve it.
+type EPDPosition struct {
+	// Name of the position from its "id" operation, or its line number in the file if it has none.
+	id string
+	game *Game
+	// Moves of which the engine must play one, from the "bm" operation, and moves it must not play, from "am". A
+	// position has at least one of them.
+	bestMoves []ValidMove
+	avoidMoves []ValidMove
+	// Every operation of the position, keyed by opcode, with its operands as written. Quotes around strings are
+	// removed.
+	operations map[string][]string
+}
+
+// Parses a line of EPD: the first four fields of a FEN position, followed by operations such as `bm Nf3; id "x";`.
+// Moves are given in SAN. The halfmove clock and fullmove number are taken from the "hmvc" and "fmvn" operations.
+func ParseEPD(line string) (*EPDPosition, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("EPD %q has %v position fields, want 4", line, len(fields))
+	}
+	// The operations start after the fourth field, which is found by skipping the first three and the spaces around
+	// them.
+	rest := strings.TrimSpace(line)
+	for range 4 {
+		rest = strings.TrimLeft(rest, " \t")
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		rest = rest[end:]
+	}
+	operations, err := parseEPDOperations(rest)
+	if err != nil {
+		return nil, fmt.Errorf("EPD %q: %w", line, err)
+	}
+
+	hmvc, fmvn := "0", "1"
+	if operands := operations["hmvc"]; len(operands) == 1 {
+		hmvc = operands[0]
+	}
+	if operands := operations["fmvn"]; len(operands) == 1 {
+		fmvn = operands[0]
+	}
+	g, err := NewGameFromFEN(strings.Join(append(fields[:4:4], hmvc, fmvn), " "))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EPDPosition{game: g, operations: operations}
+	if operands := operations["id"]; len(operands) > 0 {
+		p.id = strings.Join(operands, " ")
+	}
+	for _, op := range []struct{
+		opcode string
+		moves *[]ValidMove
+	}{
+		{"bm", &p.bestMoves},
+		{"am", &p.avoidMoves},
+	} {
+		for _, san := range operations[op.opcode] {
+			m, err := g.ParseSAN(san)
+			if err != nil {
+				return nil, fmt.Errorf("EPD %q %v: %w", line, op.opcode, err)
+			}
+			*op.moves = append(*op.moves, m)
+		}
+	}
+	if len(p.bestMoves) == 0 && len(p.avoidMoves) == 0 {
+		return nil, fmt.Errorf("EPD %q has neither a bm nor an am operation", line)
+	}
+	return p, nil
+}
+
+var errUnterminatedEPDString = errors.New("unterminated string operand")
+
+// Splits the operations part of an EPD line into opcodes and operands. Each operation ends with a semicolon, which a
+// quoted string operand may contain.
+func parseEPDOperations(s string) (map[string][]string, error) {
+	operations := make(map[string][]string)
+	var tokens []string
+	var token strings.Builder
+	inToken := false
+	flush := func() {
+		if inToken {
+			tokens = append(tokens, token.String())
+			token.Reset()
+			inToken = false
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, errUnterminatedEPDString
+			}
+			token.WriteString(s[i+1 : i+1+end])
+			inToken = true
+			i += end + 1
+		case c == ';':
+			flush()
+			if len(tokens) > 0 {
+				operations[tokens[0]] = tokens[1:]
+			}
+			tokens = nil
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			token.WriteByte(c)
+			inToken = true
+		}
+	}
+	flush()
+	if len(tokens) > 0 {
+		// the last operation may leave out its semicolon
+		operations[tokens[0]] = tokens[1:]
+	}
+	return operations, nil
+}
+
+// Reads an EPD suite, one position per line. Blank lines and lines starting with "#" are skipped.
+func ReadEPD(r io.Reader) ([]*EPDPosition, error) {
+	var positions []*EPDPosition
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParseEPD(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", n, err)
+		}
+		if p.id == "" {
+			p.id = fmt.Sprintf("line %v", n)
+		}
+		positions = append(positions, p)
+	}
+	return positions, scanner.Err()
+}
+
+// Loads an EPD suite file.
+func LoadEPD(path string) ([]*EPDPosition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	positions, err := ReadEPD(f)
+	if err != nil {
+		return nil, fmt.Errorf("suite %v: %w", path, err)
+	}
+	return positions, nil
+}
+
+// Reports whether playing the move solves the position: it is one of the best moves, if any are given, and none of
+// the moves to avoid.
+func (p *EPDPosition) Solved(m ValidMove) bool {
+	for _, avoid := range p.avoidMoves {
+		if avoid.asMove() == m.asMove() {
+			return false
+		}
+	}
+	if len(p.bestMoves) == 0 {
+		return true
+	}
+	for _, best := range p.bestMoves {
+		if best.asMove() == m.asMove() {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the moves the position expects in the form of its operations, e.g. "bm Nf3 Ng5" or "am Qxb2".
+func (p *EPDPosition) Expected() string {
+	var parts []string
+	for _, op := range []struct{
+		opcode string
+		moves []ValidMove
+	}{
+		{"bm", p.bestMoves},
+		{"am", p.avoidMoves},
+	} {
+		if len(op.moves) == 0 {
+			continue
+		}
+		part := op.opcode
+		for _, m := range op.moves {
+			part += " " + p.game.SAN(m)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Outcome of searching a position of a suite.
+type EPDResult struct {
+	position *EPDPosition
+	result SearchResult
+	solved bool
+}
+
+// Searches every position of the suite within the limits and reports whether the move found solves it. report, if not
+// nil, is called with the result of each position as soon as it is searched. The searcher is cleared before each
+// position, so that the results do not depend on the order of the suite.
+func RunEPDSuite(ctx context.Context, searcher Searcher, positions []*EPDPosition, limits SearchLimits, report func(EPDResult)) ([]EPDResult, error) {
+	results := make([]EPDResult, 0, len(positions))
+	for _, p := range positions {
+		searcher.Clear()
+		result, err := searcher.Search(ctx, p.game.Clone(), limits)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return results, fmt.Errorf("position %v: %w", p.id, err)
+		}
+		r := EPDResult{position: p, result: result, solved: p.Solved(result.move)}
+		results = append(results, r)
+		if report != nil {
+			report(r)
+		}
+	}
+	return results, nil
+}
+
+// Writes a line for the result of a position: its id, whether it was solved, the move played and the moves expected,
+// and the search's depth, nodes and time.
+func WriteEPDResult(w io.Writer, r EPDResult) error {
+	verdict := "ok"
+	if !r.solved {
+		verdict = "FAIL"
+	}
+	_, err := fmt.Fprintf(w, "%-16v %-4v %-8v %-20v depth %v nodes %v time %v\n", r.position.id, verdict,
+		r.position.game.SAN(r.result.move), r.position.Expected(), r.result.depth, r.result.nodes,
+		r.result.elapsed.Round(time.Millisecond))
+	return err
+}
+
+// Writes a summary of the results of a suite: how many positions were solved, and the nodes and time searched in total.
+func WriteEPDSummary(w io.Writer, name string, results []EPDResult) error {
+	solved := 0
+	nodes := uint64(0)
+	elapsed := time.Duration(0)
+	for _, r := range results {
+		if r.solved {
+			solved++
+		}
+		nodes += r.result.nodes
+		elapsed += r.result.elapsed
+	}
+	_, err := fmt.Fprintf(w, "%v: solved %v of %v, nodes %v, time %v\n", name, solved, len(results), nodes,
+		elapsed.Round(time.Millisecond))
+	return err
+}
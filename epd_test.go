@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseEPD(t *testing.T) {
+	var tests = []struct{
+		name string
+		epd string
+		wantID string
+		wantFEN string
+		wantExpected string
+	}{
+		{
+			"best move",
+			`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`,
+			"WAC.001", "2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - 0 1", "bm Qg6",
+		},
+		{
+			"several best moves",
+			`r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - bm Bb5 Bc4; id "open";`,
+			"open", "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 0 1", "bm Bb5 Bc4",
+		},
+		{
+			"avoid move and clocks",
+			`4k3/8/8/8/8/8/1q6/R3K3 w Q - am Kf1; hmvc 3; fmvn 40; c0 "semicolon; in comment";`,
+			"", "4k3/8/8/8/8/8/1q6/R3K3 w Q - 3 40", "am Kf1",
+		},
+		{
+			"no final semicolon",
+			`7k/5ppp/8/8/8/8/8/R5K1 w - - bm Ra8#`,
+			"", "7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "bm Ra8#",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseEPD(tt.epd)
+			if err != nil {
+				t.Fatalf("ParseEPD returned error %v", err)
+			}
+			if p.id != tt.wantID {
+				t.Errorf("id got %q, want %q", p.id, tt.wantID)
+			}
+			if got := p.game.FEN(); got != tt.wantFEN {
+				t.Errorf("FEN got %q, want %q", got, tt.wantFEN)
+			}
+			if got := p.Expected(); got != tt.wantExpected {
+				t.Errorf("Expected got %q, want %q", got, tt.wantExpected)
+			}
+		})
+	}
+}
+
+func TestParseEPDErrors(t *testing.T) {
+	var tests = []struct{
+		name string
+		epd string
+	}{
+		{"too few fields", "7k/5ppp/8/8 w"},
+		{"bad position", "7k/5ppp/8/8/8/8/8/R5K1 x - - bm Ra8#;"},
+		{"illegal best move", "7k/5ppp/8/8/8/8/8/R5K1 w - - bm Rb8;"},
+		{"no best or avoid move", `7k/5ppp/8/8/8/8/8/R5K1 w - - id "x";`},
+		{"unterminated string", `7k/5ppp/8/8/8/8/8/R5K1 w - - bm Ra8#; id "x;`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEPD(tt.epd); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
+
+func TestRunEPDSuite(t *testing.T) {
+	suite := `# mates in one, and a capture that loses the queen
+7k/5ppp/8/8/8/8/8/R5K1 w - - bm Ra8#; id "back rank";
+
+r5k1/5ppp/8/8/8/8/5PPP/6K1 b - - bm Ra1#; id "black mates";
+3qk3/8/8/3p4/8/8/8/3QK3 w - - am Qxd5; id "poisoned pawn";
+3qk3/8/8/3p4/8/8/8/3QK3 w - - bm Qxd5;
+`
+	positions, err := ReadEPD(strings.NewReader(suite))
+	if err != nil {
+		t.Fatalf("ReadEPD returned error %v", err)
+	}
+	if len(positions) != 4 {
+		t.Fatalf("ReadEPD got %v positions, want 4", len(positions))
+	}
+	if positions[3].id != "line 6" {
+		t.Errorf("id of position without one got %q, want %q", positions[3].id, "line 6")
+	}
+
+	var reported []string
+	results, err := RunEPDSuite(context.Background(), NewEngine(), positions, SearchLimits{depth: 3}, func(r EPDResult) {
+		reported = append(reported, r.position.id)
+	})
+	if err != nil {
+		t.Fatalf("RunEPDSuite returned error %v", err)
+	}
+	if len(reported) != len(positions) {
+		t.Errorf("reported %v results, want %v", len(reported), len(positions))
+	}
+	wantSolved := []bool{true, true, true, false}
+	for i, r := range results {
+		if r.solved != wantSolved[i] {
+			t.Errorf("%v solved got %v with %v, want %v", r.position.id, r.solved, r.position.game.SAN(r.result.move), wantSolved[i])
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteEPDSummary(&out, "suite", results); err != nil {
+		t.Fatalf("WriteEPDSummary returned error %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "suite: solved 3 of 4,") {
+		t.Errorf("summary got %q", out.String())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// Material values in centipawns, indexed by PieceType. The king is never captured so it carries no material value.
+var pieceValues = [...]int{
+	Pawn: 100,
+	Rook: 500,
+	Knight: 320,
+	Bishop: 330,
+	Queen: 900,
+	King: 0,
+}
+
+// Piece-square tables, indexed by PieceType. Each table is laid out as the board is printed from White's side: the
+// first row is rank 8 and the last row is rank 1. Use pieceSquareValue to look up a score for either color.
+var pieceSquareTables = [...][64]int{
+	Pawn: {
+		  0,   0,   0,   0,   0,   0,   0,   0,
+		 50,  50,  50,  50,  50,  50,  50,  50,
+		 10,  10,  20,  30,  30,  20,  10,  10,
+		  5,   5,  10,  25,  25,  10,   5,   5,
+		  0,   0,   0,  20,  20,   0,   0,   0,
+		  5,  -5, -10,   0,   0, -10,  -5,   5,
+		  5,  10,  10, -20, -20,  10,  10,   5,
+		  0,   0,   0,   0,   0,   0,   0,   0,
+	},
+	Rook: {
+		  0,   0,   0,   0,   0,   0,   0,   0,
+		  5,  10,  10,  10,  10,  10,  10,   5,
+		 -5,   0,   0,   0,   0,   0,   0,  -5,
+		 -5,   0,   0,   0,   0,   0,   0,  -5,
+		 -5,   0,   0,   0,   0,   0,   0,  -5,
+		 -5,   0,   0,   0,   0,   0,   0,  -5,
+		 -5,   0,   0,   0,   0,   0,   0,  -5,
+		  0,   0,   0,   5,   5,   0,   0,   0,
+	},
+	Knight: {
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20,   0,   0,   0,   0, -20, -40,
+		-30,   0,  10,  15,  15,  10,   0, -30,
+		-30,   5,  15,  20,  20,  15,   5, -30,
+		-30,   0,  15,  20,  20,  15,   0, -30,
+		-30,   5,  10,  15,  15,  10,   5, -30,
+		-40, -20,   0,   5,   5,   0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	},
+	Bishop: {
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10,   0,   0,   0,   0,   0,   0, -10,
+		-10,   0,   5,  10,  10,   5,   0, -10,
+		-10,   5,   5,  10,  10,   5,   5, -10,
+		-10,   0,  10,  10,  10,  10,   0, -10,
+		-10,  10,  10,  10,  10,  10,  10, -10,
+		-10,   5,   0,   0,   0,   0,   5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	},
+	Queen: {
+		-20, -10, -10,  -5,  -5, -10, -10, -20,
+		-10,   0,   0,   0,   0,   0,   0, -10,
+		-10,   0,   5,   5,   5,   5,   0, -10,
+		 -5,   0,   5,   5,   5,   5,   0,  -5,
+		  0,   0,   5,   5,   5,   5,   0,  -5,
+		-10,   5,   5,   5,   5,   5,   0, -10,
+		-10,   0,   5,   0,   0,   0,   0, -10,
+		-20, -10, -10,  -5,  -5, -10, -10, -20,
+	},
+	King: {
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		 20,  20,   0,   0,   0,   0,  20,  20,
+		 20,  30,  10,   0,   0,  10,  30,  20,
+	},
+}
+
+// Returns the piece-square score of a piece of the given color and type on the square with the given index (0-63,
+// a1=0). Tables are written from White's side, so White's squares are flipped vertically and Black's are used as-is.
+func pieceSquareValue(color Color, pt PieceType, sq int) int {
+	if color == White {
+		sq ^= 56
+	}
+	return pieceSquareTables[pt][sq]
+}
+
+// Statically evaluates the board in centipawns from the point of view of the given color, so a positive score means
+// that color is ahead. The evaluation is material plus piece-square tables.
+func Evaluate(color Color, b Board) int {
+	score := 0
+	for c := range Colors {
+		c := Color(c)
+		sideScore := 0
+		for pt, pieces := range b.players[c].pieces {
+			for pieces != 0 {
+				sq := bits.TrailingZeros64(pieces)
+				pieces &= pieces - 1
+				sideScore += pieceValues[pt] + pieceSquareValue(c, PieceType(pt), sq)
+			}
+		}
+		if c == color {
+			score += sideScore
+		} else {
+			score -= sideScore
+		}
+	}
+	return score
+}
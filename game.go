@@ -23,6 +23,9 @@ var colorName = map[Color]string{
 func (c Color) String() string {
     return colorName[c]
 }
+func (c Color) Opponent() Color {
+	return Color(int(c + 1) % len(Colors))
+}
 // Returns (x, y) movement relative to self
 func (c Color) Forward() (int, int) {
 	switch c {
@@ -85,6 +88,24 @@ var pieceTypeName = map[PieceType]string{
 func (pt PieceType) String() string {
     return pieceTypeName[pt]
 }
+// Piece types a pawn can be promoted to, most valuable first.
+var promotionPieceTypes = []PieceType{
+	Queen,
+	Rook,
+	Bishop,
+	Knight,
+}
+var promotionRunes = map[rune]PieceType{
+	'q': Queen,
+	'r': Rook,
+	'b': Bishop,
+	'n': Knight,
+}
+// Returns the piece type a pawn is promoted to for the given rune (q, r, b or n), as typed after a move's coords.
+func PromotionPieceType(r rune) (PieceType, bool) {
+	pt, found := promotionRunes[r]
+	return pt, found
+}
 
 type Piece struct {
 	color Color
@@ -100,11 +121,13 @@ const (
 	None SpecialMove = iota
 	EnPassant
 	Castling
+	Promotion
 )
 var specialMoveName = map[SpecialMove]string{
 	None: "n/a",
     EnPassant: "en passant",
     Castling: "castling",
+    Promotion: "promotion",
 }
 func (sm SpecialMove) String() string {
 	return specialMoveName[sm]
@@ -158,6 +181,10 @@ func (cc CartesianCoord) AsCoord() Coord {
 func (cc CartesianCoord) AsBitCoord() BitCoord {
 	return BitCoord(0b1 << (cc.X+(8*cc.Y)))
 }
+// Index of the square in the range 0-63, matching the bit position of its BitCoord.
+func (cc CartesianCoord) index() int {
+	return cc.X + 8*cc.Y
+}
 
 type BitCoord uint64
 func (bc BitCoord) String() string {
@@ -214,6 +241,8 @@ func (bc BitCoord) To(x, y int) BitCoord {
 type Move struct {
 	piece Piece
 	dest CartesianCoord
+	// Type the pawn was promoted to, or Pawn if the move was not a promotion.
+	promotion PieceType
 }
 
 type ValidMove struct {
@@ -221,6 +250,11 @@ type ValidMove struct {
 	dest CartesianCoord
 	newBoard Board
 	specialMove SpecialMove
+	// Type the pawn is promoted to if specialMove is Promotion, otherwise Pawn.
+	promotion PieceType
+}
+func (m ValidMove) asMove() Move {
+	return Move{piece: m.piece, dest: m.dest, promotion: m.promotion}
 }
 
 type Game struct {
@@ -229,6 +263,16 @@ type Game struct {
 	validMoves map[Piece][]ValidMove
 	board Board
 	moves []Move
+	// FEN of the position the game started from, or empty for the standard starting position.
+	startFEN string
+	// Number of leading moves that were not played but set up from startFEN. See NewGameFromFEN.
+	setupMoves int
+	// Halfmoves since the last capture or pawn move, and the number of the current full move, as in FEN.
+	halfmoveClock int
+	fullmoveNumber int
+	// Zobrist hashes of the positions since the last capture or pawn move, the current one last, to find repetitions
+	// by. Only these positions can occur again.
+	positions []uint64
 }
 
 // Board is a struct with no pointers to ensure cloning is easy.
@@ -268,8 +312,10 @@ func NewGame() *Game {
 			},
 		},
 		moves: make([]Move, 0),
+		fullmoveNumber: 1,
 	}
 	game.validMoves = computeValidMoves(game.currentPlayer, game.board, game.moves, true)
+	game.positions = []uint64{zobristHash(game.currentPlayer, game.board, game.moves)}
 	return &game
 }
 
@@ -293,6 +339,95 @@ func getCheckThreats(color Color, b Board, gameMoves []Move) []Piece {
 	return threats
 }
 
+// Returns a bit table of every square occupied by a piece of either color.
+func (b Board) occupied() uint64 {
+	var occupied uint64
+	for _, player := range b.players {
+		for _, pieces := range player.pieces {
+			occupied |= pieces
+		}
+	}
+	return occupied
+}
+
+// Returns a bit table of every square occupied by a piece of the player.
+func (p Player) occupied() uint64 {
+	var occupied uint64
+	for _, pieces := range p.pieces {
+		occupied |= pieces
+	}
+	return occupied
+}
+
+var knightOffsets = [...][2]int{{1, -2}, {1, 2}, {2, -1}, {2, 1}, {-1, -2}, {-1, 2}, {-2, -1}, {-2, 1}}
+var kingOffsets = [...][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+var orthogonalOffsets = [...][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}}
+var diagonalOffsets = [...][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+
+// Returns a bit table of the pieces of both colors that could capture on the given square. This follows the same
+// capture rules as the computeValidMovesFor* functions, but works directly on the bit tables instead of generating
+// every move, which makes it cheap enough to call once per candidate move. Sliding pieces are blocked by the squares
+// in occupied, which callers can make differ from the board to see through pieces that have already captured.
+func attackersTo(cc CartesianCoord, b Board, occupied uint64) uint64 {
+	pos := cc.AsBitCoord()
+	var attackers uint64
+
+	// Pawns capture diagonally forward, so an attacking pawn sits diagonally backward (from its point of view).
+	for _, c := range Colors {
+		backX, backY := c.Backward()
+		leftX, leftY := c.Left()
+		rightX, rightY := c.Right()
+		pawns := b.players[c].pieces[Pawn]
+		attackers |= uint64(pos.To(backX+leftX, backY+leftY) | pos.To(backX+rightX, backY+rightY)) & pawns
+	}
+
+	var knights, kings, rooks, bishops uint64
+	for _, player := range b.players {
+		knights |= player.pieces[Knight]
+		kings |= player.pieces[King]
+		rooks |= player.pieces[Rook] | player.pieces[Queen]
+		bishops |= player.pieces[Bishop] | player.pieces[Queen]
+	}
+	for _, o := range knightOffsets {
+		attackers |= uint64(pos.To(o[0], o[1])) & knights
+	}
+	for _, o := range kingOffsets {
+		attackers |= uint64(pos.To(o[0], o[1])) & kings
+	}
+	for _, o := range orthogonalOffsets {
+		attackers |= firstOccupied(pos, o[0], o[1], occupied) & rooks
+	}
+	for _, o := range diagonalOffsets {
+		attackers |= firstOccupied(pos, o[0], o[1], occupied) & bishops
+	}
+	return attackers & occupied
+}
+
+// Walks from pos in the given direction and returns the bit of the first occupied square, or 0 if there is none.
+func firstOccupied(pos BitCoord, x int, y int, occupied uint64) uint64 {
+	for next := pos.To(x, y); next != 0; next = next.To(x, y) {
+		if uint64(next) & occupied != 0 {
+			return uint64(next)
+		}
+	}
+	return 0
+}
+
+// Reports whether any piece of the given color could capture on the given square.
+func isSquareAttacked(cc CartesianCoord, by Color, b Board) bool {
+	return attackersTo(cc, b, b.occupied()) & b.players[by].occupied() != 0
+}
+
+// Reports whether the king of the given color is attacked. Equivalent to checking getCheckThreats for a non-empty
+// result, without collecting the threatening pieces.
+func inCheck(color Color, b Board) bool {
+	king := BitCoord(b.players[color].pieces[King])
+	if !king.IsValid() {
+		return false
+	}
+	return isSquareAttacked(king.AsCartesianCoord(), color.Opponent(), b)
+}
+
 // Mutates game state to match the chosen move to execute. Returns a human readable representation of the move that was
 // executed, and whether it was executed or not.
 func (g *Game) ExecuteValidMove(move ValidMove) (string, bool) {
@@ -302,7 +437,7 @@ func (g *Game) ExecuteValidMove(move ValidMove) (string, bool) {
 	}
 	found = false
 	for _, m := range moves {
-		if m.dest == move.dest {
+		if m.dest == move.dest && m.promotion == move.promotion {
 			found = true
 			break
 		}
@@ -311,41 +446,128 @@ func (g *Game) ExecuteValidMove(move ValidMove) (string, bool) {
 		return "", false
 	}
 
-	g.moves = append(g.moves, Move{piece: move.piece, dest: move.dest})
+	g.moves = append(g.moves, move.asMove())
 	notes := ""
-	if origPiece, found := GetCoord(move.dest, g.board); found {
+	if origPiece, found := GetCoord(move.dest, g.board); found && move.specialMove == Promotion {
+		notes = fmt.Sprintf(" [cap %v, %v to %v]", origPiece.pieceType, move.specialMove, move.promotion)
+	} else if found {
 		notes = fmt.Sprintf(" [cap %v]", origPiece.pieceType)
+	} else if move.specialMove == Promotion {
+		notes = fmt.Sprintf(" [%v to %v]", move.specialMove, move.promotion)
 	} else if move.specialMove == EnPassant {
 		notes = fmt.Sprintf(" [%v cap %v]", move.specialMove, Pawn)
 	} else if move.specialMove != None {
 		notes = fmt.Sprintf(" [%v]", move.specialMove)
 	}
 	moveText := fmt.Sprintf("%v %v %v to %v%v", move.piece.color, move.piece.pieceType, move.piece.cc.AsCoord(), move.dest.AsCoord(), notes)
+	if move.piece.pieceType == Pawn || isCapture(move, g.board) {
+		g.halfmoveClock = 0
+	} else {
+		g.halfmoveClock++
+	}
+	if g.currentPlayer == Black {
+		g.fullmoveNumber++
+	}
 	g.board = move.newBoard
-	g.currentPlayer = Color(int(g.currentPlayer + 1) % len(Colors))
-	threats := getCheckThreats(g.currentPlayer, g.board, g.moves)
+	g.currentPlayer = g.currentPlayer.Opponent()
 	g.validMoves = computeValidMoves(g.currentPlayer, g.board, g.moves, true)
-
-	inCheck, noMoves := false, true
-	if len(threats) > 0 {
-		inCheck = true
+	g.currentPlayerStatus = statusOf(g.currentPlayer, g.board, g.validMoves)
+	if g.halfmoveClock == 0 {
+		// a new slice, since clones of the game share the old one
+		g.positions = nil
 	}
-	for _, pieceMoves := range g.validMoves {
+	g.positions = append(g.positions, zobristHash(g.currentPlayer, g.board, g.moves))
+	return moveText, true
+}
+
+// Describes whether the given color is in check, checkmated or stalemated.
+func statusOf(color Color, b Board, validMoves map[Piece][]ValidMove) string {
+	inCheck, noMoves := inCheck(color, b), true
+	for _, pieceMoves := range validMoves {
 		if len(pieceMoves) > 0 {
 			noMoves = false
 			break
 		}
 	}
 	if inCheck && noMoves {
-		g.currentPlayerStatus = "CHECKMATE"
+		return "CHECKMATE"
 	} else if inCheck {
-		g.currentPlayerStatus = "CHECK"
+		return "CHECK"
 	} else if noMoves {
-		g.currentPlayerStatus = "DRAW"
-	} else {
-		g.currentPlayerStatus = ""
+		return "DRAW"
 	}
-	return moveText, true
+	return ""
+}
+
+// Returns the result of a finished game as written in PGN ("1-0", "0-1" or "1/2-1/2") and the reason it ended, or false
+// if the game goes on.
+func (g *Game) Result() (string, string, bool) {
+	switch {
+	case g.currentPlayerStatus == "CHECKMATE" && g.currentPlayer == Black:
+		return "1-0", "White mates", true
+	case g.currentPlayerStatus == "CHECKMATE":
+		return "0-1", "Black mates", true
+	case g.currentPlayerStatus == "DRAW":
+		return "1/2-1/2", "Stalemate", true
+	case g.halfmoveClock >= 100:
+		return "1/2-1/2", "50 move rule", true
+	case insufficientMaterial(g.board):
+		return "1/2-1/2", "Insufficient material", true
+	case g.repetitions() >= 3:
+		return "1/2-1/2", "Threefold repetition", true
+	}
+	return "", "", false
+}
+
+// Reports whether neither player has the material to mate: there are no pawns, rooks or queens, and at most one
+// knight or bishop.
+func insufficientMaterial(b Board) bool {
+	minors := 0
+	for _, player := range b.players {
+		if player.pieces[Pawn] | player.pieces[Rook] | player.pieces[Queen] != 0 {
+			return false
+		}
+		minors += bits.OnesCount64(player.pieces[Knight] | player.pieces[Bishop])
+	}
+	return minors <= 1
+}
+
+// Returns how many times the current position has occurred in the game, counting the current one.
+func (g *Game) repetitions() int {
+	if len(g.positions) == 0 {
+		return 0
+	}
+	current := g.positions[len(g.positions)-1]
+	count := 0
+	for _, hash := range g.positions {
+		if hash == current {
+			count++
+		}
+	}
+	return count
+}
+
+// Counts the leaf nodes of the tree of valid moves of the given depth from the current position. Comparing the counts
+// with published ones is the standard way of checking a move generator.
+func (g *Game) Perft(depth int) uint64 {
+	return perft(g.currentPlayer, g.board, slices.Clone(g.moves), depth)
+}
+
+func perft(color Color, b Board, gameMoves []Move, depth int) uint64 {
+	if depth <= 0 {
+		return 1
+	}
+	var nodes uint64
+	for _, pieceMoves := range computeValidMoves(color, b, gameMoves, true) {
+		if depth == 1 {
+			nodes += uint64(len(pieceMoves))
+			continue
+		}
+		for _, m := range pieceMoves {
+			nodes += perft(color.Opponent(), m.newBoard, append(gameMoves, m.asMove()), depth-1)
+		}
+	}
+	return nodes
 }
 
 // Takes in a Coord and returns a (Piece, bool). The Coord arg points to a position on the board. The Piece return value
@@ -413,7 +635,7 @@ func computeValidMoves(color Color, board Board, gameMoves []Move, removeIfIntoC
 		for piece, pieceMoves := range moves {
 			n := 0
 			for _, move := range pieceMoves {
-				if len(getCheckThreats(color, move.newBoard, gameMoves)) == 0 {
+				if !inCheck(color, move.newBoard) {
 					pieceMoves[n] = move
 					n++
 				}
@@ -444,6 +666,111 @@ func (g *Game) GetValidMovesForPiece(p Piece) []ValidMove {
 	return g.validMoves[p]
 }
 
+// Returns the legal moves of the given color as a flat list. computeValidMoves groups moves in a map, so the moves are
+// sorted by origin and then destination square to keep the order stable between calls.
+func legalMoves(color Color, board Board, gameMoves []Move) []ValidMove {
+	moves := make([]ValidMove, 0, 48)
+	for _, pieceMoves := range computeValidMoves(color, board, gameMoves, true) {
+		moves = append(moves, pieceMoves...)
+	}
+	slices.SortFunc(moves, compareValidMoves)
+	return moves
+}
+
+func compareValidMoves(a, b ValidMove) int {
+	if a.piece.cc != b.piece.cc {
+		return a.piece.cc.index() - b.piece.cc.index()
+	}
+	if a.dest != b.dest {
+		return a.dest.index() - b.dest.index()
+	}
+	return int(a.promotion) - int(b.promotion)
+}
+
+// Returns the legal moves of the current player as a flat list in a stable order.
+func (g *Game) LegalMoves() []ValidMove {
+	moves := make([]ValidMove, 0, 48)
+	for _, pieceMoves := range g.validMoves {
+		moves = append(moves, pieceMoves...)
+	}
+	slices.SortFunc(moves, compareValidMoves)
+	return moves
+}
+
+// Looks up the valid move of the current player that moves the piece at from to dest. promotion is the type a pawn is
+// promoted to, and must be Pawn for moves that are not a promotion.
+func (g *Game) FindValidMove(from CartesianCoord, dest CartesianCoord, promotion PieceType) (ValidMove, bool) {
+	piece, found := GetCoord(from, g.board)
+	if !found || piece.color != g.currentPlayer {
+		return ValidMove{}, false
+	}
+	for _, m := range g.validMoves[piece] {
+		if m.dest == dest && m.promotion == promotion {
+			return m, true
+		}
+	}
+	return ValidMove{}, false
+}
+
+// Takes back the last move. The game is rebuilt by replaying every earlier move, since moves do not record enough to
+// be reversed in place. Returns the human readable text of each replayed move, and false if there was nothing to undo.
+func (g *Game) Undo() ([]string, bool) {
+	if len(g.moves) == g.setupMoves {
+		return nil, false
+	}
+	replayed, moveTexts := g.replay(len(g.moves)-g.setupMoves-1, nil)
+	*g = *replayed
+	return moveTexts, true
+}
+
+// Returns a new game in the position the game started from, before any of its moves.
+func (g *Game) initialGame() *Game {
+	if g.startFEN == "" {
+		return NewGame()
+	}
+	initial, err := NewGameFromFEN(g.startFEN)
+	if err != nil {
+		panic(fmt.Sprintf("FEN %q the game started from no longer parses: %v", g.startFEN, err))
+	}
+	return initial
+}
+
+// Returns a new game with only the first n moves of the game played, not counting the moves that set up the starting
+// position.
+func (g *Game) replayTo(n int) *Game {
+	replayed, _ := g.replay(n, nil)
+	return replayed
+}
+
+// Plays the first n moves of the game, not counting the moves that set up the starting position, on a new game from
+// the starting position. onMove, if not nil, is called with each move just before it is played on the new game.
+// Returns the new game and the human readable text of each move.
+func (g *Game) replay(n int, onMove func(replayed *Game, vm ValidMove)) (*Game, []string) {
+	replayed := g.initialGame()
+	moveTexts := make([]string, 0, n)
+	for _, m := range g.moves[g.setupMoves:g.setupMoves+n] {
+		vm, found := replayed.FindValidMove(m.piece.cc, m.dest, m.promotion)
+		if !found {
+			panic(fmt.Sprintf("move %+v from game history is not valid on replay", m))
+		}
+		if onMove != nil {
+			onMove(replayed, vm)
+		}
+		moveText, _ := replayed.ExecuteValidMove(vm)
+		moveTexts = append(moveTexts, moveText)
+	}
+	return replayed, moveTexts
+}
+
+// Returns a copy of the game that can be played on, e.g. by another goroutine, without affecting the original. The
+// valid moves are shared, which is safe because executing a move replaces them rather than changing them.
+func (g *Game) Clone() *Game {
+	clone := *g
+	clone.moves = slices.Clone(g.moves)
+	clone.positions = slices.Clone(g.positions)
+	return &clone
+}
+
 func checkDirection (p Piece, b Board, x int, y int, onlyOne bool, requiresCapture bool, requiresMove bool) (moves []ValidMove) {
 	pos := p.cc.AsBitCoord()
 	moves = make([]ValidMove, 0)
@@ -492,19 +819,11 @@ func checkDirection (p Piece, b Board, x int, y int, onlyOne bool, requiresCaptu
 
 func checkEnPassant(p Piece, b Board, gameMoves []Move) (moves []ValidMove) {
 	moves = make([]ValidMove, 0)
-	pieceMoves := movesOfPiece(p, gameMoves)
-	if len(pieceMoves) != 3 {
-		// has not moved 3 times exactly
-		return
+	fifthRank := 4
+	if p.color == Black {
+		fifthRank = 3
 	}
-	if slices.ContainsFunc(pieceMoves, func (m Move) bool {
-		delta := m.dest.Y - m.piece.cc.Y
-		if delta < 0 {
-			delta = 0 - delta
-		}
-		return delta > 1
-	}) {
-		// has moved 2 squares at once
+	if p.cc.Y != fifthRank || len(gameMoves) == 0 {
 		return
 	}
 
@@ -542,6 +861,15 @@ func checkCastle(p Piece, b Board, gameMoves []Move, dirX int, dirY int) (moves
 	if len(pieceMoves) != 0 {
 		return
 	}
+	// Only a king and rook on their starting squares can castle, which matters for positions not reached from the
+	// standard starting position.
+	homeY := 0
+	if p.color == Black {
+		homeY = 7
+	}
+	if p.cc != (CartesianCoord{4, homeY}) {
+		return
+	}
 
 	pos := p.cc.AsBitCoord()
 	var next BitCoord = pos.To(dirX, dirY)
@@ -551,10 +879,17 @@ func checkCastle(p Piece, b Board, gameMoves []Move, dirX int, dirY int) (moves
 		}
 		pairPiece, found := GetCoord(next.AsCartesianCoord(), b)
 		if found {
-			if pairPiece.pieceType == Rook && len(movesOfPiece(pairPiece, gameMoves)) == 0 {
+			if pairPiece.color == p.color && pairPiece.pieceType == Rook && (pairPiece.cc.X == 0 || pairPiece.cc.X == 7) &&
+				len(movesOfPiece(pairPiece, gameMoves)) == 0 {
 				pairPiecePos := pairPiece.cc.AsBitCoord()
 				pairPieceDest := p.cc.AsBitCoord().To(dirX, dirY)
 				dest := p.cc.AsBitCoord().To(dirX*2, dirY*2)
+				// The king may not castle out of, through, or into check.
+				opponent := p.color.Opponent()
+				if isSquareAttacked(p.cc, opponent, b) || isSquareAttacked(pairPieceDest.AsCartesianCoord(), opponent, b) ||
+					isSquareAttacked(dest.AsCartesianCoord(), opponent, b) {
+					break
+				}
 				m := ValidMove{
 					piece: p,
 					dest: dest.AsCartesianCoord(),
@@ -574,21 +909,54 @@ func checkCastle(p Piece, b Board, gameMoves []Move, dirX int, dirY int) (moves
 }
 
 func computeValidMovesForPawn(p Piece, b Board, gameMoves []Move) []ValidMove {
-	// TODO: Pawn promotion. Needs to change the ValidMove struct to allow for specifying the piece type to promote to,
-	// and updating the UI logic to allow for it.
 	moves := make([]ValidMove, 0)
 
 	forwardX, forwardY := p.color.Forward() // 0,1
 	leftX, leftY := p.color.Left() // -1,0
 	rightX, rightY := p.color.Right() // 1,0
-	moves = append(moves, checkDirection(p, b, forwardX, forwardY, true, false, true)...)
-	if !hasPieceMoved(p, gameMoves) {
+	forward := checkDirection(p, b, forwardX, forwardY, true, false, true)
+	moves = append(moves, forward...)
+	startRank := 1
+	if p.color == Black {
+		startRank = 6
+	}
+	if p.cc.Y == startRank && len(forward) > 0 {
+		// a pawn that has not moved yet may advance two squares, if both are empty
 		moves = append(moves, checkDirection(p, b, forwardX*2, forwardY*2, true, false, true)...)
 	}
 	moves = append(moves, checkDirection(p, b, leftX+forwardX, leftY+forwardY, true, true, false)...)
 	moves = append(moves, checkDirection(p, b, rightX+forwardX, rightY+forwardY, true, true, false)...)
 	moves = append(moves, checkEnPassant(p, b, gameMoves)...)
-	return moves
+	return checkPromotion(p, moves)
+}
+
+// Replaces each move of the pawn onto the last rank with one move per piece type the pawn can be promoted to.
+func checkPromotion(p Piece, moves []ValidMove) []ValidMove {
+	lastRank := 7
+	if p.color == Black {
+		lastRank = 0
+	}
+	if !slices.ContainsFunc(moves, func (m ValidMove) bool { return m.dest.Y == lastRank }) {
+		return moves
+	}
+
+	result := make([]ValidMove, 0, len(moves) + 3*len(promotionPieceTypes))
+	for _, m := range moves {
+		if m.dest.Y != lastRank {
+			result = append(result, m)
+			continue
+		}
+		dest := uint64(m.dest.AsBitCoord())
+		for _, pt := range promotionPieceTypes {
+			pm := m
+			pm.specialMove = Promotion
+			pm.promotion = pt
+			pm.newBoard.players[p.color].pieces[Pawn] &^= dest
+			pm.newBoard.players[p.color].pieces[pt] |= dest
+			result = append(result, pm)
+		}
+	}
+	return result
 }
 
 func computeValidMovesForRook(p Piece, b Board) []ValidMove {
@@ -641,9 +1009,109 @@ func computeValidMovesForKing(p Piece, b Board, gameMoves []Move) []ValidMove {
 	moves = append(moves, checkDirection(p, b, -1, 0, true, false, false)...)
 	moves = append(moves, checkDirection(p, b, 0, 1, true, false, false)...)
 	moves = append(moves, checkDirection(p, b, 0, -1, true, false, false)...)
+	moves = append(moves, checkDirection(p, b, 1, 1, true, false, false)...)
+	moves = append(moves, checkDirection(p, b, 1, -1, true, false, false)...)
+	moves = append(moves, checkDirection(p, b, -1, 1, true, false, false)...)
+	moves = append(moves, checkDirection(p, b, -1, -1, true, false, false)...)
 	leftX, leftY := p.color.Left()
 	rightX, rightY := p.color.Right()
 	moves = append(moves, checkCastle(p, b, gameMoves, leftX, leftY)...)
 	moves = append(moves, checkCastle(p, b, gameMoves, rightX, rightY)...)
 	return moves
 }
+
+// Value of each PieceType when exchanging pieces. The king is worth more than everything else combined, so an
+// exchange never ends with a king capturing onto a square that is still defended.
+var exchangeValues = [...]int{
+	Pawn: 100,
+	Rook: 500,
+	Knight: 320,
+	Bishop: 330,
+	Queen: 900,
+	King: 10000,
+}
+
+// Returns the expected material gain in centipawns for the moving player of the move, assuming both players keep
+// capturing on the destination square with their least valuable piece for as long as it pays off. A negative result
+// means the move loses material. Pins and checks are ignored.
+func (g *Game) StaticExchange(m ValidMove) int {
+	return staticExchange(m, g.board)
+}
+
+// Reports whether the move leaves one of the current player's pieces where the opponent can win material by capturing
+// it, and returns the most valuable such piece.
+func (g *Game) HangingPiece(m ValidMove) (Piece, bool) {
+	color := m.piece.color
+	b := m.newBoard
+	var hanging Piece
+	found := false
+	for pt, pieces := range b.players[color].pieces {
+		if PieceType(pt) == King {
+			continue
+		}
+		for pieces != 0 {
+			sq := BitCoord(pieces & -pieces)
+			pieces &^= uint64(sq)
+			cc := sq.AsCartesianCoord()
+			if exchangeOnSquare(cc, color.Opponent(), b, b.occupied()) <= 0 {
+				continue
+			}
+			if !found || exchangeValues[pt] > exchangeValues[hanging.pieceType] {
+				hanging = Piece{color, PieceType(pt), cc}
+				found = true
+			}
+		}
+	}
+	return hanging, found
+}
+
+func staticExchange(m ValidMove, b Board) int {
+	occupied := b.occupied()
+	captured := 0
+	if victim, found := GetCoord(m.dest, b); found {
+		captured = exchangeValues[victim.pieceType]
+	} else if m.specialMove == EnPassant {
+		captured = exchangeValues[Pawn]
+		backX, backY := m.piece.color.Backward()
+		occupied &^= uint64(m.dest.AsBitCoord().To(backX, backY))
+	}
+	moved := m.piece.pieceType
+	if m.specialMove == Promotion {
+		captured += exchangeValues[m.promotion] - exchangeValues[Pawn]
+		moved = m.promotion
+	}
+	occupied &^= uint64(m.piece.cc.AsBitCoord())
+	return captured - exchangeAfter(m.dest, m.piece.color.Opponent(), b, occupied, exchangeValues[moved])
+}
+
+// Returns the material the given color wins by starting an exchange on the square, or 0 if it has no capture there or
+// would lose material by capturing.
+func exchangeOnSquare(cc CartesianCoord, color Color, b Board, occupied uint64) int {
+	target, found := GetCoord(cc, b)
+	if !found {
+		return 0
+	}
+	return exchangeAfter(cc, color, b, occupied, exchangeValues[target.pieceType])
+}
+
+// Returns what color can gain by recapturing on the square, where a piece worth targetValue now stands. color may
+// decline to recapture, so the result is never negative.
+func exchangeAfter(cc CartesianCoord, color Color, b Board, occupied uint64, targetValue int) int {
+	attackers := attackersTo(cc, b, occupied) & b.players[color].occupied()
+	if attackers == 0 {
+		return 0
+	}
+	// Recapture with the least valuable attacker.
+	var attacker uint64
+	var attackerType PieceType
+	for _, pt := range []PieceType{Pawn, Knight, Bishop, Rook, Queen, King} {
+		if pieces := attackers & b.players[color].pieces[pt]; pieces != 0 {
+			attacker = pieces & -pieces
+			attackerType = pt
+			break
+		}
+	}
+	occupied &^= attacker
+	gain := targetValue - exchangeAfter(cc, color.Opponent(), b, occupied, exchangeValues[attackerType])
+	return max(0, gain)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"testing"
 	"slices"
 )
@@ -451,3 +452,293 @@ func TestPawnMoves (t *testing.T) {
 		})
 	}
 }
+
+func TestPerft(t *testing.T) {
+	// Published node counts, see https://www.chessprogramming.org/Perft_Results
+	var tests = []struct{
+		name string
+		fen string
+		depth int
+		want uint64
+	}{
+		{"start depth 1", startFEN, 1, 20},
+		{"start depth 2", startFEN, 2, 400},
+		{"start depth 3", startFEN, 3, 8902},
+		{"kiwipete depth 1", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", 1, 48},
+		{"kiwipete depth 2", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", 2, 2039},
+		{"position 3 depth 3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", 3, 2812},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			if got := g.Perft(tt.depth); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInCheck(t *testing.T) {
+	// inCheck must agree with getCheckThreats, which derives checks from the full move generators. Compare both on
+	// positions from pseudo-random games.
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range 10 {
+		t.Run(fmt.Sprintf("game %v", i), func(t *testing.T) {
+			g := NewGame()
+			for range 60 {
+				for _, c := range Colors {
+					want := len(getCheckThreats(c, g.board, g.moves)) > 0
+					if got := inCheck(c, g.board); got != want {
+						t.Fatalf("inCheck(%v) got %v, want %v after %+v", c, got, want, g.moves)
+					}
+				}
+				moves := g.LegalMoves()
+				if len(moves) == 0 {
+					break
+				}
+				g.ExecuteValidMove(moves[r.IntN(len(moves))])
+			}
+		})
+	}
+}
+
+func TestCastlingNeedsHomeSquares(t *testing.T) {
+	// Boards without a move history, such as those of the tablebases, count every piece as unmoved, so castling must
+	// also check where the pieces stand.
+	var tests = []struct{
+		name string
+		fen string
+	}{
+		{"king off e1", "4k3/8/8/8/8/8/8/RK6 w - - 0 1"},
+		{"rook off the corner", "4k3/8/8/8/8/8/8/1R2K3 w - - 0 1"},
+	}
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("%v: NewGameFromFEN returned error %v", tt.name, err)
+		}
+		for _, moves := range computeValidMoves(White, g.board, nil, true) {
+			for _, m := range moves {
+				if m.specialMove == Castling {
+					t.Errorf("%v: got castling move %v", tt.name, m.asMove().CoordNotation())
+				}
+			}
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	g := NewGame()
+	if _, ok := g.Undo(); ok {
+		t.Errorf("Undo of a new game got ok")
+	}
+
+	playMoves(t, g, "e2e4", "e7e5", "g1f3")
+	moveTexts, ok := g.Undo()
+	if !ok {
+		t.Fatalf("Undo got not ok")
+	}
+	wantTexts := []string{"White pawn e2 to e4", "Black pawn e7 to e5"}
+	if !slices.Equal(moveTexts, wantTexts) {
+		t.Errorf("move texts got %v, want %v", moveTexts, wantTexts)
+	}
+	if g.currentPlayer != White {
+		t.Errorf("current player got %v, want %v", g.currentPlayer, White)
+	}
+	if _, found := GetCoord(Coord("g1").AsCartesianCoord(), g.board); !found {
+		t.Errorf("knight not back on g1")
+	}
+	if len(g.moves) != 2 {
+		t.Errorf("moves got %v, want 2", len(g.moves))
+	}
+}
+
+// Plays moves given as origin and destination coords and an optional promotion, e.g. "e2e4" or "e7e8q", failing the
+// test if one is not valid.
+func playMoves(t *testing.T, g *Game, moves ...string) {
+	t.Helper()
+	for _, m := range moves {
+		promotion := Pawn
+		if len(m) == 5 {
+			promotion, _ = PromotionPieceType(rune(m[4]))
+		}
+		vm, found := g.FindValidMove(Coord(m[0:2]).AsCartesianCoord(), Coord(m[2:4]).AsCartesianCoord(), promotion)
+		if !found {
+			t.Fatalf("move %v not found", m)
+		}
+		g.ExecuteValidMove(vm)
+	}
+}
+
+func TestResult(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		moves []string
+		wantResult string
+		wantReason string
+		wantOver bool
+	}{
+		{"game goes on", startFEN, []string{"e2e4"}, "", "", false},
+		{"mate", startFEN, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, "0-1", "Black mates", true},
+		{"stalemate", "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", nil, "1/2-1/2", "Stalemate", true},
+		{"50 move rule", "7k/8/8/8/8/8/8/R6K w - - 100 80", nil, "1/2-1/2", "50 move rule", true},
+		{"insufficient material", "7k/8/8/8/8/8/8/5B1K w - - 0 1", nil, "1/2-1/2", "Insufficient material", true},
+		{"insufficient material after a capture", "7k/8/8/8/8/8/6q1/6NK w - - 0 1", []string{"h1g2"}, "1/2-1/2", "Insufficient material", true},
+		{"position twice", startFEN, []string{"g1f3", "g8f6", "f3g1", "f6g8"}, "", "", false},
+		{
+			"threefold repetition", startFEN, []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"},
+			"1/2-1/2", "Threefold repetition", true,
+		},
+		{
+			"threefold repetition after a pawn move", startFEN,
+			[]string{"e2e4", "g8f6", "g1f3", "f6g8", "f3g1", "g8f6", "g1f3", "f6g8", "f3g1"},
+			"1/2-1/2", "Threefold repetition", true,
+		},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("%v: NewGameFromFEN returned error %v", tt.name, err)
+		}
+		playMoves(t, g, tt.moves...)
+		result, reason, over := g.Result()
+		if result != tt.wantResult || reason != tt.wantReason || over != tt.wantOver {
+			t.Errorf("%v: Result got %q, %q, %v, want %q, %q, %v", tt.name, result, reason, over, tt.wantResult,
+				tt.wantReason, tt.wantOver)
+		}
+	}
+}
+
+func TestInsufficientMaterial(t *testing.T) {
+	var tests = []struct{
+		fen string
+		want bool
+	}{
+		{"7k/8/8/8/8/8/8/7K w - - 0 1", true},
+		{"7k/8/8/8/8/8/8/5B1K w - - 0 1", true},
+		{"7k/8/8/8/8/8/8/5N1K w - - 0 1", true},
+		{"6nk/8/8/8/8/8/8/5N1K w - - 0 1", false},
+		{"7k/8/8/8/8/8/8/4BB1K w - - 0 1", false},
+		{"7k/8/8/8/8/8/7P/7K w - - 0 1", false},
+		{"7k/8/8/8/8/8/8/6RK w - - 0 1", false},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("NewGameFromFEN(%q) returned error %v", tt.fen, err)
+		}
+		if got := insufficientMaterial(g.board); got != tt.want {
+			t.Errorf("insufficientMaterial(%q) got %v, want %v", tt.fen, got, tt.want)
+		}
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	g := NewGame()
+	playMoves(t, g, "h2h4", "g7g5", "h4g5", "g8f6", "g5g6", "f6g8", "g6g7", "a7a6")
+	pawn, _ := GetCoord(Coord("g7").AsCartesianCoord(), g.board)
+	moves := g.GetValidMovesForPiece(pawn)
+
+	var got []PieceType
+	for _, m := range moves {
+		if m.dest != Coord("f8").AsCartesianCoord() && m.dest != Coord("h8").AsCartesianCoord() {
+			t.Errorf("unexpected move to %v", m.dest.AsCoord())
+		}
+		if m.specialMove != Promotion {
+			t.Errorf("move to %v got special move %v, want %v", m.dest.AsCoord(), m.specialMove, Promotion)
+		}
+		if m.dest == Coord("h8").AsCartesianCoord() {
+			got = append(got, m.promotion)
+		}
+	}
+	if !slices.Equal(got, promotionPieceTypes) {
+		t.Errorf("promotions got %v, want %v", got, promotionPieceTypes)
+	}
+
+	playMoves(t, g, "g7h8n")
+	p, _ := GetCoord(Coord("h8").AsCartesianCoord(), g.board)
+	if want := (Piece{White, Knight, Coord("h8").AsCartesianCoord()}); p != want {
+		t.Errorf("piece got %v, want %v", p, want)
+	}
+	if g.board.players[White].pieces[Pawn] & uint64(Coord("h8").AsCartesianCoord().AsBitCoord()) != 0 {
+		t.Errorf("pawn left on h8")
+	}
+	if g.moves[len(g.moves)-1].promotion != Knight {
+		t.Errorf("promotion not recorded in moves")
+	}
+}
+
+func TestStaticExchange(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		from Coord
+		dest Coord
+		promotion PieceType
+		want int
+	}{
+		{"undefended pawn", "6k1/8/8/3p4/8/8/8/3R2K1 w - - 0 1", "d1", "d5", Pawn, 100},
+		{"pawn defended by pawn", "6k1/8/4p3/3p4/8/8/8/3Q2K1 w - - 0 1", "d1", "d5", Pawn, -800},
+		{"equal trade after winning a pawn", "6k1/8/2n5/4p3/8/5N2/8/4R1K1 w - - 0 1", "f3", "e5", Pawn, 100},
+		{"x-ray through doubled rooks", "3r2k1/8/8/3p4/8/8/3R4/3R2K1 w - - 0 1", "d2", "d5", Pawn, 100},
+		{"promotion", "6k1/P7/8/8/8/8/8/6K1 w - - 0 1", "a7", "a8", Queen, 800},
+		{"quiet move to attacked square", "6k1/8/4p3/8/8/2N5/8/6K1 w - - 0 1", "c3", "d5", Pawn, -320},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			m, found := g.FindValidMove(tt.from.AsCartesianCoord(), tt.dest.AsCartesianCoord(), tt.promotion)
+			if !found {
+				t.Fatalf("move %v%v not found", tt.from, tt.dest)
+			}
+			if got := g.StaticExchange(m); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHangingPiece(t *testing.T) {
+	g, err := NewGameFromFEN("6k1/8/4p3/4p3/8/2N5/8/2B3K1 w - - 0 1")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	var tests = []struct{
+		from Coord
+		dest Coord
+		wantHanging bool
+		wantPiece Piece
+	}{
+		{"c3", "d5", true, Piece{White, Knight, Coord("d5").AsCartesianCoord()}},
+		{"c3", "b5", false, Piece{}},
+		{"c1", "f4", true, Piece{White, Bishop, Coord("f4").AsCartesianCoord()}},
+		{"c1", "g5", false, Piece{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from + tt.dest), func(t *testing.T) {
+			m, found := g.FindValidMove(tt.from.AsCartesianCoord(), tt.dest.AsCartesianCoord(), Pawn)
+			if !found {
+				t.Fatalf("move not found")
+			}
+			p, hanging := g.HangingPiece(m)
+			if hanging != tt.wantHanging {
+				t.Fatalf("hanging got %v, want %v", hanging, tt.wantHanging)
+			}
+			if hanging && p != tt.wantPiece {
+				t.Errorf("piece got %v, want %v", p, tt.wantPiece)
+			}
+		})
+	}
+}
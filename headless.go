@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+var errInputEnded = errors.New("input ended before the game did")
+
+// LineController plays the moves a human types, one per line, in coordinate notation or SAN. Lines that are not a
+// valid move are answered with the reason on out, and the next line is read.
+type LineController struct {
+	scanner *bufio.Scanner
+	out io.Writer
+}
+
+func NewLineController(in io.Reader, out io.Writer) *LineController {
+	return &LineController{scanner: bufio.NewScanner(in), out: out}
+}
+
+// Reads lines until one is a valid move. A read in progress is not interrupted by ctx, which is only checked between
+// lines.
+func (l *LineController) NextMove(ctx context.Context, g *Game) (ValidMove, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return ValidMove{}, err
+		}
+		if !l.scanner.Scan() {
+			if err := l.scanner.Err(); err != nil {
+				return ValidMove{}, err
+			}
+			return ValidMove{}, errInputEnded
+		}
+		text := strings.TrimSpace(l.scanner.Text())
+		if text == "" {
+			continue
+		}
+		m, err := parseMoveText(g, text)
+		if err != nil {
+			fmt.Fprintf(l.out, "%q: %v, try again\n", text, err)
+			continue
+		}
+		return m, nil
+	}
+}
+
+// Plays the game to its end with PlayGame, without a terminal UI: the board is written to out as text before every
+// move, and each move and finally the result as lines, so that a game can be played or scripted over pipes.
+func RunHeadless(ctx context.Context, g *Game, controllers [2]Controller, out io.Writer) error {
+	// The game before each move, to write the move in SAN.
+	before := g.Clone()
+	io.WriteString(out, BoardText(g))
+	err := PlayGame(ctx, g, controllers, func(m ValidMove, text string) {
+		if before.currentPlayer == White {
+			fmt.Fprintf(out, "%v. %v\n", before.fullmoveNumber, before.SAN(m))
+		} else {
+			fmt.Fprintf(out, "%v... %v\n", before.fullmoveNumber, before.SAN(m))
+		}
+		before.ExecuteValidMove(m)
+		io.WriteString(out, BoardText(g))
+	})
+	if err != nil {
+		return err
+	}
+	result, reason, _ := g.Result()
+	_, err = fmt.Fprintf(out, "%v {%v}\n", result, reason)
+	return err
+}
+
+// Draws the board of the game as text, White at the bottom, with a piece letter as in FEN on each occupied square and
+// a dot on each empty one, followed by a line telling who is to move and whether they are in check.
+func BoardText(g *Game) string {
+	var sb strings.Builder
+	for y := 7; y >= 0; y-- {
+		fmt.Fprintf(&sb, "%v", y + 1)
+		for x := range 8 {
+			r := '.'
+			if p, occupied := GetCoord(CartesianCoord{x, y}, g.board); occupied {
+				r = pieceTypeFENRunes[p.pieceType]
+				if p.color == White {
+					r = unicode.ToUpper(r)
+				}
+			}
+			sb.WriteRune(' ')
+			sb.WriteRune(r)
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("  a b c d e f g h\n")
+	if _, _, over := g.Result(); over {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%v to move", g.currentPlayer)
+	if g.currentPlayerStatus == "CHECK" {
+		sb.WriteString(", in check")
+	}
+	sb.WriteByte('\n')
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunHeadless(t *testing.T) {
+	var tests = []struct{
+		name string
+		// The position to start from, or "" for the standard starting position.
+		fen string
+		input string
+		// The engine plays Black with these moves if given, otherwise the input plays both sides.
+		engineMoves []string
+		wantOutput []string
+		wantErr error
+	}{
+		{
+			"human vs human", "", "e2e4\ne5\n\nxx\nQh5\nNc6\nBc4\nNf6\nQxf7\n", nil,
+			[]string{"\"xx\": not a move in SAN, try again\n", "2... Nc6\n", "4. Qxf7#\n", "1-0 {White mates}\n"},
+			nil,
+		},
+		{
+			"human vs engine", "", "f3\ng2g4\n", []string{"e7e5", "d8h4"},
+			[]string{"1... e5\n", "2... Qh4#\n", "0-1 {Black mates}\n"},
+			nil,
+		},
+		{
+			"check", "", "e4\nf6\nQh5\n", nil,
+			[]string{"2. Qh5+\n", "Black to move, in check\n"},
+			errInputEnded,
+		},
+		{
+			"from FEN", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 12", "e7e5\ng1f3\n", nil,
+			[]string{"12... e5\n", "13. Nf3\n"},
+			errInputEnded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			human := NewLineController(strings.NewReader(tt.input), &out)
+			controllers := [2]Controller{human, human}
+			if tt.engineMoves != nil {
+				controllers[Black] = NewEngineController(&scriptedSearcher{moves: tt.engineMoves}, SearchLimits{})
+			}
+			g := NewGame()
+			if tt.fen != "" {
+				var err error
+				g, err = NewGameFromFEN(tt.fen)
+				if err != nil {
+					t.Fatalf("NewGameFromFEN returned error %v", err)
+				}
+			}
+			err := RunHeadless(context.Background(), g, controllers, &out)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunHeadless returned error %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output does not contain %q:\n%v", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBoardText(t *testing.T) {
+	want := "8 r n b q k b n r\n" +
+		"7 p p p p p p p p\n" +
+		"6 . . . . . . . .\n" +
+		"5 . . . . . . . .\n" +
+		"4 . . . . . . . .\n" +
+		"3 . . . . . . . .\n" +
+		"2 P P P P P P P P\n" +
+		"1 R N B Q K B N R\n" +
+		"  a b c d e f g h\n" +
+		"White to move\n"
+	if got := BoardText(NewGame()); got != want {
+		t.Errorf("BoardText got\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Where the program logs to, how much, and in which format.
+type LogOptions struct {
+	// "none" to discard the log, "stderr", or the path of a file to append to.
+	destination string
+	level slog.Level
+	// "text" for key=value pairs, or "json" for a JSON object per line.
+	format string
+}
+
+// Returns a logger as the options say, and the file it writes to, which must be closed, or nil if it writes to none.
+func OpenLog(options LogOptions) (*slog.Logger, io.Closer, error) {
+	var w io.Writer
+	var f *os.File
+	switch options.destination {
+	case "none", "":
+		return slog.New(slog.DiscardHandler), nil, nil
+	case "stderr":
+		w = os.Stderr
+	default:
+		var err error
+		f, err = os.OpenFile(options.destination, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = f
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: options.level}
+	var handler slog.Handler
+	switch options.format {
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOptions)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		if f != nil {
+			f.Close()
+		}
+		return nil, nil, fmt.Errorf("unknown log format %q, want text or json", options.format)
+	}
+	if f == nil {
+		return slog.New(handler), nil, nil
+	}
+	return slog.New(handler), f, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenLog(t *testing.T) {
+	var tests = []struct{
+		format string
+		level slog.Level
+		wantLines int
+	}{
+		{"text", slog.LevelInfo, 2},
+		{"json", slog.LevelDebug, 3},
+		{"json", slog.LevelError, 0},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "log")
+		logger, f, err := OpenLog(LogOptions{destination: path, level: tt.level, format: tt.format})
+		if err != nil {
+			t.Fatalf("OpenLog returned error %v", err)
+		}
+		logger.Debug("layout updated", "width", 80)
+		logger.Info("move played", "move", "e2e4", "fen", startFEN)
+		logger.Warn("dropping move")
+		f.Close()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading log returned error %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(data) == 0 {
+			lines = nil
+		}
+		if len(lines) != tt.wantLines {
+			t.Errorf("%v log at %v got %v lines, want %v:\n%s", tt.format, tt.level, len(lines), tt.wantLines, data)
+			continue
+		}
+		for _, line := range lines {
+			if tt.format == "json" {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Errorf("json log line %q does not parse: %v", line, err)
+				}
+			} else if !strings.Contains(line, "msg=") {
+				t.Errorf("text log line %q has no msg", line)
+			}
+		}
+		if tt.wantLines > 0 && !strings.Contains(string(data), "e2e4") {
+			t.Errorf("log does not contain the move field:\n%s", data)
+		}
+	}
+
+	if logger, f, err := OpenLog(LogOptions{destination: "none"}); err != nil || f != nil || logger.Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("OpenLog of none got a logger that logs")
+	}
+	if _, _, err := OpenLog(LogOptions{destination: "stderr", format: "xml"}); err == nil {
+		t.Errorf("OpenLog of an unknown format got no error")
+	}
+}
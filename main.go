@@ -1,6 +1,572 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings given before the subcommand, which apply to all of them.
+type globalOptions struct {
+	log LogOptions
+	theme string
+	bookPath string
+	tablebaseDir string
+	threads int
+}
+
 func main() {
+	var global globalOptions
+	flag.StringVar(&global.log.destination, "log", "none", "where to log: none, stderr, which the full-screen UI draws over, or a file to append to")
+	flag.TextVar(&global.log.level, "log-level", slog.LevelInfo, "least severe level to log: debug, info, warn or error")
+	flag.StringVar(&global.log.format, "log-format", "text", "format of the log: text or json")
+	flag.StringVar(&global.theme, "theme", defaultTheme, "colors of the board in the full-screen UI: " + strings.Join(ThemeNames(), ", "))
+	flag.StringVar(&global.bookPath, "book", "", "path of a Polyglot opening book for the built-in engine")
+	flag.StringVar(&global.tablebaseDir, "tablebases", "", "directory of endgame tablebases for the built-in engine, or to write them to with tbgen")
+	flag.IntVar(&global.threads, "threads", 1, fmt.Sprintf("number of goroutines the built-in engine searches on, up to %v", maxThreads))
+	flag.Usage = usage
+	flag.Parse()
+
+	logger, logFile, err := OpenLog(global.log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	command, args := "play", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "play":
+		err = runPlay(args, global)
+	case "perft":
+		err = runPerft(args)
+	case "analyze":
+		err = runAnalyze(args, global.tablebaseDir, global.threads)
+	case "convert":
+		err = runConvert(args)
+	case "uci", "xboard":
+		err = runProtocol(command, global)
+	case "match":
+		err = runMatch(args, global.tablebaseDir, global.threads)
+	case "epd":
+		err = runEPDSuites(args, global.tablebaseDir, global.threads)
+	case "tbgen":
+		err = generateTablebases(global.tablebaseDir, args)
+	default:
+		err = fmt.Errorf("unknown subcommand %q, see -help", command)
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Describes the subcommands and the global flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: %v [global flags] [subcommand [flags] [args]]
+
+Subcommands:
+  play      play a game in the full-screen UI, or as text with -headless (the default)
+  perft     count the positions each depth of valid moves reaches
+  analyze   annotate a PGN game with the engine's verdicts, or show the best lines of a position
+  convert   convert a game between FEN, PGN, SAN and coordinate notation
+  uci       run as a UCI engine
+  xboard    run as an xboard engine
+  match     play a match between two engines
+  epd       run the engine on EPD test suites
+  tbgen     generate endgame tablebases
+
+Run a subcommand with -help for its flags. Global flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Plays a game between the players given by the flags of the play subcommand in args, in the full-screen UI or, with
+// -headless, as text on stdin and stdout.
+func runPlay(args []string, global globalOptions) error {
+	flags := flag.NewFlagSet("play", flag.ExitOnError)
+	fen := flags.String("fen", "", "position to start from, instead of the standard one")
+	pgnPath := flags.String("pgn", "", "PGN file of a game to play on from")
+	white := flags.String("white", "human", "who plays White: human, random, listen=address, dial=address, or an engine: builtin, skill=N, elo=N or uci=path")
+	black := flags.String("black", "human", "who plays Black, as for -white")
+	moveTime := flags.Duration("time", engineMoveTime, "time engines think about each move for")
+	headless := flags.Bool("headless", false, "play on stdin and stdout with the board as text, instead of in the full-screen UI")
+	enginePath := flags.String("engine", "", "path of a UCI engine to offer as an opponent in the full-screen UI")
+	skillLevel := flags.Int("skill", maxSkillLevel, fmt.Sprintf("strength from 1 to %v of the built-in engine the full-screen UI plays builtin and toggles on with", maxSkillLevel))
+	elo := flags.Int("elo", 0, fmt.Sprintf("rating from %v to %v to set the built-in engine's strength by, instead of -skill", minSkillElo, maxSkillElo))
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return fmt.Errorf("play takes no arguments, only flags, not %q", flags.Args())
+	}
+
 	game := NewGame()
-	Start(game)
+	var err error
+	switch {
+	case *fen != "" && *pgnPath != "":
+		return fmt.Errorf("play takes -fen or -pgn, not both")
+	case *fen != "":
+		game, err = NewGameFromFEN(*fen)
+	case *pgnPath != "":
+		game, err = loadGame(*pgnPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	var tablebases *Tablebases
+	if global.tablebaseDir != "" {
+		tablebases, err = LoadTablebases(global.tablebaseDir)
+		if err != nil {
+			return err
+		}
+	}
+	specs := [2]string{*white, *black}
+	if *headless {
+		return runHeadless(game, specs, *moveTime, global.threads, tablebases)
+	}
+
+	if _, found := themes[global.theme]; !found {
+		return fmt.Errorf("unknown theme %q, want one of %v", global.theme, strings.Join(ThemeNames(), ", "))
+	}
+	var book *OpeningBook
+	if global.bookPath != "" {
+		book, err = LoadOpeningBook(global.bookPath)
+		if err != nil {
+			return err
+		}
+	}
+	options := UIOptions{
+		engine: EngineOptions{skillLevel: *skillLevel, threads: global.threads},
+		moveTime: *moveTime,
+		theme: global.theme,
+		logger: slog.Default(),
+	}
+	if *elo > 0 {
+		options.engine.skillLevel = SkillLevelForElo(*elo)
+	}
+	var opponents []Searcher
+	if *enginePath != "" {
+		external, err := StartUCIEngine(*enginePath)
+		if err != nil {
+			return err
+		}
+		defer external.Close()
+		opponents = append(opponents, external)
+	}
+
+	// builtin plays with the UI's own engine, which is set up by -skill or -elo, uses the book, and is the one toggled
+	// on and off. Every other player is set up as in a headless game or match.
+	options.human = NewHumanController()
+	for color, spec := range specs {
+		if spec == "builtin" {
+			continue
+		}
+		controller, err := NewController(spec, options.human, SearchLimits{moveTime: *moveTime}, global.threads, tablebases)
+		if err != nil {
+			return err
+		}
+		if closer, ok := controller.(io.Closer); ok {
+			defer closer.Close()
+		}
+		options.controllers[color] = controller
+	}
+	return Start(game, book, tablebases, options, opponents...)
+}
+
+// Runs the built-in engine on stdin and stdout for a GUI, speaking UCI for the uci subcommand and CECP for xboard. The
+// engine plays from the book and tablebases of the global flags, on their number of threads.
+func runProtocol(command string, global globalOptions) error {
+	engine := NewEngine()
+	engine.SetThreads(global.threads)
+	if global.bookPath != "" {
+		book, err := LoadOpeningBook(global.bookPath)
+		if err != nil {
+			return err
+		}
+		engine.SetBook(book)
+	}
+	if global.tablebaseDir != "" {
+		tablebases, err := LoadTablebases(global.tablebaseDir)
+		if err != nil {
+			return err
+		}
+		engine.SetTablebases(tablebases)
+	}
+	if command == "uci" {
+		return RunUCI(os.Stdin, os.Stdout, engine)
+	}
+	return RunCECP(os.Stdin, os.Stdout, engine)
+}
+
+// Loads a game from a file of PGN, a FEN or a list of moves, or from stdin if path is "-". See ParseGameText.
+func loadGame(path string) (*Game, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	g, err := ParseGameText(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("game %v: %w", path, err)
+	}
+	return g, nil
+}
+
+// Counts the positions reached from a position by each depth of valid moves up to the one in args, after the flags of
+// the perft subcommand, and how fast they were counted.
+func runPerft(args []string) error {
+	flags := flag.NewFlagSet("perft", flag.ExitOnError)
+	fen := flags.String("fen", startFEN, "position to count from")
+	divide := flags.Bool("divide", false, "count the positions after each move at the deepest depth, to find the move generation goes wrong for")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("perft needs a depth")
+	}
+	depth, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || depth < 1 {
+		return fmt.Errorf("perft depth %q is not a positive number", flags.Arg(0))
+	}
+	g, err := NewGameFromFEN(*fen)
+	if err != nil {
+		return err
+	}
+
+	if *divide {
+		total := uint64(0)
+		for _, m := range g.LegalMoves() {
+			child := g.Clone()
+			child.ExecuteValidMove(m)
+			nodes := child.Perft(depth - 1)
+			total += nodes
+			fmt.Printf("%v: %v\n", m.asMove().CoordNotation(), nodes)
+		}
+		fmt.Printf("total: %v\n", total)
+		return nil
+	}
+	for d := 1; d <= depth; d++ {
+		start := time.Now()
+		nodes := g.Perft(d)
+		elapsed := time.Since(start)
+		fmt.Printf("depth %v: %v nodes in %v (%.0f nodes/s)\n", d, nodes, elapsed.Round(time.Millisecond),
+			float64(nodes) / max(elapsed.Seconds(), 1e-9))
+	}
+	return nil
+}
+
+// Analyses the game in the PGN file in args, after the flags of the analyze subcommand, and writes it annotated as PGN;
+// or without a file, searches the position of -fen and prints the best lines found.
+func runAnalyze(args []string, tablebaseDir string, threads int) error {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fen := flags.String("fen", startFEN, "position to search if no game is given")
+	moveTime := flags.Duration("movetime", time.Second, "time to search each position for")
+	depth := flags.Int("depth", 0, "depth to search each position to, instead of for -movetime")
+	nodes := flags.Uint64("nodes", 0, "nodes to search each position for, instead of for -movetime")
+	lines := flags.Int("lines", analysisPanelLines, "number of best lines to show for -fen")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return fmt.Errorf("analyze takes at most one PGN file")
+	}
+	limits := SearchLimits{depth: *depth, nodes: *nodes}
+	if *depth == 0 && *nodes == 0 {
+		limits.moveTime = *moveTime
+	}
+
+	// No opening book, since book moves come without an evaluation.
+	engine := NewEngine()
+	engine.SetThreads(threads)
+	if tablebaseDir != "" {
+		tablebases, err := LoadTablebases(tablebaseDir)
+		if err != nil {
+			return err
+		}
+		engine.SetTablebases(tablebases)
+	}
+
+	if flags.NArg() == 0 {
+		g, err := NewGameFromFEN(*fen)
+		if err != nil {
+			return err
+		}
+		limits.multiPV = *lines
+		result, err := engine.Search(context.Background(), g, limits)
+		if err != nil {
+			return err
+		}
+		fmt.Println(linesText(g, result))
+		return nil
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	g, tags, err := ReadPGN(f)
+	if err != nil {
+		return fmt.Errorf("game %v: %w", flags.Arg(0), err)
+	}
+	annotated, err := AnalyzeGame(context.Background(), engine, g, limits, func(done int, total int) {
+		fmt.Fprintf(os.Stderr, "\ranalysed %v of %v positions", done, total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	if white, found := tags["White"]; found {
+		annotated.white = white
+	}
+	if black, found := tags["Black"]; found {
+		annotated.black = black
+	}
+	return annotated.WritePGN(os.Stdout)
+}
+
+// Converts the game in the file in args, or on stdin if none is given, to the format of the -to flag of the convert
+// subcommand. See ParseGameText for the formats read and WriteGame for those written.
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := flags.String("to", "pgn", "format to convert to: " + strings.Join(gameFormats, ", "))
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return fmt.Errorf("convert takes at most one file")
+	}
+	path := "-"
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+	g, err := loadGame(path)
+	if err != nil {
+		return err
+	}
+	return WriteGame(os.Stdout, g, *to)
+}
+
+// Generates the tables of the named endings, or of the default ones if none are named, and writes them to dir.
+func generateTablebases(dir string, names []string) error {
+	if dir == "" {
+		return fmt.Errorf("tbgen needs a directory to write to, given with -tablebases")
+	}
+	if len(names) == 0 {
+		names = defaultTablebases
+	}
+	tablebases := NewTablebases()
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		start := time.Now()
+		if err := tablebases.Generate(name); err != nil {
+			return err
+		}
+		fmt.Printf("%v generated in %v\n", name, time.Since(start).Round(time.Millisecond))
+	}
+	return tablebases.Save(dir)
+}
+
+// Plays the game on with RunHeadless, between the players given by their specs, indexed by Color, with engines thinking
+// for moveTime about each move. See NewController for the specs; human players type their moves on stdin.
+func runHeadless(game *Game, specs [2]string, moveTime time.Duration, threads int, tablebases *Tablebases) error {
+	human := NewLineController(os.Stdin, os.Stdout)
+	var controllers [2]Controller
+	for i, spec := range specs {
+		controller, err := NewController(spec, human, SearchLimits{moveTime: moveTime}, threads, tablebases)
+		if err != nil {
+			return err
+		}
+		if closer, ok := controller.(io.Closer); ok {
+			defer closer.Close()
+		}
+		controllers[i] = controller
+	}
+	return RunHeadless(context.Background(), game, controllers, os.Stdout)
+}
+
+// Runs the built-in engine on the positions of each EPD suite file named in args, after the flags of the epd
+// subcommand, and prints the result of every position and a summary of each suite.
+func runEPDSuites(args []string, tablebaseDir string, threads int) error {
+	flags := flag.NewFlagSet("epd", flag.ExitOnError)
+	moveTime := flags.Duration("movetime", time.Second, "time to search each position for")
+	depth := flags.Int("depth", 0, "depth to search each position to, instead of for -movetime")
+	nodes := flags.Uint64("nodes", 0, "nodes to search each position for, instead of for -movetime")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("epd needs at least one suite file")
+	}
+	limits := SearchLimits{depth: *depth, nodes: *nodes}
+	if *depth == 0 && *nodes == 0 {
+		limits.moveTime = *moveTime
+	}
+
+	// No opening book, since book moves are not searched.
+	engine := NewEngine()
+	engine.SetThreads(threads)
+	if tablebaseDir != "" {
+		tablebases, err := LoadTablebases(tablebaseDir)
+		if err != nil {
+			return err
+		}
+		engine.SetTablebases(tablebases)
+	}
+
+	var all []EPDResult
+	for _, path := range flags.Args() {
+		positions, err := LoadEPD(path)
+		if err != nil {
+			return err
+		}
+		results, err := RunEPDSuite(context.Background(), engine, positions, limits, func(r EPDResult) {
+			WriteEPDResult(os.Stdout, r)
+		})
+		if err != nil {
+			return err
+		}
+		WriteEPDSummary(os.Stdout, path, results)
+		all = append(all, results...)
+	}
+	if flags.NArg() > 1 {
+		WriteEPDSummary(os.Stdout, "total", all)
+	}
+	return nil
+}
+
+// Plays a match between the two engines specified in args, after the flags of the match subcommand, and prints the
+// result of every game and the score so far. See NewMatchSearcher for the engine specs.
+func runMatch(args []string, tablebaseDir string, threads int) error {
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	games := flags.Int("games", 100, "number of games to play")
+	timeControl := flags.String("tc", "10+0.1", "time control as base seconds and increment per move")
+	moveTime := flags.Duration("movetime", 0, "time to search each move for, instead of -tc")
+	depth := flags.Int("depth", 0, "depth to search each move to, instead of -tc")
+	nodes := flags.Uint64("nodes", 0, "nodes to search each move for, instead of -tc")
+	openingsPath := flags.String("openings", "", "file of openings, one per line as FEN or SAN moves")
+	resignMoves := flags.Int("resign-moves", 3, "moves in a row a player's score must be lost for them to resign, 0 for never")
+	resignScore := flags.Int("resign-score", 1000, "centipawns behind at which a player's score is lost")
+	drawMoves := flags.Int("draw-moves", 8, "moves in a row both scores must be level for a draw, 0 for never")
+	drawScore := flags.Int("draw-score", 10, "centipawns either way within which a score is level")
+	drawAfter := flags.Int("draw-after", 40, "move from which games can be adjudicated drawn")
+	maxMoves := flags.Int("max-moves", 200, "moves by each side after which a game is drawn, 0 for no limit")
+	sprtBounds := flags.String("sprt", "", "Elo of H0 and H1 as elo0,elo1, to stop the match once an SPRT decides")
+	alpha := flags.Float64("alpha", 0.05, "false positive rate of the SPRT")
+	beta := flags.Float64("beta", 0.05, "false negative rate of the SPRT")
+	pgnPath := flags.String("pgn", "", "file to append the games to as PGN")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("match needs two engines, each builtin, skill=N, elo=N or uci=path")
+	}
+
+	options := MatchOptions{
+		games: *games,
+		limits: SearchLimits{depth: *depth, nodes: *nodes, moveTime: *moveTime},
+		resignScore: *resignScore,
+		resignMoves: *resignMoves,
+		drawScore: *drawScore,
+		drawMoves: *drawMoves,
+		drawAfter: *drawAfter,
+		maxMoves: *maxMoves,
+	}
+	if *depth == 0 && *nodes == 0 && *moveTime == 0 {
+		var err error
+		options.base, options.increment, err = ParseTimeControl(*timeControl)
+		if err != nil {
+			return err
+		}
+	}
+	if *sprtBounds != "" {
+		elo0, elo1, _ := strings.Cut(*sprtBounds, ",")
+		var err0, err1 error
+		options.sprt.elo0, err0 = strconv.ParseFloat(elo0, 64)
+		options.sprt.elo1, err1 = strconv.ParseFloat(elo1, 64)
+		if err0 != nil || err1 != nil || options.sprt.elo1 <= options.sprt.elo0 {
+			return fmt.Errorf("-sprt %q is not elo0,elo1 with elo0 below elo1", *sprtBounds)
+		}
+		options.sprt.alpha, options.sprt.beta = *alpha, *beta
+	}
+	if *openingsPath != "" {
+		var err error
+		options.openings, err = LoadOpenings(*openingsPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, line := range defaultMatchOpenings {
+			opening, err := ParseOpening(line)
+			if err != nil {
+				return fmt.Errorf("default opening %q: %w", line, err)
+			}
+			options.openings = append(options.openings, opening)
+		}
+	}
+
+	var tablebases *Tablebases
+	if tablebaseDir != "" {
+		var err error
+		tablebases, err = LoadTablebases(tablebaseDir)
+		if err != nil {
+			return err
+		}
+	}
+	var searchers [2]Searcher
+	var names [2]string
+	for i, spec := range flags.Args() {
+		searcher, err := NewMatchSearcher(spec, threads, tablebases)
+		if err != nil {
+			return err
+		}
+		if closer, ok := searcher.(io.Closer); ok {
+			defer closer.Close()
+		}
+		searchers[i] = searcher
+		names[i] = searcher.Name()
+	}
+	if names[0] == names[1] {
+		names = [2]string{flags.Arg(0), flags.Arg(1)}
+	}
+
+	var pgn io.Writer
+	if *pgnPath != "" {
+		f, err := os.OpenFile(*pgnPath, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		pgn = f
+	}
+
+	stats, err := RunMatch(context.Background(), searchers, options, func(i int, game MatchGame, stats MatchStats) {
+		white, black := names[0], names[1]
+		if i % 2 == 1 {
+			white, black = black, white
+		}
+		fmt.Printf("Game %v (opening %v): %v - %v %v {%v}\n", i + 1, i / 2 % len(options.openings) + 1, white, black,
+			game.result, game.reason)
+		fmt.Printf("Score of %v vs %v: %v - %v - %v [%.3f] %v\n", names[0], names[1], stats.wins, stats.losses,
+			stats.draws, stats.Score(), stats.games())
+		if pgn != nil {
+			record := NewAnnotatedGame(game.game)
+			record.white, record.black, record.result = white, black, game.result
+			if err := record.WritePGN(pgn); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return WriteMatchSummary(os.Stdout, names, stats, options.sprt)
 }
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Extra time a searcher is allowed over its clock before its search is cancelled, for the overhead of
+	// communicating with it. It still loses on time if its clock runs out.
+	matchTimeMargin = 100 * time.Millisecond
+	// Normal quantile of the two-sided 95% confidence interval of the Elo difference.
+	eloConfidence = 1.959964
+)
+
+// Openings the games of a match start from when no list is given, each a few moves of a common opening.
+var defaultMatchOpenings = []string{
+	"e4 e5 Nf3 Nc6 Bb5 a6",
+	"e4 e5 Nf3 Nc6 Bc4 Bc5",
+	"e4 c5 Nf3 d6 d4 cxd4",
+	"e4 e6 d4 d5 Nc3 Nf6",
+	"e4 c6 d4 d5 Nc3 dxe4",
+	"d4 d5 c4 e6 Nc3 Nf6",
+	"d4 Nf6 c4 g6 Nc3 Bg7",
+	"d4 Nf6 c4 e6 Nc3 Bb4",
+	"c4 e5 Nc3 Nf6 Nf3 Nc6",
+	"Nf3 d5 g3 Nf6 Bg2 c6",
+}
+
+// Settings of a match between two searchers.
+type MatchOptions struct {
+	// Number of games to play, unless the SPRT decides the match first.
+	games int
+	// Clock time of each player at the start of a game and the time added after each of their moves. Without a base
+	// time, each move is searched within limits instead.
+	base time.Duration
+	increment time.Duration
+	limits SearchLimits
+	// Positions the games start from. Each is played twice, with the searchers swapping colors.
+	openings []*Game
+	// A player resigns once their own score has been at most -resignScore for resignMoves moves in a row. 0 moves
+	// turns resigning off.
+	resignScore int
+	resignMoves int
+	// The game is drawn once, from move drawAfter on, both players' scores have been within drawScore of 0 for
+	// drawMoves moves in a row. 0 moves turns this off.
+	drawScore int
+	drawMoves int
+	drawAfter int
+	// The game is drawn once this many moves have been played by each side. 0 means no limit.
+	maxMoves int
+	sprt SPRT
+}
+
+// A finished game of a match.
+type MatchGame struct {
+	game *Game
+	// PGN result, e.g. "1-0", and the reason the game ended, e.g. "Black loses on time".
+	result string
+	reason string
+}
+
+// Plays a game between the searchers, indexed by the color they play, from the opening position, and returns how it
+// ended. The game ends by the rules, or is adjudicated by the options. An error is only returned if a searcher fails or
+// ctx is cancelled.
+func PlayMatchGame(ctx context.Context, players [2]Searcher, opening *Game, options MatchOptions) (MatchGame, error) {
+	g := opening.Clone()
+	for _, p := range players {
+		p.Clear()
+	}
+	clock := [2]time.Duration{options.base, options.base}
+	var resignCount, drawCount [2]int
+	end := func(result string, reason string) (MatchGame, error) {
+		return MatchGame{game: g, result: result, reason: reason}, nil
+	}
+	win := func(c Color) string {
+		if c == White {
+			return "1-0"
+		}
+		return "0-1"
+	}
+
+	for {
+		if result, reason, over := g.Result(); over {
+			return end(result, reason)
+		}
+		if options.maxMoves > 0 && (len(g.moves) - g.setupMoves) / 2 >= options.maxMoves {
+			return end("1/2-1/2", "Move limit")
+		}
+
+		color := g.currentPlayer
+		limits := options.limits
+		moveCtx, cancel := ctx, context.CancelFunc(func() {})
+		if options.base > 0 {
+			limits.clock = clock
+			limits.increment = [2]time.Duration{options.increment, options.increment}
+			moveCtx, cancel = context.WithTimeout(ctx, clock[color] + matchTimeMargin)
+		}
+		start := time.Now()
+		result, err := players[color].Search(moveCtx, g, limits)
+		elapsed := time.Since(start)
+		cancel()
+		if ctx.Err() != nil {
+			return MatchGame{}, ctx.Err()
+		}
+		if err != nil {
+			return MatchGame{}, fmt.Errorf("%v playing %v: %w", players[color].Name(), color, err)
+		}
+		if options.base > 0 {
+			clock[color] -= elapsed
+			if clock[color] < 0 {
+				return end(win(color.Opponent()), fmt.Sprintf("%v loses on time", color))
+			}
+			clock[color] += options.increment
+		}
+
+		if options.resignMoves > 0 {
+			resignCount[color]++
+			if result.score > -options.resignScore {
+				resignCount[color] = 0
+			}
+			if resignCount[color] >= options.resignMoves {
+				return end(win(color.Opponent()), fmt.Sprintf("%v resigns", color))
+			}
+		}
+		if options.drawMoves > 0 {
+			drawCount[color]++
+			if g.fullmoveNumber < options.drawAfter || result.score > options.drawScore || result.score < -options.drawScore {
+				drawCount[color] = 0
+			}
+			if drawCount[White] >= options.drawMoves && drawCount[Black] >= options.drawMoves {
+				return end("1/2-1/2", "Draw adjudication")
+			}
+		}
+
+		g.ExecuteValidMove(result.move)
+	}
+}
+
+// Results of a match from the point of view of its first searcher.
+type MatchStats struct {
+	wins int
+	losses int
+	draws int
+}
+
+func (s MatchStats) games() int {
+	return s.wins + s.losses + s.draws
+}
+
+// Returns the share of the points the first searcher scored, from 0 to 1.
+func (s MatchStats) Score() float64 {
+	if s.games() == 0 {
+		return 0.5
+	}
+	return (float64(s.wins) + float64(s.draws) / 2) / float64(s.games())
+}
+
+// Returns the mean and variance of the points of a single game, given the numbers of wins, draws and losses.
+func gamePoints(wins float64, draws float64, losses float64) (float64, float64) {
+	n := wins + draws + losses
+	score := (wins + draws / 2) / n
+	variance := (wins * math.Pow(1 - score, 2) + draws * math.Pow(0.5 - score, 2) + losses * math.Pow(score, 2)) / n
+	return score, variance
+}
+
+// Returns the Elo difference of the first searcher over the second that the score implies, and the margin of its 95%
+// confidence interval. Either is infinite if a searcher has scored every point, or could have within the margin.
+func (s MatchStats) Elo() (float64, float64) {
+	if s.games() == 0 {
+		return 0, 0
+	}
+	score, variance := gamePoints(float64(s.wins), float64(s.draws), float64(s.losses))
+	deviation := math.Sqrt(variance / float64(s.games()))
+	low := eloFromScore(score - eloConfidence * deviation)
+	high := eloFromScore(score + eloConfidence * deviation)
+	if math.IsInf(low, 0) || math.IsInf(high, 0) {
+		return eloFromScore(score), math.Inf(1)
+	}
+	return eloFromScore(score), (high - low) / 2
+}
+
+// Returns the Elo difference at which the expected score is the share given.
+func eloFromScore(score float64) float64 {
+	if score <= 0 {
+		return math.Inf(-1)
+	}
+	if score >= 1 {
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1 / score - 1)
+}
+
+// Returns the share of the points expected at the Elo difference.
+func scoreFromElo(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo / 400))
+}
+
+// A sequential probability ratio test of whether the first searcher of a match is elo1 stronger than the second (H1)
+// rather than only elo0 (H0), with false positive rate alpha and false negative rate beta. The zero value is off.
+type SPRT struct {
+	elo0 float64
+	elo1 float64
+	alpha float64
+	beta float64
+}
+
+func (t SPRT) enabled() bool {
+	return t.alpha > 0 && t.beta > 0
+}
+
+// Returns the log-likelihood ratio at which H0 and H1 are accepted.
+func (t SPRT) Bounds() (float64, float64) {
+	return math.Log(t.beta / (1 - t.alpha)), math.Log((1 - t.beta) / t.alpha)
+}
+
+// Returns the log-likelihood ratio of H1 over H0 given the results, using the normal approximation to the trinomial
+// distribution of wins, draws and losses. Half a win and half a loss are added to the results, so that one-sided
+// results, which have no variance, still decide the test.
+func (t SPRT) LLR(s MatchStats) float64 {
+	if s.games() == 0 {
+		return 0
+	}
+	score, variance := gamePoints(float64(s.wins) + 0.5, float64(s.draws), float64(s.losses) + 0.5)
+	score0, score1 := scoreFromElo(t.elo0), scoreFromElo(t.elo1)
+	return (score1 - score0) * (2 * score - score0 - score1) * float64(s.games()) / (2 * variance)
+}
+
+// Returns "H1 accepted" or "H0 accepted" once the test has decided, or "" while it needs more games.
+func (t SPRT) Decision(s MatchStats) string {
+	lower, upper := t.Bounds()
+	switch llr := t.LLR(s); {
+	case llr >= upper:
+		return "H1 accepted"
+	case llr <= lower:
+		return "H0 accepted"
+	}
+	return ""
+}
+
+// Plays the games of a match between the searchers. Game i starts from opening i/2, with the first searcher playing
+// White in even games and Black in odd ones. report, if not nil, is called after each game with its number, from 0,
+// and the results so far. The match stops early once the SPRT, if any, decides.
+func RunMatch(ctx context.Context, searchers [2]Searcher, options MatchOptions, report func(i int, game MatchGame, stats MatchStats)) (MatchStats, error) {
+	var stats MatchStats
+	if len(options.openings) == 0 {
+		return stats, fmt.Errorf("a match needs at least one opening")
+	}
+	for i := range options.games {
+		opening := options.openings[i / 2 % len(options.openings)]
+		players := [2]Searcher{searchers[0], searchers[1]}
+		firstColor := White
+		if i % 2 == 1 {
+			players[White], players[Black] = players[Black], players[White]
+			firstColor = Black
+		}
+		game, err := PlayMatchGame(ctx, players, opening, options)
+		if err != nil {
+			return stats, fmt.Errorf("game %v: %w", i + 1, err)
+		}
+		switch {
+		case game.result == "1/2-1/2":
+			stats.draws++
+		case (game.result == "1-0") == (firstColor == White):
+			stats.wins++
+		default:
+			stats.losses++
+		}
+		if report != nil {
+			report(i, game, stats)
+		}
+		if options.sprt.enabled() && options.sprt.Decision(stats) != "" {
+			break
+		}
+	}
+	return stats, nil
+}
+
+// Writes the results of a match: the score, the Elo difference with its error margin and, if there is one, the state
+// of the SPRT.
+func WriteMatchSummary(w io.Writer, names [2]string, stats MatchStats, sprt SPRT) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Score of %v vs %v: %v - %v - %v [%.3f] %v\n", names[0], names[1], stats.wins, stats.losses,
+		stats.draws, stats.Score(), stats.games())
+	elo, margin := stats.Elo()
+	fmt.Fprintf(&sb, "Elo difference: %.1f +/- %.1f\n", elo, margin)
+	if sprt.enabled() {
+		lower, upper := sprt.Bounds()
+		decision := sprt.Decision(stats)
+		if decision == "" {
+			decision = "no decision yet"
+		}
+		fmt.Fprintf(&sb, "SPRT: llr %.2f (%.2f, %.2f) for elo0 %v elo1 %v, %v\n", sprt.LLR(stats), lower, upper,
+			sprt.elo0, sprt.elo1, decision)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Returns a searcher for a match from its spec: "builtin" for the built-in engine at full strength, "skill=N" or
+// "elo=N" for it at a skill level or rating, or "uci=path args..." for an external UCI engine, such as another build
+// of this program run with its uci subcommand. The built-in engine searches on the threads given, with the tablebases
+// if not nil. External engines must be closed.
+func NewMatchSearcher(spec string, threads int, tablebases *Tablebases) (Searcher, error) {
+	kind, value, _ := strings.Cut(spec, "=")
+	if kind == "uci" {
+		args := strings.Fields(value)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("engine %q has no path", spec)
+		}
+		external, err := StartUCIEngine(args[0], args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return external, nil
+	}
+
+	engine := NewEngine()
+	engine.SetThreads(threads)
+	engine.SetTablebases(tablebases)
+	switch kind {
+	case "builtin":
+		return engine, nil
+	case "skill", "elo":
+		level, err := parseSkillSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		engine.SetSkillLevel(level)
+		return engine, nil
+	}
+	return nil, fmt.Errorf("unknown engine %q, want builtin, skill=N, elo=N or uci=path", spec)
+}
+
+// Returns the skill level of the built-in engine from a "skill=N" or "elo=N" spec.
+func parseSkillSpec(spec string) (int, error) {
+	kind, value, _ := strings.Cut(spec, "=")
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("engine %q: %v is not a number", spec, value)
+	}
+	if kind == "elo" {
+		n = SkillLevelForElo(n)
+	}
+	return n, nil
+}
+
+// Parses a time control of a base time and an increment per move in seconds, e.g. "10+0.1" or "60".
+func ParseTimeControl(s string) (time.Duration, time.Duration, error) {
+	baseText, incrementText, hasIncrement := strings.Cut(s, "+")
+	base, err := strconv.ParseFloat(baseText, 64)
+	if err != nil || base <= 0 {
+		return 0, 0, fmt.Errorf("time control %q has invalid base time", s)
+	}
+	increment := 0.0
+	if hasIncrement {
+		increment, err = strconv.ParseFloat(incrementText, 64)
+		if err != nil || increment < 0 {
+			return 0, 0, fmt.Errorf("time control %q has invalid increment", s)
+		}
+	}
+	return time.Duration(base * float64(time.Second)), time.Duration(increment * float64(time.Second)), nil
+}
+
+// Parses an opening: either a position in FEN, or moves in SAN from the standard starting position, which may be
+// numbered, e.g. "1. e4 e5 2. Nf3".
+func ParseOpening(s string) (*Game, error) {
+	if strings.Contains(s, "/") {
+		return NewGameFromFEN(s)
+	}
+	g := NewGame()
+	for _, token := range strings.Fields(s) {
+		if strings.HasSuffix(token, ".") {
+			continue
+		}
+		m, err := g.ParseSAN(token)
+		if err != nil {
+			return nil, fmt.Errorf("opening %q: %w", s, err)
+		}
+		g.ExecuteValidMove(m)
+	}
+	return g, nil
+}
+
+// Reads openings, one per line. Blank lines and lines starting with "#" are skipped. See ParseOpening.
+func ReadOpenings(r io.Reader) ([]*Game, error) {
+	var openings []*Game
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		g, err := ParseOpening(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", n, err)
+		}
+		openings = append(openings, g)
+	}
+	return openings, scanner.Err()
+}
+
+// Loads an openings file.
+func LoadOpenings(path string) ([]*Game, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	openings, err := ReadOpenings(f)
+	if err != nil {
+		return nil, fmt.Errorf("openings %v: %w", path, err)
+	}
+	return openings, nil
+}
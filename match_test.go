@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Plays the next valid move of a script, given in coordinate notation, each after a delay and with the same score.
+// Once the script runs out it starts over.
+type scriptedSearcher struct {
+	moves []string
+	next int
+	score int
+	delay time.Duration
+}
+
+func (s *scriptedSearcher) Search(ctx context.Context, g *Game, limits SearchLimits) (SearchResult, error) {
+	time.Sleep(s.delay)
+	for range s.moves {
+		m, err := g.ParseCoordMove(s.moves[s.next % len(s.moves)])
+		s.next++
+		if err == nil {
+			return SearchResult{move: m, score: s.score, depth: 1}, nil
+		}
+	}
+	return SearchResult{}, errNoLegalMoves
+}
+
+func (s *scriptedSearcher) Clear() {
+	s.next = 0
+}
+
+func (s *scriptedSearcher) Name() string {
+	return "scripted"
+}
+
+func TestPlayMatchGame(t *testing.T) {
+	knights := func(moves ...string) *scriptedSearcher {
+		return &scriptedSearcher{moves: moves}
+	}
+	var tests = []struct{
+		name string
+		fen string
+		white Searcher
+		black Searcher
+		options MatchOptions
+		wantResult string
+		wantReason string
+	}{
+		{
+			"mate", "7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", NewEngine(), knights("h8g8"),
+			MatchOptions{limits: SearchLimits{depth: 2}}, "1-0", "White mates",
+		},
+		{
+			"repetition", startFEN, knights("g1f3", "f3g1"), knights("g8f6", "f6g8"),
+			MatchOptions{}, "1/2-1/2", "Threefold repetition",
+		},
+		{
+			"insufficient material", "7k/8/8/8/8/8/6q1/6NK w - - 0 1", NewEngine(), knights("h8g8"),
+			MatchOptions{limits: SearchLimits{depth: 1}}, "1/2-1/2", "Insufficient material",
+		},
+		{
+			"move limit", startFEN, knights("g1f3", "f3g1", "b1c3", "c3b1"), knights("g8f6", "f6g8", "b8c6", "c6b8"),
+			MatchOptions{maxMoves: 3}, "1/2-1/2", "Move limit",
+		},
+		{
+			"resign", startFEN, knights("g1f3", "f3g1", "b1c3", "c3b1"), &scriptedSearcher{moves: []string{"g8f6", "f6g8", "b8c6", "c6b8"}, score: -500},
+			MatchOptions{resignScore: 400, resignMoves: 2}, "1-0", "Black resigns",
+		},
+		{
+			"draw adjudication", startFEN, knights("g1f3", "f3g1", "b1c3", "c3b1"), knights("g8f6", "f6g8", "b8c6", "c6b8"),
+			MatchOptions{drawMoves: 2, drawScore: 10}, "1/2-1/2", "Draw adjudication",
+		},
+		{
+			"loss on time", startFEN, knights("g1f3", "f3g1", "b1c3", "c3b1"), &scriptedSearcher{moves: []string{"g8f6"}, delay: 50 * time.Millisecond},
+			MatchOptions{base: 20 * time.Millisecond, increment: time.Millisecond}, "1-0", "Black loses on time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opening, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			game, err := PlayMatchGame(context.Background(), [2]Searcher{tt.white, tt.black}, opening, tt.options)
+			if err != nil {
+				t.Fatalf("PlayMatchGame returned error %v", err)
+			}
+			if game.result != tt.wantResult || game.reason != tt.wantReason {
+				t.Errorf("got %v {%v}, want %v {%v}", game.result, game.reason, tt.wantResult, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMatchStatsElo(t *testing.T) {
+	var tests = []struct{
+		stats MatchStats
+		wantElo float64
+		wantFiniteMargin bool
+	}{
+		{MatchStats{}, 0, true},
+		{MatchStats{wins: 50, losses: 50, draws: 100}, 0, true},
+		{MatchStats{wins: 60, losses: 20, draws: 20}, 147.19, true},
+		{MatchStats{wins: 20, losses: 60, draws: 20}, -147.19, true},
+		{MatchStats{wins: 3}, math.Inf(1), false},
+	}
+
+	for _, tt := range tests {
+		elo, margin := tt.stats.Elo()
+		if math.Abs(elo - tt.wantElo) > 0.01 && elo != tt.wantElo {
+			t.Errorf("Elo of %+v got %.2f, want %.2f", tt.stats, elo, tt.wantElo)
+		}
+		if finite := !math.IsInf(margin, 0) && !math.IsNaN(margin); finite != tt.wantFiniteMargin {
+			t.Errorf("margin of %+v got %v, want finite %v", tt.stats, margin, tt.wantFiniteMargin)
+		}
+		if tt.wantFiniteMargin && tt.stats.games() > 0 && margin <= 0 {
+			t.Errorf("margin of %+v got %v, want positive", tt.stats, margin)
+		}
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	sprt := SPRT{elo0: 0, elo1: 10, alpha: 0.05, beta: 0.05}
+	lower, upper := sprt.Bounds()
+	if math.Abs(lower + 2.944) > 0.001 || math.Abs(upper - 2.944) > 0.001 {
+		t.Errorf("Bounds got (%.3f, %.3f), want (-2.944, 2.944)", lower, upper)
+	}
+
+	var tests = []struct{
+		stats MatchStats
+		want string
+	}{
+		{MatchStats{}, ""},
+		{MatchStats{wins: 10, losses: 10, draws: 10}, ""},
+		{MatchStats{wins: 10000, losses: 10000, draws: 10000}, "H0 accepted"},
+		{MatchStats{wins: 1500, losses: 1000, draws: 1000}, "H1 accepted"},
+		{MatchStats{losses: 20}, "H0 accepted"},
+		{MatchStats{wins: 20}, "H1 accepted"},
+	}
+	for _, tt := range tests {
+		if got := sprt.Decision(tt.stats); got != tt.want {
+			t.Errorf("Decision of %+v got %q with llr %.2f, want %q", tt.stats, got, sprt.LLR(tt.stats), tt.want)
+		}
+	}
+}
+
+func TestRunMatch(t *testing.T) {
+	opening, err := ParseOpening("1. e4 e5 2. Nf3")
+	if err != nil {
+		t.Fatalf("ParseOpening returned error %v", err)
+	}
+	// The first searcher resigns every game at once, whichever color it plays.
+	resigner := &scriptedSearcher{moves: []string{"b8c6", "b1c3"}, score: -1000}
+	other := &scriptedSearcher{moves: []string{"g8f6", "g1f3"}}
+	options := MatchOptions{games: 100, openings: []*Game{opening}, resignScore: 500, resignMoves: 1,
+		sprt: SPRT{elo0: 0, elo1: 10, alpha: 0.05, beta: 0.05}}
+
+	var results []string
+	stats, err := RunMatch(context.Background(), [2]Searcher{resigner, other}, options, func(i int, game MatchGame, stats MatchStats) {
+		results = append(results, game.result)
+	})
+	if err != nil {
+		t.Fatalf("RunMatch returned error %v", err)
+	}
+	if stats.wins != 0 || stats.draws != 0 || stats.losses != len(results) {
+		t.Errorf("stats got %+v after %v games, want all losses", stats, len(results))
+	}
+	if len(results) >= options.games {
+		t.Errorf("played all %v games, want the SPRT to stop the match", len(results))
+	}
+	// The resigner plays White in even games and Black in odd ones.
+	for i, result := range results[:2] {
+		want := []string{"0-1", "1-0"}[i]
+		if result != want {
+			t.Errorf("game %v got %v, want %v", i + 1, result, want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteMatchSummary(&out, [2]string{"a", "b"}, stats, options.sprt); err != nil {
+		t.Fatalf("WriteMatchSummary returned error %v", err)
+	}
+	if !strings.Contains(out.String(), "H0 accepted") {
+		t.Errorf("summary got %q, want H0 accepted", out.String())
+	}
+}
+
+func TestParseOpening(t *testing.T) {
+	var tests = []struct{
+		opening string
+		wantFEN string
+	}{
+		{"e4 e5 Nf3", "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"},
+		{"1. e4 e5 2. Nf3", "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"},
+		{"7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1"},
+	}
+	for _, tt := range tests {
+		g, err := ParseOpening(tt.opening)
+		if err != nil {
+			t.Fatalf("ParseOpening(%q) returned error %v", tt.opening, err)
+		}
+		if got := g.FEN(); got != tt.wantFEN {
+			t.Errorf("ParseOpening(%q) got %q, want %q", tt.opening, got, tt.wantFEN)
+		}
+	}
+	if _, err := ParseOpening("e4 e4"); err == nil {
+		t.Errorf("ParseOpening of illegal moves got no error")
+	}
+	for _, line := range defaultMatchOpenings {
+		if _, err := ParseOpening(line); err != nil {
+			t.Errorf("default opening %q: %v", line, err)
+		}
+	}
+}
+
+func TestParseTimeControl(t *testing.T) {
+	var tests = []struct{
+		tc string
+		wantBase time.Duration
+		wantIncrement time.Duration
+		wantErr bool
+	}{
+		{"10+0.1", 10 * time.Second, 100 * time.Millisecond, false},
+		{"60", time.Minute, 0, false},
+		{"0.5+0", 500 * time.Millisecond, 0, false},
+		{"", 0, 0, true},
+		{"0+1", 0, 0, true},
+		{"10+x", 0, 0, true},
+	}
+	for _, tt := range tests {
+		base, increment, err := ParseTimeControl(tt.tc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimeControl(%q) error got %v, want error %v", tt.tc, err, tt.wantErr)
+			continue
+		}
+		if base != tt.wantBase || increment != tt.wantIncrement {
+			t.Errorf("ParseTimeControl(%q) got %v+%v, want %v+%v", tt.tc, base, increment, tt.wantBase, tt.wantIncrement)
+		}
+	}
+}
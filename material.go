@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// Number of pieces of each PieceType each color starts the game with.
+var startingPieceCounts = [...]int{
+	Pawn: 8,
+	Rook: 2,
+	Knight: 2,
+	Bishop: 2,
+	Queen: 1,
+	King: 1,
+}
+
+// Material values in the points players count, indexed by PieceType.
+var materialPoints = [...]int{
+	Pawn: 1,
+	Rook: 5,
+	Knight: 3,
+	Bishop: 3,
+	Queen: 9,
+	King: 0,
+}
+
+// Returns the number of pieces of each PieceType that each color has lost, indexed by Color, found by counting the
+// pieces left on the board against the starting counts. A piece beyond the starting count of its type is taken to be a
+// promoted pawn, so that the pawn is not counted as lost. Positions not reached from the starting position, such as
+// those set up from a FEN, count whatever is missing from a full set as lost.
+func CapturedPieces(b Board) [2][6]int {
+	var captured [2][6]int
+	for c, player := range b.players {
+		promoted := 0
+		for pt := Rook; pt <= Queen; pt++ {
+			missing := startingPieceCounts[pt] - bits.OnesCount64(player.pieces[pt])
+			if missing < 0 {
+				promoted -= missing
+			}
+			captured[c][pt] = max(missing, 0)
+		}
+		captured[c][Pawn] = max(startingPieceCounts[Pawn] - bits.OnesCount64(player.pieces[Pawn]) - promoted, 0)
+	}
+	return captured
+}
+
+// Returns the material White has more than Black on the board in points, negative if Black has more.
+func MaterialDifference(b Board) int {
+	difference := 0
+	for pt := Pawn; pt <= King; pt++ {
+		difference += materialPoints[pt] * bits.OnesCount64(b.players[White].pieces[pt])
+		difference -= materialPoints[pt] * bits.OnesCount64(b.players[Black].pieces[pt])
+	}
+	return difference
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCapturedPieces(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		wantWhite [6]int
+		wantBlack [6]int
+		wantDifference int
+	}{
+		{"start", startFEN, [6]int{}, [6]int{}, 0},
+		{
+			"pawn for knight", "rnbqkb1r/pppp1ppp/8/4P3/8/8/PPP2PPP/RNBQKBNR b KQkq - 0 4",
+			[6]int{Pawn: 1}, [6]int{Pawn: 1, Knight: 1}, 3,
+		},
+		{
+			// White promoted a pawn to a second queen, and Black lost a rook and two pawns on the way.
+			"promotion", "1nbqkbnr/2pppppp/8/8/8/8/PPP1PPPP/RNBQKBNQ b Qk - 0 9",
+			[6]int{Rook: 1}, [6]int{Pawn: 2, Rook: 1}, 10,
+		},
+		{
+			"underpromotion", "4k3/8/8/8/8/8/8/NNN1K3 w - - 0 1",
+			[6]int{Pawn: 7, Rook: 2, Bishop: 2, Queen: 1}, [6]int{Pawn: 8, Rook: 2, Knight: 2, Bishop: 2, Queen: 1}, 9,
+		},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("%v: NewGameFromFEN returned error %v", tt.name, err)
+		}
+		captured := CapturedPieces(g.board)
+		if captured[White] != tt.wantWhite || captured[Black] != tt.wantBlack {
+			t.Errorf("%v: CapturedPieces got %v, want %v", tt.name, captured, [2][6]int{tt.wantWhite, tt.wantBlack})
+		}
+		if got := MaterialDifference(g.board); got != tt.wantDifference {
+			t.Errorf("%v: MaterialDifference got %v, want %v", tt.name, got, tt.wantDifference)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FEN of the standard starting position.
+const startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+var pieceTypeFENRunes = map[PieceType]rune{
+	Pawn: 'p',
+	Rook: 'r',
+	Knight: 'n',
+	Bishop: 'b',
+	Queen: 'q',
+	King: 'k',
+}
+
+var castlingFENRunes = []struct{
+	r rune
+	right int
+}{
+	{'K', whiteKingside},
+	{'Q', whiteQueenside},
+	{'k', blackKingside},
+	{'q', blackQueenside},
+}
+
+// Creates a game from a position in Forsyth-Edwards Notation. The halfmove clock and fullmove number may be left out.
+//
+// The rules decide castling and en passant from the move history, which a FEN position does not have. Instead, the
+// game's moves start with setup moves that give the history the position implies: every king and rook without a
+// castling right is recorded as having moved (to the square it stands on), and the pawn that can be captured en
+// passant is recorded as having just advanced two squares.
+func NewGameFromFEN(fen string) (*Game, error) {
+	fields := strings.Fields(fen)
+	if len(fields) != 4 && len(fields) != 6 {
+		return nil, fmt.Errorf("FEN %q has %v fields, want 4 or 6", fen, len(fields))
+	}
+
+	g := Game{
+		startFEN: fen,
+		fullmoveNumber: 1,
+		moves: make([]Move, 0),
+	}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, fmt.Errorf("FEN %q has %v ranks, want 8", fen, len(ranks))
+	}
+	for i, rank := range ranks {
+		y := 7 - i
+		x := 0
+		for _, r := range rank {
+			if r >= '1' && r <= '8' {
+				x += int(r - '0')
+				continue
+			}
+			pt, found := fenRunePieceType(r)
+			if !found {
+				return nil, fmt.Errorf("FEN %q has unknown piece %q", fen, r)
+			}
+			if x > 7 {
+				return nil, fmt.Errorf("FEN %q rank %v has more than 8 squares", fen, y+1)
+			}
+			color := White
+			if r >= 'a' {
+				color = Black
+			}
+			g.board.players[color].pieces[pt] |= uint64(CartesianCoord{x, y}.AsBitCoord())
+			x++
+		}
+		if x != 8 {
+			return nil, fmt.Errorf("FEN %q rank %v does not have 8 squares", fen, y+1)
+		}
+	}
+	for _, c := range Colors {
+		if !BitCoord(g.board.players[c].pieces[King]).IsValid() {
+			return nil, fmt.Errorf("FEN %q does not have exactly one %v king", fen, c)
+		}
+	}
+
+	switch fields[1] {
+	case "w":
+		g.currentPlayer = White
+	case "b":
+		g.currentPlayer = Black
+	default:
+		return nil, fmt.Errorf("FEN %q has unknown side to move %q", fen, fields[1])
+	}
+	if inCheck(g.currentPlayer.Opponent(), g.board) {
+		return nil, fmt.Errorf("FEN %q has the side not to move in check", fen)
+	}
+
+	rights := 0
+	if fields[2] != "-" {
+		for _, r := range fields[2] {
+			known := false
+			for _, cr := range castlingFENRunes {
+				if cr.r == r {
+					rights |= cr.right
+					known = true
+				}
+			}
+			if !known {
+				return nil, fmt.Errorf("FEN %q has unknown castling right %q", fen, r)
+			}
+		}
+	}
+	// Every king and rook that could castle is left as is, so first record them all as moved, then take back the
+	// setup moves of those that keep a right.
+	for _, c := range Colors {
+		for _, pt := range []PieceType{King, Rook} {
+			pieces := g.board.players[c].pieces[pt]
+			for pieces != 0 {
+				cc := BitCoord(pieces & -pieces).AsCartesianCoord()
+				pieces &= pieces - 1
+				g.moves = append(g.moves, Move{piece: Piece{c, pt, cc}, dest: cc})
+			}
+		}
+	}
+	keep := make(map[Piece]bool)
+	for _, r := range []struct{
+		right int
+		king Piece
+		rook Piece
+	}{
+		{whiteKingside, Piece{White, King, Coord("e1").AsCartesianCoord()}, Piece{White, Rook, Coord("h1").AsCartesianCoord()}},
+		{whiteQueenside, Piece{White, King, Coord("e1").AsCartesianCoord()}, Piece{White, Rook, Coord("a1").AsCartesianCoord()}},
+		{blackKingside, Piece{Black, King, Coord("e8").AsCartesianCoord()}, Piece{Black, Rook, Coord("h8").AsCartesianCoord()}},
+		{blackQueenside, Piece{Black, King, Coord("e8").AsCartesianCoord()}, Piece{Black, Rook, Coord("a8").AsCartesianCoord()}},
+	} {
+		if rights & r.right == 0 {
+			continue
+		}
+		king, _ := GetCoord(r.king.cc, g.board)
+		rook, _ := GetCoord(r.rook.cc, g.board)
+		if king != r.king || rook != r.rook {
+			return nil, fmt.Errorf("FEN %q has castling right without king and rook in place", fen)
+		}
+		keep[r.king] = true
+		keep[r.rook] = true
+	}
+	n := 0
+	for _, m := range g.moves {
+		if !keep[m.piece] {
+			g.moves[n] = m
+			n++
+		}
+	}
+	g.moves = g.moves[:n]
+
+	if fields[3] != "-" {
+		ep := Coord(fields[3])
+		if !ep.IsValid() {
+			return nil, fmt.Errorf("FEN %q has invalid en passant square %q", fen, fields[3])
+		}
+		opponent := g.currentPlayer.Opponent()
+		forwardX, forwardY := opponent.Forward()
+		from := ep.AsCartesianCoord().AsBitCoord().To(-forwardX, -forwardY)
+		dest := ep.AsCartesianCoord().AsBitCoord().To(forwardX, forwardY)
+		pawn := Piece{opponent, Pawn, dest.AsCartesianCoord()}
+		if p, found := GetCoord(pawn.cc, g.board); dest == 0 || !found || p != pawn {
+			return nil, fmt.Errorf("FEN %q has en passant square %v without a pawn that just advanced past it", fen, ep)
+		}
+		g.moves = append(g.moves, Move{piece: Piece{opponent, Pawn, from.AsCartesianCoord()}, dest: pawn.cc})
+	}
+	g.setupMoves = len(g.moves)
+
+	if len(fields) == 6 {
+		halfmoveClock, err := strconv.Atoi(fields[4])
+		if err != nil || halfmoveClock < 0 {
+			return nil, fmt.Errorf("FEN %q has invalid halfmove clock %q", fen, fields[4])
+		}
+		fullmoveNumber, err := strconv.Atoi(fields[5])
+		if err != nil || fullmoveNumber < 1 {
+			return nil, fmt.Errorf("FEN %q has invalid fullmove number %q", fen, fields[5])
+		}
+		g.halfmoveClock = halfmoveClock
+		g.fullmoveNumber = fullmoveNumber
+	}
+
+	g.validMoves = computeValidMoves(g.currentPlayer, g.board, g.moves, true)
+	g.currentPlayerStatus = statusOf(g.currentPlayer, g.board, g.validMoves)
+	g.positions = []uint64{zobristHash(g.currentPlayer, g.board, g.moves)}
+	return &g, nil
+}
+
+func fenRunePieceType(r rune) (PieceType, bool) {
+	lower := r
+	if r >= 'A' && r <= 'Z' {
+		lower = r - 'A' + 'a'
+	}
+	for pt, fr := range pieceTypeFENRunes {
+		if fr == lower {
+			return pt, true
+		}
+	}
+	return Pawn, false
+}
+
+// Returns the current position in Forsyth-Edwards Notation. The en passant square is only given if a pawn can
+// actually make the capture.
+func (g *Game) FEN() string {
+	var sb strings.Builder
+	for y := 7; y >= 0; y-- {
+		empty := 0
+		for x := range 8 {
+			p, found := GetCoord(CartesianCoord{x, y}, g.board)
+			if !found {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			r := pieceTypeFENRunes[p.pieceType]
+			if p.color == White {
+				r = r - 'a' + 'A'
+			}
+			sb.WriteRune(r)
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+		if y > 0 {
+			sb.WriteRune('/')
+		}
+	}
+
+	sb.WriteRune(' ')
+	if g.currentPlayer == White {
+		sb.WriteString("w")
+	} else {
+		sb.WriteString("b")
+	}
+
+	sb.WriteRune(' ')
+	rights := castlingRights(g.board, g.moves)
+	if rights == 0 {
+		sb.WriteRune('-')
+	}
+	for _, cr := range castlingFENRunes {
+		if rights & cr.right != 0 {
+			sb.WriteRune(cr.r)
+		}
+	}
+
+	sb.WriteRune(' ')
+	if file, found := enPassantFile(g.currentPlayer, g.board, g.moves); found {
+		lastMove := g.moves[len(g.moves)-1]
+		sb.WriteString(string(CartesianCoord{file, (lastMove.piece.cc.Y + lastMove.dest.Y) / 2}.AsCoord()))
+	} else {
+		sb.WriteRune('-')
+	}
+
+	fmt.Fprintf(&sb, " %v %v", g.halfmoveClock, g.fullmoveNumber)
+	return sb.String()
+}
+
+// Returns the move in the coordinate notation used by UCI and CECP: the origin and destination coords, followed by the
+// promotion piece if any, e.g. "e2e4" or "e7e8q".
+func (m Move) CoordNotation() string {
+	s := string(m.piece.cc.AsCoord() + m.dest.AsCoord())
+	if m.promotion != Pawn {
+		s += string(pieceTypeFENRunes[m.promotion])
+	}
+	return s
+}
+
+// Returns the valid move of the current player in Standard Algebraic Notation, e.g. "Nf3", "exd5", "Rad1", "e8=Q+"
+// or "O-O#". The origin is only given as far as needed to tell the move apart from another of the same piece type.
+func (g *Game) SAN(m ValidMove) string {
+	var sb strings.Builder
+	if m.specialMove == Castling && m.dest.X == 6 {
+		sb.WriteString("O-O")
+	} else if m.specialMove == Castling {
+		sb.WriteString("O-O-O")
+	} else {
+		capture := isCapture(m, g.board)
+		if m.piece.pieceType == Pawn {
+			if capture {
+				sb.WriteByte(byte('a' + m.piece.cc.X))
+			}
+		} else {
+			sb.WriteRune(pieceTypeFENRunes[m.piece.pieceType] - 'a' + 'A')
+			ambiguous, sameFile, sameRank := false, false, false
+			for _, other := range g.LegalMoves() {
+				if other.piece.pieceType != m.piece.pieceType || other.dest != m.dest || other.piece.cc == m.piece.cc {
+					continue
+				}
+				ambiguous = true
+				sameFile = sameFile || other.piece.cc.X == m.piece.cc.X
+				sameRank = sameRank || other.piece.cc.Y == m.piece.cc.Y
+			}
+			if ambiguous && (!sameFile || sameRank) {
+				sb.WriteByte(byte('a' + m.piece.cc.X))
+			}
+			if sameFile {
+				sb.WriteByte(byte('1' + m.piece.cc.Y))
+			}
+		}
+		if capture {
+			sb.WriteByte('x')
+		}
+		sb.WriteString(string(m.dest.AsCoord()))
+		if m.specialMove == Promotion {
+			sb.WriteRune('=')
+			sb.WriteRune(pieceTypeFENRunes[m.promotion] - 'a' + 'A')
+		}
+	}
+
+	opponent := g.currentPlayer.Opponent()
+	if inCheck(opponent, m.newBoard) {
+		if len(legalMoves(opponent, m.newBoard, append(slices.Clone(g.moves), m.asMove()))) == 0 {
+			sb.WriteRune('#')
+		} else {
+			sb.WriteRune('+')
+		}
+	}
+	return sb.String()
+}
+
+// Returns a line of moves from the current position in SAN with move numbers, e.g. "12... Nf6 13. Qxf7#". The line
+// ends early at a move that is not valid, which a principal variation read from the transposition table may have.
+func (g *Game) SANLine(moves []Move) string {
+	line := g.Clone()
+	var sb strings.Builder
+	for i, m := range moves {
+		vm, found := line.FindValidMove(m.piece.cc, m.dest, m.promotion)
+		if !found || vm.piece.pieceType != m.piece.pieceType {
+			break
+		}
+		if i > 0 {
+			sb.WriteRune(' ')
+		}
+		if line.currentPlayer == White {
+			fmt.Fprintf(&sb, "%v. ", line.fullmoveNumber)
+		} else if i == 0 {
+			fmt.Fprintf(&sb, "%v... ", line.fullmoveNumber)
+		}
+		sb.WriteString(line.SAN(vm))
+		line.ExecuteValidMove(vm)
+	}
+	return sb.String()
+}
+
+var errInvalidCoordNotation = errors.New("not a move in coordinate notation")
+
+// Looks up the valid move of the current player given in coordinate notation. See Move.CoordNotation.
+func (g *Game) ParseCoordMove(s string) (ValidMove, error) {
+	if len(s) != 4 && len(s) != 5 {
+		return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidCoordNotation)
+	}
+	from, dest := Coord(s[0:2]), Coord(s[2:4])
+	if !from.IsValid() || !dest.IsValid() {
+		return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidCoordNotation)
+	}
+	promotion := Pawn
+	if len(s) == 5 {
+		var isPromotion bool
+		promotion, isPromotion = PromotionPieceType(rune(s[4]))
+		if !isPromotion {
+			return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidCoordNotation)
+		}
+	}
+	m, found := g.FindValidMove(from.AsCartesianCoord(), dest.AsCartesianCoord(), promotion)
+	if !found {
+		return ValidMove{}, fmt.Errorf("%q is not a valid move for %v", s, g.currentPlayer)
+	}
+	return m, nil
+}
+
+var errInvalidSAN = errors.New("not a move in SAN")
+
+// Looks up the legal move of the current player given in Standard Algebraic Notation. See Game.SAN. Check and
+// annotation marks are ignored, and so are a missing capture mark or promotion "=", a "0-0" for "O-O", and an origin
+// given more fully than needed, e.g. "Ng1f3".
+func (g *Game) ParseSAN(s string) (ValidMove, error) {
+	san := strings.TrimRight(s, "+#!?")
+	castle := 0
+	switch san {
+	case "O-O", "0-0":
+		castle = 6
+	case "O-O-O", "0-0-0":
+		castle = 2
+	}
+	if castle != 0 {
+		for _, m := range g.LegalMoves() {
+			if m.specialMove == Castling && m.dest.X == castle {
+				return m, nil
+			}
+		}
+		return ValidMove{}, fmt.Errorf("%q is not a valid move for %v", s, g.currentPlayer)
+	}
+
+	pieceType := Pawn
+	if len(san) > 0 && san[0] >= 'A' && san[0] <= 'Z' {
+		pt, found := pieceTypeForSAN(san[0])
+		if !found || pt == Pawn {
+			return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidSAN)
+		}
+		pieceType = pt
+		san = san[1:]
+	}
+	promotion := Pawn
+	if n := len(san); pieceType == Pawn && n > 0 && san[n-1] >= 'A' && san[n-1] <= 'Z' {
+		pt, found := pieceTypeForSAN(san[n-1])
+		if !found || pt == Pawn || pt == King {
+			return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidSAN)
+		}
+		promotion = pt
+		san = strings.TrimSuffix(san[:n-1], "=")
+	}
+	san = strings.ReplaceAll(san, "x", "")
+	if len(san) < 2 || len(san) > 4 {
+		return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidSAN)
+	}
+	dest := Coord(san[len(san)-2:])
+	if !dest.IsValid() {
+		return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidSAN)
+	}
+	fromFile, fromRank := -1, -1
+	for _, r := range san[:len(san)-2] {
+		switch {
+		case r >= 'a' && r <= 'h':
+			fromFile = int(r - 'a')
+		case r >= '1' && r <= '8':
+			fromRank = int(r - '1')
+		default:
+			return ValidMove{}, fmt.Errorf("%q: %w", s, errInvalidSAN)
+		}
+	}
+
+	var matches []ValidMove
+	for _, m := range g.LegalMoves() {
+		if m.piece.pieceType != pieceType || m.dest != dest.AsCartesianCoord() || m.promotion != promotion {
+			continue
+		}
+		if (fromFile >= 0 && m.piece.cc.X != fromFile) || (fromRank >= 0 && m.piece.cc.Y != fromRank) {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	switch len(matches) {
+	case 0:
+		return ValidMove{}, fmt.Errorf("%q is not a valid move for %v", s, g.currentPlayer)
+	case 1:
+		return matches[0], nil
+	}
+	return ValidMove{}, fmt.Errorf("%q is ambiguous for %v", s, g.currentPlayer)
+}
+
+// Returns the piece type of an uppercase SAN piece letter, e.g. 'N' for a knight.
+func pieceTypeForSAN(b byte) (PieceType, bool) {
+	for pt, r := range pieceTypeFENRunes {
+		if r - 'a' + 'A' == rune(b) {
+			return pt, true
+		}
+	}
+	return Pawn, false
+}
+
+// Parses a move in coordinate notation, e.g. "e2e4", or failing that in SAN, e.g. "e4".
+func parseMoveText(g *Game, text string) (ValidMove, error) {
+	if m, err := g.ParseCoordMove(text); err == nil {
+		return m, nil
+	}
+	return g.ParseSAN(text)
+}
+
+// Parses a game given as a FEN position, or as PGN, which may be no more than its moves, e.g. "1. e4 e5 2. g1f3". See
+// ReadPGN.
+func ParseGameText(text string) (*Game, error) {
+	fields := strings.Fields(text)
+	if len(fields) > 0 && strings.Count(fields[0], "/") == 7 {
+		return NewGameFromFEN(strings.Join(fields, " "))
+	}
+	g, _, err := ReadPGN(strings.NewReader(text))
+	return g, err
+}
+
+// Formats of a game that WriteGame writes.
+var gameFormats = []string{"fen", "pgn", "san", "coord"}
+
+// Writes the game in a format of gameFormats: the FEN of its current position, PGN, or its moves on a line in SAN or in
+// coordinate notation.
+func WriteGame(w io.Writer, g *Game, format string) error {
+	moves := g.moves[g.setupMoves:]
+	var err error
+	switch format {
+	case "fen":
+		_, err = fmt.Fprintln(w, g.FEN())
+	case "pgn":
+		err = NewAnnotatedGame(g).WritePGN(w)
+	case "san":
+		_, err = fmt.Fprintln(w, g.initialGame().SANLine(moves))
+	case "coord":
+		coords := make([]string, len(moves))
+		for i, m := range moves {
+			coords[i] = m.CoordNotation()
+		}
+		_, err = fmt.Fprintln(w, strings.Join(coords, " "))
+	default:
+		err = fmt.Errorf("unknown game format %q, want one of %v", format, strings.Join(gameFormats, ", "))
+	}
+	return err
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFEN(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		wantFEN string
+	}{
+		{"start", startFEN, startFEN},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", ""},
+		{"partial castling rights", "r3k2r/8/8/8/8/8/8/R3K2R b Kq - 3 20", ""},
+		{"en passant", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", ""},
+		{"en passant not possible", "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq e6 0 2", "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 2"},
+		{"no clocks", "8/8/8/4k3/8/8/8/4K3 w - -", "8/8/8/4k3/8/8/8/4K3 w - - 0 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			want := tt.wantFEN
+			if want == "" {
+				want = tt.fen
+			}
+			if got := g.FEN(); got != want {
+				t.Errorf("FEN got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFENErrors(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+	}{
+		{"empty", ""},
+		{"too few ranks", "8/8/8/8/8/8/8 w - - 0 1"},
+		{"too many squares", "9/8/8/8/8/8/8/K6k w - - 0 1"},
+		{"too many pieces", "KKKKKKKKk/8/8/8/8/8/8/8 w - - 0 1"},
+		{"unknown piece", "8/8/8/8/8/8/8/K5xk w - - 0 1"},
+		{"missing king", "8/8/8/8/8/8/8/K7 w - - 0 1"},
+		{"two kings", "8/8/8/8/8/8/8/KK5k w - - 0 1"},
+		{"unknown side", "8/8/8/8/8/8/8/K6k x - - 0 1"},
+		{"opponent in check", "8/8/8/8/8/8/8/K5Rk w - - 0 1"},
+		{"castling without rook", "4k3/8/8/8/8/8/8/4K3 w K - 0 1"},
+		{"en passant without pawn", "4k3/8/8/8/8/8/8/4K3 w - e6 0 1"},
+		{"bad fullmove number", "4k3/8/8/8/8/8/8/4K3 w - - 0 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGameFromFEN(tt.fen); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
+
+func TestFENCastlingAndEnPassant(t *testing.T) {
+	// The rules decide castling and en passant from the move history, so check that the setup moves are honoured.
+	var tests = []struct{
+		name string
+		fen string
+		move string
+		wantValid bool
+	}{
+		{"castle with right", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", true},
+		{"castle without right", "r3k2r/8/8/8/8/8/8/R3K2R w Qkq - 0 1", "e1g1", false},
+		{"castle queenside", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", true},
+		{"castle through check", "r3k2r/8/8/8/8/8/8/R3KR1R b KQkq - 0 1", "e8g8", false},
+		{"castle with rook off the corner", "4k3/8/8/8/8/8/8/1R2K2R w K - 0 1", "e1c1", false},
+		{"castle queenside from f1", "4k3/8/8/8/8/8/8/R4K1R w - - 0 1", "f1d1", false},
+		{"castle kingside from f1", "4k3/8/8/8/8/8/8/R4K1R w - - 0 1", "f1h1", false},
+		{"en passant", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "e5f6", true},
+		{"en passant of older advance", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "e5d6", false},
+		{"double advance from start rank", "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", "e2e4", true},
+		{"double advance blocked", "4k3/8/8/8/8/4n3/4P3/4K3 w - - 0 1", "e2e4", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			_, err = g.ParseCoordMove(tt.move)
+			if (err == nil) != tt.wantValid {
+				t.Errorf("valid got %v, want %v", err == nil, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestPerftFromFEN(t *testing.T) {
+	// Positions whose castling rights differ from what the placement alone allows, so that the setup moves count.
+	// Published node counts, see https://www.chessprogramming.org/Perft_Results
+	var tests = []struct{
+		name string
+		fen string
+		depth int
+		want uint64
+	}{
+		{"position 4 depth 2", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", 2, 264},
+		{"position 5 depth 2", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", 2, 1486},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			if got := g.Perft(tt.depth); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoordNotation(t *testing.T) {
+	g := NewGame()
+	playMoves(t, g, "h2h4", "g7g5", "h4g5", "g8f6", "g5g6", "f6g8", "g6g7", "a7a6")
+	for _, s := range []string{"g7h8q", "g7f8n", "e2e4", "g1f3"} {
+		m, err := g.ParseCoordMove(s)
+		if err != nil {
+			t.Errorf("ParseCoordMove(%q) returned error %v", s, err)
+			continue
+		}
+		if got := m.asMove().CoordNotation(); got != s {
+			t.Errorf("CoordNotation got %q, want %q", got, s)
+		}
+	}
+	for _, s := range []string{"", "e2", "e2e5", "g7h8", "g7h8k", "e2e4q", "i2i4", "e7e5"} {
+		if _, err := g.ParseCoordMove(s); err == nil {
+			t.Errorf("ParseCoordMove(%q) got no error", s)
+		}
+	}
+}
+
+func TestSAN(t *testing.T) {
+	var tests = []struct{
+		fen string
+		move string
+		want string
+	}{
+		{startFEN, "g1f3", "Nf3"},
+		{startFEN, "e2e4", "e4"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "e4d5", "exd5"},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "e5f6", "exf6"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", "O-O-O"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "a1d1", "Rad1"},
+		{"4k3/8/8/8/8/8/8/R3K2R w - - 0 1", "h1h8", "Rh8+"},
+		{"4k3/8/8/8/R7/8/8/R3K3 w - - 0 1", "a1a2", "R1a2"},
+		{"4k3/8/8/8/8/8/8/N1N1K2N w - - 0 1", "a1b3", "Nab3"},
+		{"4k3/8/8/8/8/2N5/8/2N1K1N1 w - - 0 1", "c1e2", "Nc1e2"},
+		{"3k4/6P1/8/8/8/8/8/4K3 w - - 0 1", "g7g8q", "g8=Q+"},
+		{"7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a8", "Ra8#"},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("NewGameFromFEN(%q) returned error %v", tt.fen, err)
+		}
+		m, err := g.ParseCoordMove(tt.move)
+		if err != nil {
+			t.Fatalf("ParseCoordMove(%q) returned error %v", tt.move, err)
+		}
+		if got := g.SAN(m); got != tt.want {
+			t.Errorf("SAN of %v in %q got %q, want %q", tt.move, tt.fen, got, tt.want)
+		}
+	}
+}
+
+func TestSANLine(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		moves []string
+		// Number of leading moves to repeat at the end, which are no longer valid there.
+		repeat int
+		want string
+	}{
+		{"white to move", startFEN, []string{"e2e4", "e7e5", "g1f3"}, 0, "1. e4 e5 2. Nf3"},
+		{"black to move", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1", []string{"e7e5", "g1f3", "b8c6"}, 0, "1... e5 2. Nf3 Nc6"},
+		{"mate", "7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", []string{"a1a8"}, 0, "1. Ra8#"},
+		{"invalid move ends line", startFEN, []string{"e2e4"}, 1, "1. e4"},
+		{"empty", startFEN, nil, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			line := g.Clone()
+			var moves []Move
+			for _, s := range tt.moves {
+				m, err := line.ParseCoordMove(s)
+				if err != nil {
+					t.Fatalf("ParseCoordMove(%q) returned error %v", s, err)
+				}
+				moves = append(moves, m.asMove())
+				line.ExecuteValidMove(m)
+			}
+			moves = append(moves, moves[:tt.repeat]...)
+			if got := g.SANLine(moves); got != tt.want {
+				t.Errorf("SANLine got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSAN(t *testing.T) {
+	var tests = []struct{
+		fen string
+		san string
+		want string
+	}{
+		{startFEN, "Nf3", "g1f3"},
+		{startFEN, "e4", "e2e4"},
+		{startFEN, "Ng1f3", "g1f3"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "exd5", "e4d5"},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "ed5", "e4d5"},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", "exf6", "e5f6"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O", "e1g1"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "0-0-0", "e8c8"},
+		{"4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "Rad1", "a1d1"},
+		{"4k3/8/8/8/R7/8/8/R3K3 w - - 0 1", "R1a2", "a1a2"},
+		{"4k3/8/8/8/8/2N5/8/2N1K1N1 w - - 0 1", "Nc1e2", "c1e2"},
+		{"3k4/6P1/8/8/8/8/8/4K3 w - - 0 1", "g8=Q+", "g7g8q"},
+		{"3k4/6P1/8/8/8/8/8/4K3 w - - 0 1", "g8N", "g7g8n"},
+		{"7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "Ra8#!", "a1a8"},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(tt.fen)
+		if err != nil {
+			t.Fatalf("NewGameFromFEN(%q) returned error %v", tt.fen, err)
+		}
+		m, err := g.ParseSAN(tt.san)
+		if err != nil {
+			t.Errorf("ParseSAN(%q) in %q returned error %v", tt.san, tt.fen, err)
+			continue
+		}
+		if got := m.asMove().CoordNotation(); got != tt.want {
+			t.Errorf("ParseSAN(%q) in %q got %v, want %v", tt.san, tt.fen, got, tt.want)
+		}
+	}
+}
+
+func TestParseSANErrors(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		san string
+	}{
+		{"empty", startFEN, ""},
+		{"illegal", startFEN, "e5"},
+		{"unknown piece", startFEN, "Xf3"},
+		{"bad square", startFEN, "Nf9"},
+		{"ambiguous", "4k3/8/8/8/8/8/4K3/R6R w - - 0 1", "Rd1"},
+		{"promotion missing", "3k4/6P1/8/8/8/8/8/4K3 w - - 0 1", "g8"},
+		{"castling not allowed", "r3k2r/8/8/8/8/8/8/R3K2R w - - 0 1", "O-O"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			if m, err := g.ParseSAN(tt.san); err == nil {
+				t.Errorf("ParseSAN(%q) got %v, want error", tt.san, m.asMove().CoordNotation())
+			}
+		})
+	}
+}
+
+func TestParseSANRoundTrip(t *testing.T) {
+	fens := []string{
+		startFEN,
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"n1n5/PPPk4/8/8/8/8/4Kppp/5N1N b - - 0 1",
+	}
+	for _, fen := range fens {
+		g, err := NewGameFromFEN(fen)
+		if err != nil {
+			t.Fatalf("NewGameFromFEN(%q) returned error %v", fen, err)
+		}
+		for _, m := range g.LegalMoves() {
+			san := g.SAN(m)
+			parsed, err := g.ParseSAN(san)
+			if err != nil {
+				t.Errorf("ParseSAN(%q) in %q returned error %v", san, fen, err)
+				continue
+			}
+			if parsed.asMove() != m.asMove() {
+				t.Errorf("ParseSAN(%q) in %q got %v, want %v", san, fen, parsed.asMove().CoordNotation(), m.asMove().CoordNotation())
+			}
+		}
+	}
+}
+
+func TestParseGameText(t *testing.T) {
+	var tests = []struct{
+		text string
+		wantFEN string
+	}{
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"},
+		{"1. e4 e5", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"},
+		{"e2e4 e7e5\n", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"},
+		{"[Event \"?\"]\n\n1. e4 e5 *\n", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"},
+	}
+	for _, tt := range tests {
+		g, err := ParseGameText(tt.text)
+		if err != nil {
+			t.Errorf("ParseGameText(%q) returned error %v", tt.text, err)
+			continue
+		}
+		if got := g.FEN(); got != tt.wantFEN {
+			t.Errorf("ParseGameText(%q) got %q, want %q", tt.text, got, tt.wantFEN)
+		}
+	}
+}
+
+func TestWriteGame(t *testing.T) {
+	g, err := NewGameFromFEN("4k3/8/8/8/8/8/4P3/4K3 b - - 0 12")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	playMoves(t, g, "e8d7", "e2e4")
+	var tests = []struct{
+		format string
+		want string
+	}{
+		{"fen", "8/3k4/8/8/4P3/8/8/4K3 b - - 0 13\n"},
+		{"san", "12... Kd7 13. e4\n"},
+		{"coord", "e8d7 e2e4\n"},
+		{"pgn", "[FEN \"4k3/8/8/8/8/8/4P3/4K3 b - - 0 12\"]\n\n12... Kd7 13. e4 *\n\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteGame(&buf, g, tt.format); err != nil {
+			t.Errorf("WriteGame(%v) returned error %v", tt.format, err)
+			continue
+		}
+		if got := buf.String(); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("WriteGame(%v) got %q, want it to end with %q", tt.format, got, tt.want)
+		}
+	}
+	if err := WriteGame(io.Discard, g, "xml"); err == nil {
+		t.Errorf("WriteGame of an unknown format got no error")
+	}
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Deepest ply the search will reach, counted from the root.
+	maxPly = 64
+	// Most goroutines a search can run on.
+	maxThreads = 256
+	// Score of being mated at the root. Mates further away score closer to zero by the number of plies to the mate,
+	// so that shorter mates are preferred. Any score beyond mateThreshold is a forced mate.
+	mateScore = 100000
+	mateThreshold = mateScore - maxPly
+	infinity = mateScore + 1
+	// Number of nodes searched between checks of the context, deadline and node limit.
+	checkInterval = 1024
+	// Assumed number of moves left in the game when the clock has no moves-to-go.
+	defaultMovesToGo = 30
+	// Time kept in reserve on the clock to cover the cost of communicating moves.
+	moveOverhead = 50 * time.Millisecond
+)
+
+var errNoLegalMoves = errors.New("no legal moves in position")
+
+// Bounds a search. Zero values mean no limit of that kind. Without any limit the search runs until the context is
+// cancelled or maxPly is reached.
+type SearchLimits struct {
+	depth int
+	nodes uint64
+	moveTime time.Duration
+	// Remaining clock time and increment per move of each color, indexed by Color.
+	clock [2]time.Duration
+	increment [2]time.Duration
+	movesToGo int
+	infinite bool
+	// Number of best root moves to find a line for, each searched as fully as the best. 0 and 1 both mean only the
+	// best.
+	multiPV int
+}
+
+// One of the best lines found from the root, with its score from the point of view of the player to move.
+type PVLine struct {
+	score int
+	pv []Move
+}
+
+// Outcome of a search, also reported for each completed iteration while searching.
+type SearchResult struct {
+	move ValidMove
+	// Score in centipawns from the point of view of the player to move.
+	score int
+	depth int
+	nodes uint64
+	elapsed time.Duration
+	pv []Move
+	// The best lines, best first, as many as SearchLimits.multiPV asked for and there are legal moves. The first is the
+	// line of score and pv.
+	lines []PVLine
+}
+
+// Engine picks moves using an iterative deepening alpha-beta search. It is not safe for concurrent use, but the move
+// ordering tables are kept between searches so an Engine should be reused across the moves of a game.
+//
+// With more than one thread, the search uses Lazy SMP: helper engines search the same position on their own goroutines,
+// each starting at a different depth, and share their results with the main search through the transposition table
+// only. Their moves are never played; they just make the main search find more of the tree already searched.
+type Engine struct {
+	// Called after each completed iteration with the result so far. May be nil.
+	onIteration func(SearchResult)
+
+	ctx context.Context
+	limits SearchLimits
+	start time.Time
+	deadline time.Time
+	nodes uint64
+	stopped bool
+	// Set by the main search once it is done, to stop it if this is a helper. Nil for the main search.
+	stop *atomic.Bool
+	// nodes as of the last check for stopping, which the main search reads from its helpers while they run.
+	publishedNodes atomic.Uint64
+	// Engines searching alongside this one, one per thread beyond the first. See SetThreads.
+	helpers []*Engine
+
+	// Game moves up to the root followed by the moves of the line currently being searched.
+	moves []Move
+	// Zobrist hashes of the positions before the one being searched: those of the game since its last capture or pawn
+	// move, followed by those of the line. See isRepetition.
+	positions []uint64
+	// Triangular table of principal variations, where pv[ply] holds the best line found from ply onwards.
+	pv [maxPly+1][maxPly+1]Move
+	pvLength [maxPly+1]int
+	// Principal variation of the previous iteration, searched first in the next one.
+	prevPV []Move
+	// Quiet moves that caused a beta cutoff at each ply.
+	killers [maxPly][2]Move
+	// Scores of quiet moves that caused a beta cutoff, indexed by color, origin and destination square.
+	history [2][64][64]int
+	// Results of earlier searches of positions, kept between searches. Nil if disabled.
+	tt *TranspositionTable
+	// Opening book whose moves are played instead of searching, while the position is in it. Nil if there is none.
+	book *OpeningBook
+	// Endgame tablebases, probed instead of searching positions that are in them. Nil if there are none.
+	tablebases *Tablebases
+	// Strength from 1 to maxSkillLevel, and the seed of the evaluation noise of the levels below it, which changes
+	// with every search. See SetSkillLevel.
+	skillLevel int
+	noiseSeed uint64
+	// Root moves left out of the search, since lines for them were already found. See SearchLimits.multiPV.
+	excluded []Move
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		tt: NewTranspositionTable(defaultHashSizeMB),
+		skillLevel: maxSkillLevel,
+	}
+}
+
+// Replaces the transposition table with an empty one of the given size. A size of 0 disables the table.
+func (e *Engine) SetHashSize(sizeMB int) {
+	e.tt = nil
+	if sizeMB > 0 {
+		e.tt = NewTranspositionTable(sizeMB)
+	}
+}
+
+// Sets the opening book to play from. A nil book makes the engine always search.
+func (e *Engine) SetBook(book *OpeningBook) {
+	e.book = book
+}
+
+// Sets the number of goroutines a search runs on, from 1 to maxThreads.
+func (e *Engine) SetThreads(threads int) {
+	threads = max(1, min(threads, maxThreads))
+	for len(e.helpers) < threads - 1 {
+		e.helpers = append(e.helpers, &Engine{skillLevel: maxSkillLevel})
+	}
+	e.helpers = e.helpers[:threads - 1]
+}
+
+// Sets the endgame tablebases to probe. Nil disables probing.
+func (e *Engine) SetTablebases(tablebases *Tablebases) {
+	e.tablebases = tablebases
+}
+
+// Forgets everything learned in earlier searches, e.g. when starting a new game.
+func (e *Engine) Clear() {
+	if e.tt != nil {
+		e.tt.Clear()
+	}
+	e.history = [2][64][64]int{}
+	for _, helper := range e.helpers {
+		helper.history = [2][64][64]int{}
+	}
+}
+
+// Searches the current position of the game for the best move within the given limits. When the search is stopped
+// early, by the limits or by cancelling ctx, the result of the deepest completed iteration is returned. If not even
+// the first iteration completed, the first legal move is returned. An error is returned only if there is no legal move.
+// While the position is in the opening book or the tablebases, a move from them is returned without searching, unless
+// the search is infinite. Below the strongest skill level, the limits are tightened and the move played may not be the
+// best one found.
+func (e *Engine) Search(ctx context.Context, g *Game, limits SearchLimits) (SearchResult, error) {
+	rootMoves := legalMoves(g.currentPlayer, g.board, g.moves)
+	if len(rootMoves) == 0 {
+		return SearchResult{}, errNoLegalMoves
+	}
+	if e.book != nil && !limits.infinite {
+		if m, found := e.book.PickMove(g); found {
+			return SearchResult{move: m, pv: []Move{m.asMove()}}, nil
+		}
+	}
+	if e.tablebases != nil && !limits.infinite {
+		if m, result, found := e.tablebases.BestMove(g); found {
+			return SearchResult{move: m, score: result.score(0), pv: []Move{m.asMove()}}, nil
+		}
+	}
+
+	limits = e.skillLimits(limits)
+	e.prepare(ctx, limits, g)
+	e.noiseSeed = rand.Uint64()
+	if e.tt != nil {
+		e.tt.NewSearch()
+	}
+
+	softTime, hardTime := allocateTime(limits, g.currentPlayer)
+	e.deadline = time.Time{}
+	if hardTime > 0 {
+		e.deadline = e.start.Add(hardTime)
+	}
+
+	// Weakened levels search alone, since helpers would make them stronger.
+	var helpers []*Engine
+	if e.skillLevel >= maxSkillLevel {
+		helpers = e.helpers
+	}
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i, helper := range helpers {
+		helper.stop = &stop
+		helper.tt = e.tt
+		helper.tablebases = e.tablebases
+		// Only the main search's limits apply, and it stops the helpers when it is done.
+		helper.prepare(ctx, SearchLimits{infinite: true}, g)
+		helper.deadline = e.deadline
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The main search starts at depth 1, and the helpers in turn at depths 2 to 5.
+			helper.searchHelper(g, 2 + i % 4)
+		}()
+	}
+	maxDepth := limits.depth
+	if maxDepth <= 0 || maxDepth > maxPly {
+		maxDepth = maxPly
+	}
+
+	lineCount := max(1, min(limits.multiPV, len(rootMoves)))
+	best := SearchResult{move: rootMoves[0]}
+	for depth := 1; depth <= maxDepth; depth++ {
+		// Each line after the first is the best line of the root moves not yet in a line. A line is only complete
+		// once every move of the iteration has been searched, so an iteration stopped early is discarded whole.
+		lines := make([]PVLine, 0, lineCount)
+		e.excluded = e.excluded[:0]
+		for len(lines) < lineCount && !e.stopped {
+			score := e.negamax(g.currentPlayer, g.board, depth, 0, -infinity, infinity, true)
+			pv := slices.Clone(e.pv[0][:e.pvLength[0]])
+			if e.stopped || len(pv) == 0 {
+				break
+			}
+			lines = append(lines, PVLine{score, pv})
+			e.excluded = append(e.excluded, pv[0])
+		}
+		e.excluded = e.excluded[:0]
+		if e.stopped {
+			break
+		}
+		slices.SortStableFunc(lines, func(a, b PVLine) int {
+			return b.score - a.score
+		})
+		best.setLine(lines[0], rootMoves)
+		best.lines = lines
+		best.depth = depth
+		best.nodes = e.totalNodes(helpers)
+		best.elapsed = time.Since(e.start)
+		e.prevPV = lines[0].pv
+		if e.onIteration != nil {
+			e.onIteration(best)
+		}
+
+		score := lines[0].score
+		if !limits.infinite && (score >= mateThreshold || score <= -mateThreshold) {
+			// A forced mate was found; deeper iterations cannot improve on it.
+			break
+		}
+		if softTime > 0 && time.Since(e.start) > softTime/2 {
+			// The next iteration usually takes longer than all previous ones together, so it would not finish in time.
+			break
+		}
+	}
+	if e.skillLevel < maxSkillLevel && !limits.infinite && len(best.lines) > 0 {
+		best.setLine(e.pickSkillLine(best.lines), rootMoves)
+	}
+	stop.Store(true)
+	wg.Wait()
+	best.nodes = e.totalNodes(helpers)
+	best.elapsed = time.Since(e.start)
+	return best, nil
+}
+
+// Resets the state of the engine for a search of the game's current position.
+func (e *Engine) prepare(ctx context.Context, limits SearchLimits, g *Game) {
+	e.ctx = ctx
+	e.limits = limits
+	e.start = time.Now()
+	e.nodes = 0
+	e.publishedNodes.Store(0)
+	e.stopped = false
+	e.moves = append(e.moves[:0], g.moves...)
+	// The root's own position is added as the search leaves it.
+	e.positions = append(e.positions[:0], g.positions[:max(len(g.positions)-1, 0)]...)
+	e.prevPV = nil
+	e.killers = [maxPly][2]Move{}
+	e.ageHistory()
+}
+
+// Searches deeper and deeper from startDepth until stopped, for the sake of the entries it leaves in the shared
+// transposition table. Helpers start at different depths so that they do not all search the same tree in step.
+func (e *Engine) searchHelper(g *Game, startDepth int) {
+	defer func() {
+		e.publishedNodes.Store(e.nodes)
+	}()
+	for depth := startDepth; depth <= maxPly; depth++ {
+		e.negamax(g.currentPlayer, g.board, depth, 0, -infinity, infinity, true)
+		if e.stopped {
+			return
+		}
+		e.prevPV = slices.Clone(e.pv[0][:e.pvLength[0]])
+	}
+}
+
+// Returns the nodes searched by the engine and its helpers so far.
+func (e *Engine) totalNodes(helpers []*Engine) uint64 {
+	nodes := e.nodes
+	for _, helper := range helpers {
+		nodes += helper.publishedNodes.Load()
+	}
+	return nodes
+}
+
+// Makes the line the result's move, score and principal variation.
+func (r *SearchResult) setLine(line PVLine, rootMoves []ValidMove) {
+	for _, m := range rootMoves {
+		if m.asMove() == line.pv[0] {
+			r.move = m
+			break
+		}
+	}
+	r.score = line.score
+	r.pv = line.pv
+}
+
+// Decides how long to think for. soft is the target time: no new iteration is started once half of it has passed.
+// hard is the point at which the search is stopped mid-iteration. Both are zero if the search is not bound by time.
+func allocateTime(limits SearchLimits, color Color) (soft time.Duration, hard time.Duration) {
+	if limits.infinite {
+		return 0, 0
+	}
+	if limits.moveTime > 0 {
+		return limits.moveTime, limits.moveTime
+	}
+	remaining := limits.clock[color]
+	if remaining <= 0 {
+		return 0, 0
+	}
+
+	movesToGo := limits.movesToGo
+	if movesToGo <= 0 {
+		movesToGo = defaultMovesToGo
+	}
+	soft = remaining / time.Duration(movesToGo) + limits.increment[color] * 3 / 4
+	hard = soft * 3
+
+	// Never plan to use more than is left on the clock, keeping a reserve for overhead.
+	available := max(remaining - moveOverhead, remaining / 2)
+	hard = min(hard, available)
+	soft = min(soft, hard)
+	return soft, hard
+}
+
+// Checks whether the search has to stop. The context and clock are only consulted every checkInterval nodes, since
+// both are comparatively expensive.
+func (e *Engine) checkStop() {
+	if e.limits.nodes > 0 && e.nodes >= e.limits.nodes {
+		e.stopped = true
+		return
+	}
+	if e.nodes % checkInterval != 0 {
+		return
+	}
+	e.publishedNodes.Store(e.nodes)
+	if e.ctx.Err() != nil || e.stop != nil && e.stop.Load() {
+		e.stopped = true
+	} else if !e.deadline.IsZero() && time.Now().After(e.deadline) {
+		e.stopped = true
+	}
+}
+
+// Alpha-beta search of the position reached by the line in e.moves, returning its score from the point of view of
+// color. onPV reports whether every move leading here was the previous iteration's principal variation.
+func (e *Engine) negamax(color Color, board Board, depth int, ply int, alpha int, beta int, onPV bool) int {
+	e.pvLength[ply] = ply
+	e.checkStop()
+	if e.stopped {
+		return 0
+	}
+	e.nodes++
+
+	hash := zobristHash(color, board, e.moves)
+	if ply > 0 && e.isRepetition(hash) {
+		return 0
+	}
+	if e.tablebases != nil && ply > 0 {
+		if result, found := e.tablebases.probe(color, board, e.moves); found {
+			return result.score(ply)
+		}
+	}
+	if depth <= 0 || ply >= maxPly {
+		return e.quiesce(color, board, ply, alpha, beta)
+	}
+
+	var hashEntry ttEntry
+	hasHashEntry := false
+	if e.tt != nil {
+		hashEntry, hasHashEntry = e.tt.Probe(hash, ply)
+		// The root is always searched, since the principal variation is needed from it.
+		if hasHashEntry && ply > 0 && int(hashEntry.depth) >= depth {
+			score := int(hashEntry.score)
+			switch {
+			case hashEntry.bound == boundExact,
+				hashEntry.bound == boundLower && score >= beta,
+				hashEntry.bound == boundUpper && score <= alpha:
+				return score
+			}
+		}
+	}
+
+	moves := legalMoves(color, board, e.moves)
+	if len(moves) == 0 {
+		if inCheck(color, board) {
+			return -mateScore + ply
+		}
+		return 0
+	}
+
+	var pvMove, hashMove Move
+	if onPV && ply < len(e.prevPV) {
+		pvMove = e.prevPV[ply]
+	}
+	if hasHashEntry && hashEntry.hasMove() {
+		for _, m := range moves {
+			if hashEntry.matches(m) {
+				hashMove = m.asMove()
+				break
+			}
+		}
+	}
+	e.orderMoves(moves, color, board, ply, pvMove, hashMove)
+
+	origAlpha := alpha
+	bestScore := -infinity
+	var bestMove Move
+	for _, m := range moves {
+		move := m.asMove()
+		if ply == 0 && slices.Contains(e.excluded, move) {
+			continue
+		}
+		e.moves = append(e.moves, move)
+		e.positions = append(e.positions, hash)
+		score := -e.negamax(color.Opponent(), m.newBoard, depth-1, ply+1, -beta, -alpha, onPV && move == pvMove)
+		e.moves = e.moves[:len(e.moves)-1]
+		e.positions = e.positions[:len(e.positions)-1]
+		if e.stopped {
+			return 0
+		}
+
+		if score > bestScore {
+			bestScore = score
+			bestMove = move
+		}
+		if score > alpha {
+			alpha = score
+			e.pv[ply][ply] = move
+			copy(e.pv[ply][ply+1:], e.pv[ply+1][ply+1:e.pvLength[ply+1]])
+			e.pvLength[ply] = e.pvLength[ply+1]
+		}
+		if alpha >= beta {
+			if !isCapture(m, board) {
+				e.recordCutoff(move, color, ply, depth)
+			}
+			break
+		}
+	}
+
+	if e.tt != nil && (ply > 0 || len(e.excluded) == 0) {
+		// With root moves excluded, the root's score is not that of its position.
+		bound := boundExact
+		if bestScore >= beta {
+			bound = boundLower
+		} else if bestScore <= origAlpha {
+			// every move failed low, so none of them is known to be better than the others
+			bound = boundUpper
+			bestMove = Move{}
+		}
+		e.tt.Store(hash, ply, depth, bestScore, bound, bestMove)
+	}
+	return bestScore
+}
+
+// Reports whether the position with the hash occurred before, in the game or in the line searched. It is scored as a
+// draw on its first repetition, since the side that repeated it can repeat it again.
+func (e *Engine) isRepetition(hash uint64) bool {
+	// only every other position has the same player to move
+	for i := len(e.positions) - 2; i >= 0; i -= 2 {
+		if e.positions[i] == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Searches only captures and promotions until the position is quiet, so that the static evaluation is never taken in
+// the middle of an exchange. The player to move may stand pat instead of capturing, so the evaluation is a lower
+// bound of the score. Captures that lose material according to static exchange evaluation are skipped.
+func (e *Engine) quiesce(color Color, board Board, ply int, alpha int, beta int) int {
+	e.pvLength[ply] = ply
+	e.checkStop()
+	if e.stopped {
+		return 0
+	}
+	e.nodes++
+
+	standPat := Evaluate(color, board)
+	if e.skillLevel < maxSkillLevel {
+		standPat += e.evalNoise(color, board)
+	}
+	if standPat >= beta || ply >= maxPly {
+		return standPat
+	}
+	alpha = max(alpha, standPat)
+
+	moves := legalMoves(color, board, e.moves)
+	n := 0
+	for _, m := range moves {
+		if !isCapture(m, board) && m.specialMove != Promotion {
+			continue
+		}
+		if staticExchange(m, board) < 0 {
+			continue
+		}
+		moves[n] = m
+		n++
+	}
+	moves = moves[:n]
+	e.orderMoves(moves, color, board, ply, Move{}, Move{})
+
+	bestScore := standPat
+	for _, m := range moves {
+		e.moves = append(e.moves, m.asMove())
+		score := -e.quiesce(color.Opponent(), m.newBoard, ply+1, -beta, -alpha)
+		e.moves = e.moves[:len(e.moves)-1]
+		if e.stopped {
+			return 0
+		}
+
+		bestScore = max(bestScore, score)
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return bestScore
+}
+
+// Reports whether the move captures a piece on the board it is made from.
+func isCapture(m ValidMove, b Board) bool {
+	_, found := capturedPieceType(m, b)
+	return found
+}
+
+// Returns the type of the piece captured by the move, if any.
+func capturedPieceType(m ValidMove, b Board) (PieceType, bool) {
+	if m.specialMove == EnPassant {
+		return Pawn, true
+	}
+	if p, found := GetCoord(m.dest, b); found && p.color != m.piece.color {
+		return p.pieceType, true
+	}
+	return Pawn, false
+}
+
+// Remembers a quiet move that caused a beta cutoff, so it is tried early in sibling nodes (killers) and wherever else
+// it is legal (history).
+func (e *Engine) recordCutoff(move Move, color Color, ply int, depth int) {
+	if e.killers[ply][0] != move {
+		e.killers[ply][1] = e.killers[ply][0]
+		e.killers[ply][0] = move
+	}
+	h := &e.history[color][move.piece.cc.index()][move.dest.index()]
+	*h += depth * depth
+	if *h > maxHistoryScore {
+		e.ageHistory()
+	}
+}
+
+// Halves every history score, so that what was learned in earlier searches gradually loses weight.
+func (e *Engine) ageHistory() {
+	for c := range e.history {
+		for from := range e.history[c] {
+			for to := range e.history[c][from] {
+				e.history[c][from][to] /= 2
+			}
+		}
+	}
+}
+
+// Move ordering scores. Captures are ordered by most valuable victim first, then least valuable attacker.
+const (
+	pvMoveScore = 4_000_000
+	hashMoveScore = 3_000_000
+	captureScore = 2_000_000
+	firstKillerScore = 1_000_001
+	secondKillerScore = 1_000_000
+	losingCaptureScore = 900_000
+	maxHistoryScore = 500_000
+)
+
+// Rank of each PieceType for capture ordering, which unlike pieceValues gives the king the highest rank.
+var mvvLvaRank = [...]int{
+	Pawn: 1,
+	Knight: 2,
+	Bishop: 3,
+	Rook: 4,
+	Queen: 5,
+	King: 6,
+}
+
+// Sorts moves so that the ones most likely to be best are searched first: the previous principal variation, then the
+// best move stored in the transposition table, then captures and promotions that do not lose material, then killer
+// moves, then losing captures, then quiet moves by their history score. pvMove and hashMove are zero-valued if there
+// is no such move.
+func (e *Engine) orderMoves(moves []ValidMove, color Color, board Board, ply int, pvMove Move, hashMove Move) {
+	scored := make([]scoredMove, len(moves))
+	for i, m := range moves {
+		move := m.asMove()
+		var score int
+		victim, isCapture := capturedPieceType(m, board)
+		if isCapture || m.specialMove == Promotion {
+			// a promotion without capture ranks with capturing a pawn, and the promoted piece decides between them
+			score = mvvLvaRank[victim] * 10 - mvvLvaRank[m.piece.pieceType] + mvvLvaRank[m.promotion] * 100
+			if staticExchange(m, board) >= 0 {
+				score += captureScore
+			} else {
+				score += losingCaptureScore
+			}
+		} else if move == e.killers[ply][0] {
+			score = firstKillerScore
+		} else if move == e.killers[ply][1] {
+			score = secondKillerScore
+		} else {
+			score = e.history[color][m.piece.cc.index()][m.dest.index()]
+		}
+		if move == pvMove {
+			score = pvMoveScore
+		} else if move == hashMove {
+			score = hashMoveScore
+		}
+		scored[i] = scoredMove{m, score}
+	}
+	slices.SortStableFunc(scored, func(a, b scoredMove) int {
+		return b.score - a.score
+	})
+	for i := range scored {
+		moves[i] = scored[i].move
+	}
+}
+
+type scoredMove struct {
+	move ValidMove
+	score int
+}
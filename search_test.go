@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchBestMove(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		depth int
+		wantMove Move
+		// Checked only if non-zero.
+		wantScore int
+	}{
+		{
+			"back rank mate",
+			"7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1",
+			3,
+			Move{piece: Piece{White, Rook, Coord("a1").AsCartesianCoord()}, dest: Coord("a8").AsCartesianCoord()},
+			mateScore - 1,
+		},
+		{
+			"wins hanging queen",
+			"7k/6pp/8/q7/8/8/8/R5K1 w - - 0 1",
+			2,
+			Move{piece: Piece{White, Rook, Coord("a1").AsCartesianCoord()}, dest: Coord("a5").AsCartesianCoord()},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			result, err := NewEngine().Search(context.Background(), g, SearchLimits{depth: tt.depth})
+			if err != nil {
+				t.Fatalf("Search returned error %v", err)
+			}
+			move := result.move.asMove()
+			if move != tt.wantMove {
+				t.Errorf("move got %+v, want %+v", move, tt.wantMove)
+			}
+			if tt.wantScore != 0 && result.score != tt.wantScore {
+				t.Errorf("score got %v, want %v", result.score, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestSearchLimits(t *testing.T) {
+	var tests = []struct{
+		name string
+		limits SearchLimits
+		cancelled bool
+		wantMaxDepth int
+		wantMaxNodes uint64
+	}{
+		{"depth", SearchLimits{depth: 2}, false, 2, 0},
+		{"nodes", SearchLimits{nodes: 500}, false, 0, 500},
+		{"move time", SearchLimits{moveTime: 50 * time.Millisecond}, false, 0, 0},
+		{"clock", SearchLimits{clock: [2]time.Duration{time.Second, time.Second}}, false, 0, 0},
+		{"cancelled", SearchLimits{infinite: true}, true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancelled {
+				cancel()
+			} else {
+				defer cancel()
+			}
+			g := NewGame()
+			start := time.Now()
+			result, err := NewEngine().Search(ctx, g, tt.limits)
+			if err != nil {
+				t.Fatalf("Search returned error %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("search took %v", elapsed)
+			}
+			if _, found := g.FindValidMove(result.move.piece.cc, result.move.dest, result.move.promotion); !found {
+				t.Errorf("move %+v is not valid", result.move)
+			}
+			if tt.wantMaxDepth > 0 && result.depth > tt.wantMaxDepth {
+				t.Errorf("depth got %v, want at most %v", result.depth, tt.wantMaxDepth)
+			}
+			if tt.wantMaxNodes > 0 && result.nodes > tt.wantMaxNodes {
+				t.Errorf("nodes got %v, want at most %v", result.nodes, tt.wantMaxNodes)
+			}
+		})
+	}
+}
+
+func TestAllocateTime(t *testing.T) {
+	var tests = []struct{
+		limits SearchLimits
+		color Color
+		wantSoft time.Duration
+		wantHard time.Duration
+	}{
+		{SearchLimits{}, White, 0, 0},
+		{SearchLimits{infinite: true, clock: [2]time.Duration{time.Minute, time.Minute}}, White, 0, 0},
+		{SearchLimits{moveTime: time.Second}, Black, time.Second, time.Second},
+		{SearchLimits{clock: [2]time.Duration{30 * time.Second, time.Minute}}, White, time.Second, 3 * time.Second},
+		{SearchLimits{clock: [2]time.Duration{30 * time.Second, time.Minute}}, Black, 2 * time.Second, 6 * time.Second},
+		{
+			SearchLimits{clock: [2]time.Duration{30 * time.Second, 0}, increment: [2]time.Duration{2 * time.Second, 0}},
+			White,
+			2500 * time.Millisecond,
+			7500 * time.Millisecond,
+		},
+		{SearchLimits{clock: [2]time.Duration{10 * time.Second, 0}, movesToGo: 2}, White, 5 * time.Second, 9950 * time.Millisecond},
+		{SearchLimits{clock: [2]time.Duration{10 * time.Second, 0}, movesToGo: 1}, White, 9950 * time.Millisecond, 9950 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%+v", tt.limits), func(t *testing.T) {
+			soft, hard := allocateTime(tt.limits, tt.color)
+			if soft != tt.wantSoft || hard != tt.wantHard {
+				t.Errorf("got %v/%v, want %v/%v", soft, hard, tt.wantSoft, tt.wantHard)
+			}
+		})
+	}
+}
+
+func TestQuiescenceSearch(t *testing.T) {
+	// At depth 1 a search without quiescence would stop right after Qxd5 and count the pawn as won.
+	g, err := NewGameFromFEN("6k1/8/4p3/3p4/8/8/8/3Q2K1 w - - 0 1")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	result, err := NewEngine().Search(context.Background(), g, SearchLimits{depth: 1})
+	if err != nil {
+		t.Fatalf("Search returned error %v", err)
+	}
+	if result.move.dest == Coord("d5").AsCartesianCoord() {
+		t.Errorf("engine captured the defended pawn")
+	}
+	if result.score < pieceValues[Queen] - 2*pieceValues[Pawn] - 100 {
+		t.Errorf("score got %v, want about a queen against two pawns", result.score)
+	}
+}
+
+func TestSearchRepetition(t *testing.T) {
+	// Black is a rook up short, but checks on e1 and h4 for ever.
+	const perpetual = "6k1/R4ppp/1R6/4q3/8/8/2Q3P1/6K1 b - - 0 1"
+	var tests = []struct{
+		name string
+		moves []string
+		depth int
+		wantMove string
+	}{
+		{"repetition in the line searched", nil, 5, "e5e1"},
+		// The checks have been given once, so checking on e1 again repeats the position after the first check.
+		{"repetition of the game", []string{"e5e1", "g1h2", "e1h4", "h2g1"}, 1, "h4e1"},
+	}
+
+	for _, tt := range tests {
+		g, err := NewGameFromFEN(perpetual)
+		if err != nil {
+			t.Fatalf("NewGameFromFEN returned error %v", err)
+		}
+		playMoves(t, g, tt.moves...)
+		result, err := NewEngine().Search(context.Background(), g, SearchLimits{depth: tt.depth})
+		if err != nil {
+			t.Fatalf("%v: Search returned error %v", tt.name, err)
+		}
+		if move := result.move.asMove().CoordNotation(); move != tt.wantMove || result.score != 0 {
+			t.Errorf("%v: got %v with score %v, want %v with score 0", tt.name, move, result.score, tt.wantMove)
+		}
+	}
+}
+
+func TestSearchMultiPV(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		multiPV int
+		wantLines int
+	}{
+		{"single", startFEN, 0, 1},
+		{"three", startFEN, 3, 3},
+		// The king has only three moves.
+		{"more than legal moves", "k7/8/8/8/8/8/8/K7 w - - 0 1", 5, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			result, err := NewEngine().Search(context.Background(), g, SearchLimits{depth: 3, multiPV: tt.multiPV})
+			if err != nil {
+				t.Fatalf("Search returned error %v", err)
+			}
+			if len(result.lines) != tt.wantLines {
+				t.Fatalf("got %v lines, want %v", len(result.lines), tt.wantLines)
+			}
+			if result.lines[0].score != result.score || !slices.Equal(result.lines[0].pv, result.pv) {
+				t.Errorf("first line %+v is not the result's line", result.lines[0])
+			}
+			seen := make(map[Move]bool)
+			for i, line := range result.lines {
+				if seen[line.pv[0]] {
+					t.Errorf("line %v starts with %v, which an earlier line already does", i, line.pv[0].CoordNotation())
+				}
+				seen[line.pv[0]] = true
+				if i > 0 && line.score > result.lines[i-1].score {
+					t.Errorf("line %v scores %v, more than the line before it", i, line.score)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchThreads(t *testing.T) {
+	var tests = []struct{
+		name string
+		fen string
+		limits SearchLimits
+		wantMove string
+		// Whether the helpers must have searched too. Short searches can end before they are scheduled.
+		wantHelperNodes bool
+	}{
+		{"mate in one", "7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1", SearchLimits{depth: 3}, "a1a8", false},
+		{"depth", startFEN, SearchLimits{depth: 4}, "", false},
+		{"move time", startFEN, SearchLimits{moveTime: 100 * time.Millisecond}, "", true},
+		{"nodes", startFEN, SearchLimits{nodes: 2000}, "", false},
+	}
+
+	e := NewEngine()
+	e.SetThreads(4)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGameFromFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("NewGameFromFEN returned error %v", err)
+			}
+			start := time.Now()
+			result, err := e.Search(context.Background(), g, tt.limits)
+			if err != nil {
+				t.Fatalf("Search returned error %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("search took %v", elapsed)
+			}
+			if _, found := g.FindValidMove(result.move.piece.cc, result.move.dest, result.move.promotion); !found {
+				t.Errorf("move %+v is not valid", result.move)
+			}
+			if got := result.move.asMove().CoordNotation(); tt.wantMove != "" && got != tt.wantMove {
+				t.Errorf("move got %v, want %v", got, tt.wantMove)
+			}
+			if tt.wantHelperNodes && result.nodes <= e.nodes {
+				t.Errorf("nodes got %v, want more than the %v of the main search", result.nodes, e.nodes)
+			}
+		})
+	}
+}
+
+// Positions for benchmarking the search, from the opening to the endgame.
+var benchmarkFENs = []string{
+	startFEN,
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+	"r1bq1rk1/pp2bppp/2n1pn2/3p4/2PP4/2N2N2/PP2BPPP/R2QKB1R w KQ - 0 8",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+}
+
+// Measures the time to reach a fixed depth, as ns/op, and the nodes searched per second, for each number of threads.
+// With more threads, time to depth should fall and nodes per second rise, as far as the machine has cores.
+func BenchmarkSearchThreads(b *testing.B) {
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%v", threads), func(b *testing.B) {
+			var nodes uint64
+			var elapsed time.Duration
+			e := NewEngine()
+			e.SetThreads(threads)
+			for range b.N {
+				for _, fen := range benchmarkFENs {
+					g, err := NewGameFromFEN(fen)
+					if err != nil {
+						b.Fatalf("NewGameFromFEN returned error %v", err)
+					}
+					e.Clear()
+					result, err := e.Search(context.Background(), g, SearchLimits{depth: 5})
+					if err != nil {
+						b.Fatalf("Search returned error %v", err)
+					}
+					nodes += result.nodes
+					elapsed += result.elapsed
+				}
+			}
+			b.ReportMetric(float64(nodes) / elapsed.Seconds(), "nodes/s")
+		})
+	}
+}
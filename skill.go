@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand/v2"
+)
+
+const (
+	// Strongest skill level, at which the engine plays at full strength.
+	maxSkillLevel = 20
+	// Centipawns of evaluation noise, either way, for each level below the strongest.
+	skillEvalNoise = 10
+	// Centipawns a root move may score below the best one and still be played, for each level below the strongest.
+	skillMoveMargin = 12
+	// Number of best root moves a weakened engine chooses from.
+	skillMultiPV = 4
+	// Nodes searched by the weakest level. Every two levels above it double the nodes.
+	skillBaseNodes = 1000
+	// Rating range the skill levels are taken to span, for choosing a level by rating. The ratings are rough, and
+	// not measured against rated players.
+	minSkillElo = 800
+	maxSkillElo = 2400
+)
+
+// Sets the strength of the engine from 1 to maxSkillLevel. Levels below the strongest search less deep and fewer
+// nodes, blur the evaluation with noise, and pick among several good root moves at random.
+func (e *Engine) SetSkillLevel(level int) {
+	e.skillLevel = max(1, min(level, maxSkillLevel))
+}
+
+// Returns the skill level whose strength is closest to the rating. See minSkillElo.
+func SkillLevelForElo(elo int) int {
+	elo = max(minSkillElo, min(elo, maxSkillElo))
+	return 1 + ((elo - minSkillElo) * (maxSkillLevel - 1) + (maxSkillElo - minSkillElo) / 2) / (maxSkillElo - minSkillElo)
+}
+
+// Tightens the limits of a search to the engine's skill level. Infinite searches are left as they are, since they are
+// for analysis rather than play.
+func (e *Engine) skillLimits(limits SearchLimits) SearchLimits {
+	if e.skillLevel >= maxSkillLevel || limits.infinite {
+		return limits
+	}
+	depth := e.skillLevel / 2 + 1
+	if limits.depth <= 0 || limits.depth > depth {
+		limits.depth = depth
+	}
+	nodes := uint64(skillBaseNodes) << (e.skillLevel / 2)
+	if limits.nodes == 0 || limits.nodes > nodes {
+		limits.nodes = nodes
+	}
+	limits.multiPV = max(limits.multiPV, skillMultiPV)
+	return limits
+}
+
+// Returns the noise added to the evaluation of a position at the engine's skill level. The noise depends only on the
+// position and the search, so that transpositions evaluate alike.
+func (e *Engine) evalNoise(color Color, board Board) int {
+	amplitude := (maxSkillLevel - e.skillLevel) * skillEvalNoise
+	if amplitude <= 0 {
+		return 0
+	}
+	h := zobristHash(color, board, nil) ^ e.noiseSeed
+	// splitmix64 finalizer, so that similar positions get unrelated noise
+	h = (h ^ h >> 30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h >> 27) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return int(h % uint64(2 * amplitude + 1)) - amplitude
+}
+
+// Picks the root move a weakened engine plays from the best lines found. Each line's score is raised by a random
+// amount up to the level's margin, and the highest wins, so the weaker the level, the worse the moves it may play.
+func (e *Engine) pickSkillLine(lines []PVLine) PVLine {
+	margin := (maxSkillLevel - e.skillLevel) * skillMoveMargin
+	best, bestScore := lines[0], -infinity
+	for _, line := range lines {
+		if line.score <= -mateThreshold && lines[0].score > -mateThreshold {
+			// never walk into a mate that can be avoided
+			continue
+		}
+		if score := line.score + rand.IntN(margin + 1); score > bestScore {
+			best, bestScore = line, score
+		}
+	}
+	return best
+}
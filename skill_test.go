@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSkillLevelForElo(t *testing.T) {
+	var tests = []struct{
+		elo int
+		want int
+	}{
+		{0, 1},
+		{minSkillElo, 1},
+		{1600, 11},
+		{maxSkillElo, maxSkillLevel},
+		{3500, maxSkillLevel},
+	}
+
+	for _, tt := range tests {
+		if got := SkillLevelForElo(tt.elo); got != tt.want {
+			t.Errorf("SkillLevelForElo(%v) got %v, want %v", tt.elo, got, tt.want)
+		}
+	}
+}
+
+func TestSkillLimits(t *testing.T) {
+	var tests = []struct{
+		name string
+		level int
+		limits SearchLimits
+		want SearchLimits
+	}{
+		{"full strength", maxSkillLevel, SearchLimits{depth: 30}, SearchLimits{depth: 30}},
+		{"weakest", 1, SearchLimits{}, SearchLimits{depth: 1, nodes: skillBaseNodes, multiPV: skillMultiPV}},
+		{"tighter limits kept", 10, SearchLimits{depth: 2, nodes: 100}, SearchLimits{depth: 2, nodes: 100, multiPV: skillMultiPV}},
+		{"looser limits tightened", 10, SearchLimits{depth: 20, nodes: 1 << 30}, SearchLimits{depth: 6, nodes: skillBaseNodes << 5, multiPV: skillMultiPV}},
+		{"infinite", 1, SearchLimits{infinite: true}, SearchLimits{infinite: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			e.SetSkillLevel(tt.level)
+			if got := e.skillLimits(tt.limits); got != tt.want {
+				t.Errorf("skillLimits got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalNoise(t *testing.T) {
+	e := NewEngine()
+	if noise := e.evalNoise(White, NewGame().board); noise != 0 {
+		t.Errorf("evalNoise at full strength got %v, want 0", noise)
+	}
+
+	e.SetSkillLevel(1)
+	amplitude := (maxSkillLevel - 1) * skillEvalNoise
+	distinct := make(map[int]bool)
+	g := NewGame()
+	for _, m := range g.LegalMoves() {
+		noise := e.evalNoise(Black, m.newBoard)
+		if noise < -amplitude || noise > amplitude {
+			t.Errorf("evalNoise got %v, want at most %v either way", noise, amplitude)
+		}
+		if again := e.evalNoise(Black, m.newBoard); again != noise {
+			t.Errorf("evalNoise of the same position got %v and %v", noise, again)
+		}
+		distinct[noise] = true
+	}
+	if len(distinct) < 10 {
+		t.Errorf("evalNoise got only %v distinct values for 20 positions", len(distinct))
+	}
+}
+
+func TestWeakEngine(t *testing.T) {
+	e := NewEngine()
+	e.SetSkillLevel(1)
+	moves := make(map[Move]bool)
+	for range 20 {
+		result, err := e.Search(context.Background(), NewGame(), SearchLimits{depth: 10})
+		if err != nil {
+			t.Fatalf("Search returned error %v", err)
+		}
+		if result.depth > 1 || result.nodes > skillBaseNodes {
+			t.Errorf("weakest level searched to depth %v with %v nodes", result.depth, result.nodes)
+		}
+		moves[result.move.asMove()] = true
+	}
+	if len(moves) < 2 {
+		t.Errorf("weakest level played the same move in every search")
+	}
+
+	// Random picks never pass up a mate, which level 2 searches deep enough to see.
+	e.SetSkillLevel(2)
+	g, err := NewGameFromFEN("7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	for range 20 {
+		result, err := e.Search(context.Background(), g, SearchLimits{})
+		if err != nil {
+			t.Fatalf("Search returned error %v", err)
+		}
+		if got := result.move.asMove().CoordNotation(); got != "a1a8" {
+			t.Fatalf("level 2 played %v instead of mating with a1a8", got)
+		}
+	}
+}
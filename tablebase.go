@@ -0,0 +1,833 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// Most pieces, kings included, in a tablebase ending.
+	maxTablebasePieces = 5
+	// Stored values. Any other value v is a distance to mate of v-1 plies: an odd distance is a win for the player to
+	// move, an even one a loss, and 0 means the player to move is checkmated.
+	tbDraw uint8 = 0
+	tbUnknown uint8 = 254
+	tbIllegal uint8 = 255
+	// File name extension and header of tablebase files.
+	tablebaseExt = ".tb"
+	tablebaseMagic = "GCTB"
+	tablebaseVersion = 1
+)
+
+// The endings built by default, in an order where every ending's captures and promotions lead to endings before it.
+var defaultTablebases = []string{"KQK", "KRK", "KPK", "KBNK"}
+
+// Endings that are drawn whatever the position, so they need no table.
+var drawnMaterial = []string{"KK", "KBK", "KNK"}
+
+var materialRunes = map[rune]PieceType{
+	'K': King,
+	'Q': Queen,
+	'R': Rook,
+	'B': Bishop,
+	'N': Knight,
+	'P': Pawn,
+}
+
+// Piece types in the order they are named in an ending after the king, strongest first.
+var materialOrder = []PieceType{Queen, Rook, Bishop, Knight, Pawn}
+
+type tbPiece struct {
+	color Color
+	pieceType PieceType
+}
+
+// The pieces of an ending, named as the white pieces followed by the black ones, each starting with the king, e.g.
+// "KBNK". Positions list the squares of the pieces in order: the two kings first, then the other pieces as named.
+type tablebaseMaterial struct {
+	name string
+	pieces []tbPiece
+	hasPawns bool
+	// Number of squares the white king may be on after applying symmetry. See kingSlots.
+	slots int
+}
+
+// Squares the white king is moved to by symmetry. Without pawns, the board can be mirrored and rotated so that the
+// king is in the a1-d1-d4 triangle. Pawns only move one way, so with them the board can only be mirrored so that the
+// king is on files a-d.
+var kingSlots, pawnKingSlots [64]int
+
+// The 8 symmetries of the board, as functions of the square index. Only the first two keep pawns moving the right way.
+var symmetries = []func(sq int) int{
+	func(sq int) int { return sq },
+	func(sq int) int { return sq ^ 7 },
+	func(sq int) int { return sq ^ 56 },
+	func(sq int) int { return sq ^ 63 },
+	func(sq int) int { return transpose(sq) },
+	func(sq int) int { return transpose(sq) ^ 7 },
+	func(sq int) int { return transpose(sq) ^ 56 },
+	func(sq int) int { return transpose(sq) ^ 63 },
+}
+
+func transpose(sq int) int {
+	return (sq & 7) << 3 | sq >> 3
+}
+
+func init() {
+	n, pn := 0, 0
+	for sq := range 64 {
+		kingSlots[sq] = -1
+		pawnKingSlots[sq] = -1
+		x, y := sq & 7, sq >> 3
+		if x < 4 && y <= x {
+			kingSlots[sq] = n
+			n++
+		}
+		if x < 4 {
+			pawnKingSlots[sq] = pn
+			pn++
+		}
+	}
+}
+
+// Parses the name of an ending such as "KQK" or "KBNK".
+func parseMaterial(name string) (tablebaseMaterial, error) {
+	m := tablebaseMaterial{name: name, pieces: []tbPiece{{White, King}, {Black, King}}}
+	if len(name) > maxTablebasePieces {
+		return m, fmt.Errorf("ending %q has more than %v pieces", name, maxTablebasePieces)
+	}
+	blackKing := strings.LastIndex(name, "K")
+	if !strings.HasPrefix(name, "K") || blackKing <= 0 || strings.Count(name, "K") != 2 {
+		return m, fmt.Errorf("ending %q does not name two kings", name)
+	}
+	for i, r := range name {
+		pt, found := materialRunes[r]
+		if !found {
+			return m, fmt.Errorf("ending %q has unknown piece %q", name, r)
+		}
+		if pt == King {
+			continue
+		}
+		color := White
+		if i > blackKing {
+			color = Black
+		}
+		m.pieces = append(m.pieces, tbPiece{color, pt})
+		m.hasPawns = m.hasPawns || pt == Pawn
+	}
+	if len(m.pieces) == 2 {
+		return m, fmt.Errorf("ending %q has only kings", name)
+	}
+	m.slots = 10
+	if m.hasPawns {
+		m.slots = 32
+	}
+	return m, nil
+}
+
+// Number of positions in the table: both sides to move, the white king's slots and every square of the other pieces.
+func (m tablebaseMaterial) size() int {
+	size := 2 * m.slots
+	for range m.pieces[1:] {
+		size *= 64
+	}
+	return size
+}
+
+// Returns the index of the position after applying a symmetry that moves the white king into its slots. When the
+// king is on the diagonal more than one symmetry does, and the one giving the lowest index is used, so that every
+// symmetric image of a position has the same index.
+func (m tablebaseMaterial) index(side Color, sqs []int8) int {
+	slots := &kingSlots
+	candidates := symmetries
+	if m.hasPawns {
+		slots = &pawnKingSlots
+		candidates = symmetries[:2]
+	}
+	best := -1
+	for _, symmetry := range candidates {
+		slot := slots[symmetry(int(sqs[0]))]
+		if slot < 0 {
+			continue
+		}
+		idx := int(side) * m.slots + slot
+		for _, sq := range sqs[1:len(m.pieces)] {
+			idx = idx * 64 + symmetry(int(sq))
+		}
+		if best < 0 || idx < best {
+			best = idx
+		}
+	}
+	return best
+}
+
+// Returns the position at the index. See index.
+func (m tablebaseMaterial) decode(idx int, sqs []int8) Color {
+	for i := len(m.pieces) - 1; i > 0; i-- {
+		sqs[i] = int8(idx & 63)
+		idx >>= 6
+	}
+	slot := idx % m.slots
+	slots := &kingSlots
+	if m.hasPawns {
+		slots = &pawnKingSlots
+	}
+	for sq, s := range slots {
+		if s == slot {
+			sqs[0] = int8(sq)
+			break
+		}
+	}
+	return Color(idx / m.slots)
+}
+
+// Returns the board with the pieces on the squares. Pieces on square -1 have been captured.
+func (m tablebaseMaterial) board(sqs []int8) Board {
+	var b Board
+	for i, p := range m.pieces {
+		if sqs[i] >= 0 {
+			b.players[p.color].pieces[p.pieceType] |= 1 << sqs[i]
+		}
+	}
+	return b
+}
+
+// Reports whether the position can arise in a game: no two pieces share a square, no pawn is on the first or last
+// rank, and the player who just moved is not in check.
+func (m tablebaseMaterial) isLegal(side Color, sqs []int8) bool {
+	var occupied uint64
+	for i, p := range m.pieces {
+		bit := uint64(1) << sqs[i]
+		if occupied & bit != 0 {
+			return false
+		}
+		occupied |= bit
+		if p.pieceType == Pawn && (sqs[i] < 8 || sqs[i] >= 56) {
+			return false
+		}
+	}
+	return !inCheck(side.Opponent(), m.board(sqs))
+}
+
+// Calls visit with every legal move of side: the squares after the move, with a captured piece on square -1, the
+// board after the move, and whether the move keeps the same pieces on the board, i.e. is not a capture or promotion.
+func (m tablebaseMaterial) forEachMove(side Color, sqs []int8, visit func(child []int8, b Board, sameMaterial bool) bool) {
+	b := m.board(sqs)
+	own := b.players[side].occupied()
+	occupied := b.occupied()
+	var child [maxTablebasePieces]int8
+	for i, p := range m.pieces {
+		if p.color != side {
+			continue
+		}
+		from := CartesianCoord{int(sqs[i]) & 7, int(sqs[i]) >> 3}.AsBitCoord()
+		var targets uint64
+		switch p.pieceType {
+		case King:
+			targets = pieceTargets(from, kingOffsets[:], true, occupied)
+		case Knight:
+			targets = pieceTargets(from, knightOffsets[:], true, occupied)
+		case Rook:
+			targets = pieceTargets(from, orthogonalOffsets[:], false, occupied)
+		case Bishop:
+			targets = pieceTargets(from, diagonalOffsets[:], false, occupied)
+		case Queen:
+			targets = pieceTargets(from, orthogonalOffsets[:], false, occupied) |
+				pieceTargets(from, diagonalOffsets[:], false, occupied)
+		case Pawn:
+			forwardX, forwardY := side.Forward()
+			leftX, leftY := side.Left()
+			rightX, rightY := side.Right()
+			opponents := occupied &^ own
+			targets = uint64(from.To(forwardX+leftX, forwardY+leftY) | from.To(forwardX+rightX, forwardY+rightY)) & opponents
+			if step := from.To(forwardX, forwardY); uint64(step) & occupied == 0 {
+				targets |= uint64(step)
+				startY := 1
+				if side == Black {
+					startY = 6
+				}
+				if double := step.To(forwardX, forwardY); int(sqs[i]) >> 3 == startY && uint64(double) & occupied == 0 {
+					targets |= uint64(double)
+				}
+			}
+		}
+		targets &^= own
+
+		for targets != 0 {
+			dest := bits.TrailingZeros64(targets)
+			targets &= targets - 1
+			copy(child[:], sqs)
+			child[i] = int8(dest)
+			sameMaterial := true
+			for j := range m.pieces {
+				if j != i && child[j] == int8(dest) {
+					child[j] = -1
+					sameMaterial = false
+				}
+			}
+			promotions := noPromotion
+			if p.pieceType == Pawn && (dest < 8 || dest >= 56) {
+				promotions = promotionPieceTypes
+				sameMaterial = false
+			}
+			for _, promotion := range promotions {
+				childBoard := m.board(child[:])
+				if promotion != Pawn {
+					childBoard.players[side].pieces[Pawn] &^= 1 << dest
+					childBoard.players[side].pieces[promotion] |= 1 << dest
+				}
+				if inCheck(side, childBoard) {
+					break
+				}
+				if !visit(child[:len(m.pieces)], childBoard, sameMaterial) {
+					return
+				}
+			}
+		}
+	}
+}
+
+var noPromotion = []PieceType{Pawn}
+
+// Returns the squares a piece on from moves to by the offsets, once or sliding until blocked, including the blocking
+// square. Squares of the piece's own color still have to be removed.
+func pieceTargets(from BitCoord, offsets [][2]int, once bool, occupied uint64) uint64 {
+	var targets uint64
+	for _, o := range offsets {
+		for to := from.To(o[0], o[1]); to != 0; to = to.To(o[0], o[1]) {
+			targets |= uint64(to)
+			if once || uint64(to) & occupied != 0 {
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// Calls visit with every position that leads to the given one by a move of the player not to move that is not a
+// capture or promotion, i.e. every position of the same ending that precedes it. En passant is not considered, which
+// only matters for endings where both players have pawns.
+func (m tablebaseMaterial) forEachPredecessor(side Color, sqs []int8, visit func(pred []int8)) {
+	mover := side.Opponent()
+	b := m.board(sqs)
+	occupied := b.occupied()
+	var pred [maxTablebasePieces]int8
+	for i, p := range m.pieces {
+		if p.color != mover {
+			continue
+		}
+		to := CartesianCoord{int(sqs[i]) & 7, int(sqs[i]) >> 3}.AsBitCoord()
+		var origins uint64
+		switch p.pieceType {
+		case King:
+			origins = pieceTargets(to, kingOffsets[:], true, occupied)
+		case Knight:
+			origins = pieceTargets(to, knightOffsets[:], true, occupied)
+		case Rook:
+			origins = pieceTargets(to, orthogonalOffsets[:], false, occupied)
+		case Bishop:
+			origins = pieceTargets(to, diagonalOffsets[:], false, occupied)
+		case Queen:
+			origins = pieceTargets(to, orthogonalOffsets[:], false, occupied) |
+				pieceTargets(to, diagonalOffsets[:], false, occupied)
+		case Pawn:
+			backX, backY := mover.Backward()
+			step := to.To(backX, backY)
+			doubleY := 3
+			if mover == Black {
+				doubleY = 4
+			}
+			if uint64(step) & occupied == 0 && step != 0 {
+				y := bits.TrailingZeros64(uint64(step)) >> 3
+				if y != 0 && y != 7 {
+					origins |= uint64(step)
+				}
+				if int(sqs[i]) >> 3 == doubleY {
+					origins |= uint64(step.To(backX, backY))
+				}
+			}
+		}
+		origins &^= occupied
+
+		for origins != 0 {
+			from := bits.TrailingZeros64(origins)
+			origins &= origins - 1
+			copy(pred[:], sqs)
+			pred[i] = int8(from)
+			if !inCheck(side, m.board(pred[:])) {
+				visit(pred[:len(m.pieces)])
+			}
+		}
+	}
+}
+
+// Tablebase holds the value of every position of one ending.
+type Tablebase struct {
+	material tablebaseMaterial
+	values []uint8
+}
+
+// Tablebases is a set of endgame tables that can be probed for positions with few pieces.
+type Tablebases struct {
+	tables map[string]*Tablebase
+}
+
+func NewTablebases() *Tablebases {
+	return &Tablebases{tables: make(map[string]*Tablebase)}
+}
+
+// Returns the names of the endings in the set, sorted.
+func (tbs *Tablebases) Names() []string {
+	names := make([]string, 0, len(tbs.tables))
+	for name := range tbs.tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Outcome of a position with best play, from the point of view of the player to move.
+type TablebaseResult struct {
+	// 1 if the player to move wins, -1 if they lose and 0 for a draw.
+	wdl int
+	// Plies until mate, or 0 for a draw.
+	dtm int
+}
+
+func tablebaseResult(v uint8) TablebaseResult {
+	if v == tbDraw {
+		return TablebaseResult{}
+	}
+	dtm := int(v) - 1
+	if dtm % 2 == 1 {
+		return TablebaseResult{1, dtm}
+	}
+	return TablebaseResult{-1, dtm}
+}
+
+func (r TablebaseResult) String() string {
+	switch r.wdl {
+	case 1:
+		return fmt.Sprintf("mate in %v", (r.dtm + 1) / 2)
+	case -1:
+		return fmt.Sprintf("mated in %v", r.dtm / 2)
+	}
+	return "draw"
+}
+
+// Returns the score of the result for the search at the given ply, in the same terms as the search's own mate scores.
+func (r TablebaseResult) score(ply int) int {
+	switch r.wdl {
+	case 1:
+		return mateScore - ply - r.dtm
+	case -1:
+		return -mateScore + ply + r.dtm
+	}
+	return 0
+}
+
+// Returns the white and black part of the name of the ending on the board, e.g. "KBN" and "K".
+func materialNames(b Board) (string, string) {
+	var names [2]string
+	for c := range b.players {
+		names[c] = "K"
+		for _, pt := range materialOrder {
+			names[c] += strings.Repeat(string(pieceTypeFENRunes[pt] - 'a' + 'A'), bits.OnesCount64(b.players[c].pieces[pt]))
+		}
+	}
+	return names[White], names[Black]
+}
+
+// Returns the board with the colors swapped and mirrored top to bottom, which has the same value with the other
+// player to move.
+func swapColors(b Board) Board {
+	var swapped Board
+	for c := range b.players {
+		for pt, pieces := range b.players[c].pieces {
+			swapped.players[1-c].pieces[pt] = bits.ReverseBytes64(pieces)
+		}
+	}
+	return swapped
+}
+
+// Looks up the value of the position, whether the ending is stored with its colors as on the board or swapped.
+// Castling and en passant are not considered. Returns false if the set has no table for the ending.
+func (tbs *Tablebases) probeBoard(side Color, b Board) (uint8, bool) {
+	white, black := materialNames(b)
+	if slices.Contains(drawnMaterial, white + black) || slices.Contains(drawnMaterial, black + white) {
+		return tbDraw, true
+	}
+	t, found := tbs.tables[white + black]
+	if !found {
+		if t, found = tbs.tables[black + white]; !found {
+			return 0, false
+		}
+		b = swapColors(b)
+		side = side.Opponent()
+	}
+
+	var sqs [maxTablebasePieces]int8
+	for i, p := range t.material.pieces {
+		pieces := &b.players[p.color].pieces[p.pieceType]
+		sqs[i] = int8(bits.TrailingZeros64(*pieces))
+		*pieces &= *pieces - 1
+	}
+	return t.values[t.material.index(side, sqs[:])], true
+}
+
+// Looks up the position reached by the game moves, with color to move. Positions where castling or en passant is
+// possible are not in the tables.
+func (tbs *Tablebases) probe(color Color, b Board, gameMoves []Move) (TablebaseResult, bool) {
+	if bits.OnesCount64(b.occupied()) > maxTablebasePieces || castlingRights(b, gameMoves) != 0 {
+		return TablebaseResult{}, false
+	}
+	if _, found := enPassantFile(color, b, gameMoves); found {
+		return TablebaseResult{}, false
+	}
+	v, found := tbs.probeBoard(color, b)
+	if !found || v == tbIllegal || v == tbUnknown {
+		return TablebaseResult{}, false
+	}
+	return tablebaseResult(v), true
+}
+
+// Looks up the game's current position. Returns false if it is not in any table.
+func (tbs *Tablebases) Probe(g *Game) (TablebaseResult, bool) {
+	return tbs.probe(g.currentPlayer, g.board, g.moves)
+}
+
+// Returns the move that keeps the best result in a position that is in the tables: the quickest mate when winning,
+// the slowest when losing, and any move that keeps the draw otherwise.
+func (tbs *Tablebases) BestMove(g *Game) (ValidMove, TablebaseResult, bool) {
+	result, found := tbs.Probe(g)
+	if !found {
+		return ValidMove{}, result, false
+	}
+	var best ValidMove
+	bestScore := -infinity
+	moves := slices.Clone(g.moves)
+	for _, m := range g.LegalMoves() {
+		childResult, found := tbs.probe(g.currentPlayer.Opponent(), m.newBoard, append(moves, m.asMove()))
+		if !found {
+			return ValidMove{}, result, false
+		}
+		if score := -childResult.score(1); score > bestScore {
+			best = m
+			bestScore = score
+		}
+	}
+	return best, result, true
+}
+
+// Generates the table of the ending, first generating the tables of the endings its captures and promotions lead to.
+// Tables already in the set are kept.
+func (tbs *Tablebases) Generate(name string) error {
+	m, err := parseMaterial(name)
+	if err != nil {
+		return err
+	}
+	if _, found := tbs.tables[name]; found {
+		return nil
+	}
+	for _, sub := range subMaterials(m) {
+		if slices.Contains(drawnMaterial, sub) {
+			continue
+		}
+		if err := tbs.Generate(sub); err != nil {
+			return err
+		}
+	}
+	t, err := tbs.generate(m)
+	if err != nil {
+		return err
+	}
+	tbs.tables[name] = t
+	return nil
+}
+
+// Returns the names of the endings that captures and promotions lead to, with the stronger side as White.
+func subMaterials(m tablebaseMaterial) []string {
+	var subs []string
+	add := func(pieces []tbPiece) {
+		var names [2]string
+		for _, c := range Colors {
+			names[c] = "K"
+			for _, pt := range materialOrder {
+				for _, p := range pieces {
+					if p.color == c && p.pieceType == pt {
+						names[c] += string(pieceTypeFENRunes[pt] - 'a' + 'A')
+					}
+				}
+			}
+		}
+		name := strongerFirst(names[White], names[Black])
+		if !slices.Contains(subs, name) {
+			subs = append(subs, name)
+		}
+	}
+	for i, p := range m.pieces {
+		if p.pieceType == King {
+			continue
+		}
+		add(slices.Delete(slices.Clone(m.pieces), i, i+1))
+		if p.pieceType == Pawn {
+			for _, promotion := range promotionPieceTypes {
+				pieces := slices.Clone(m.pieces)
+				pieces[i].pieceType = promotion
+				add(pieces)
+			}
+		}
+	}
+	return subs
+}
+
+// Names an ending with the side with more material first, which is how tables are stored.
+func strongerFirst(a string, b string) string {
+	strength := func(s string) int {
+		total := 0
+		for _, r := range s {
+			total += pieceValues[materialRunes[r]]
+		}
+		return total
+	}
+	if strength(b) > strength(a) || (strength(b) == strength(a) && b > a) {
+		return b + a
+	}
+	return a + b
+}
+
+// Builds the table of an ending by retrograde analysis. Positions are resolved in order of their distance to mate:
+// first the checkmates, then the positions one ply before them, and so on. A position is a win as soon as one of its
+// moves leads to a resolved loss, and a loss once all of its moves lead to resolved wins. Positions never resolved are
+// draws. Moves that capture or promote lead to other endings, whose tables must already be in the set.
+func (tbs *Tablebases) generate(m tablebaseMaterial) (*Tablebase, error) {
+	t := &Tablebase{material: m, values: make([]uint8, m.size())}
+	values := t.values
+	// buckets[d] holds positions found to be d plies from mate. A position can be added more than once; only the
+	// first, and so shortest, distance counts.
+	buckets := make([][]uint32, tbUnknown - 1)
+	enqueue := func(idx int, d int) {
+		if d < len(buckets) {
+			buckets[d] = append(buckets[d], uint32(idx))
+		}
+	}
+	var missing error
+	// Returns the value of a position that another ending's table holds.
+	probeOther := func(side Color, b Board) uint8 {
+		v, found := tbs.probeBoard(side, b)
+		if !found {
+			white, black := materialNames(b)
+			missing = fmt.Errorf("ending %v needs the table of %v", m.name, strongerFirst(white, black))
+		}
+		return v
+	}
+
+	var sqs [maxTablebasePieces]int8
+	for idx := range values {
+		side := m.decode(idx, sqs[:])
+		// Positions that have a symmetric image with a lower index are never looked up.
+		if !m.isLegal(side, sqs[:]) || m.index(side, sqs[:]) != idx {
+			values[idx] = tbIllegal
+			continue
+		}
+		values[idx] = tbUnknown
+		hasMove, sameMaterial, allLost := false, false, true
+		win, loss := -1, 0
+		m.forEachMove(side, sqs[:], func(child []int8, b Board, same bool) bool {
+			hasMove = true
+			if same {
+				sameMaterial = true
+				return true
+			}
+			v := probeOther(side.Opponent(), b)
+			switch r := tablebaseResult(v); {
+			case v == tbDraw:
+				allLost = false
+			case r.wdl < 0 && (win < 0 || r.dtm + 1 < win):
+				win = r.dtm + 1
+			case r.wdl > 0:
+				loss = max(loss, r.dtm + 1)
+			}
+			return true
+		})
+		switch {
+		case !hasMove && inCheck(side, m.board(sqs[:])):
+			enqueue(idx, 0)
+		case !hasMove:
+			values[idx] = tbDraw
+		case win >= 0:
+			enqueue(idx, win)
+		case !sameMaterial && allLost:
+			enqueue(idx, loss)
+		case !sameMaterial:
+			values[idx] = tbDraw
+		}
+	}
+	if missing != nil {
+		return nil, missing
+	}
+
+	// Returns the distance to mate if every move of the position leads to a win for the opponent.
+	lossDistance := func(idx int) (int, bool) {
+		var sqs [maxTablebasePieces]int8
+		side := m.decode(idx, sqs[:])
+		loss := 0
+		m.forEachMove(side, sqs[:], func(child []int8, b Board, same bool) bool {
+			var v uint8
+			if same {
+				v = values[m.index(side.Opponent(), child)]
+			} else {
+				v = probeOther(side.Opponent(), b)
+			}
+			if v == tbDraw || v == tbUnknown || tablebaseResult(v).wdl < 0 {
+				loss = -1
+				return false
+			}
+			loss = max(loss, int(v))
+			return true
+		})
+		return loss, loss > 0
+	}
+
+	var preds []int
+	for d := range buckets {
+		for i := 0; i < len(buckets[d]); i++ {
+			idx := int(buckets[d][i])
+			if values[idx] != tbUnknown {
+				continue
+			}
+			values[idx] = uint8(d + 1)
+			side := m.decode(idx, sqs[:])
+			preds = preds[:0]
+			m.forEachPredecessor(side, sqs[:], func(pred []int8) {
+				if predIdx := m.index(side.Opponent(), pred); values[predIdx] == tbUnknown && !slices.Contains(preds, predIdx) {
+					preds = append(preds, predIdx)
+				}
+			})
+			for _, predIdx := range preds {
+				if d % 2 == 0 {
+					enqueue(predIdx, d + 1)
+				} else if loss, isLoss := lossDistance(predIdx); isLoss {
+					enqueue(predIdx, loss)
+				}
+			}
+		}
+		buckets[d] = nil
+	}
+
+	for idx, v := range values {
+		if v == tbUnknown {
+			values[idx] = tbDraw
+		}
+	}
+	return t, nil
+}
+
+// Writes the table: a header with the ending's name and number of positions, then the values compressed with
+// DEFLATE. Most positions of an ending are a few distances apart, so the values compress well.
+func (t *Tablebase) write(w io.Writer) error {
+	header := []byte(tablebaseMagic)
+	header = append(header, tablebaseVersion, byte(len(t.material.name)))
+	header = append(header, t.material.name...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(t.values)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	fw, err := flate.NewWriter(w, flate.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(t.values); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+var errNotTablebase = errors.New("not a tablebase file")
+
+// Reads a table written by write.
+func readTablebase(r io.Reader) (*Tablebase, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(tablebaseMagic) + 2)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(tablebaseMagic)]) != tablebaseMagic {
+		return nil, errNotTablebase
+	}
+	if header[len(tablebaseMagic)] != tablebaseVersion {
+		return nil, fmt.Errorf("unsupported tablebase version %v", header[len(tablebaseMagic)])
+	}
+	name := make([]byte, header[len(tablebaseMagic)+1])
+	if _, err := io.ReadFull(br, name); err != nil {
+		return nil, errNotTablebase
+	}
+	m, err := parseMaterial(string(name))
+	if err != nil {
+		return nil, err
+	}
+	var size uint32
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+		return nil, errNotTablebase
+	}
+	if int(size) != m.size() {
+		return nil, fmt.Errorf("tablebase %v has %v positions, want %v", m.name, size, m.size())
+	}
+	t := Tablebase{material: m, values: make([]uint8, size)}
+	if _, err := io.ReadFull(flate.NewReader(br), t.values); err != nil {
+		return nil, fmt.Errorf("tablebase %v: %w", m.name, err)
+	}
+	return &t, nil
+}
+
+// Writes every table of the set to the directory, one file per ending.
+func (tbs *Tablebases) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, t := range tbs.tables {
+		f, err := os.Create(filepath.Join(dir, name + tablebaseExt))
+		if err != nil {
+			return err
+		}
+		err = t.write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads every table in the directory.
+func LoadTablebases(dir string) (*Tablebases, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*" + tablebaseExt))
+	if err != nil {
+		return nil, err
+	}
+	tbs := NewTablebases()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		t, err := readTablebase(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		tbs.tables[t.material.name] = t
+	}
+	return tbs, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+var (
+	testTablebasesOnce sync.Once
+	testTablebasesSet *Tablebases
+	testTablebasesErr error
+)
+
+// Returns the tables up to KPK, generated once for all tests since that takes a few seconds.
+func testTablebases(t *testing.T) *Tablebases {
+	t.Helper()
+	testTablebasesOnce.Do(func() {
+		testTablebasesSet = NewTablebases()
+		testTablebasesErr = testTablebasesSet.Generate("KPK")
+	})
+	if testTablebasesErr != nil {
+		t.Fatalf("Generate returned error %v", testTablebasesErr)
+	}
+	return testTablebasesSet
+}
+
+func TestParseMaterial(t *testing.T) {
+	var tests = []struct{
+		name string
+		wantPieces []tbPiece
+		wantErr bool
+	}{
+		{"KQK", []tbPiece{{White, King}, {Black, King}, {White, Queen}}, false},
+		{"KBNK", []tbPiece{{White, King}, {Black, King}, {White, Bishop}, {White, Knight}}, false},
+		{"KRKP", []tbPiece{{White, King}, {Black, King}, {White, Rook}, {Black, Pawn}}, false},
+		{"KK", nil, true},
+		{"KQ", nil, true},
+		{"QKK", nil, true},
+		{"KXK", nil, true},
+		{"KQRBNK", nil, true},
+	}
+
+	for _, tt := range tests {
+		m, err := parseMaterial(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMaterial(%q) got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !slices.Equal(m.pieces, tt.wantPieces) {
+			t.Errorf("parseMaterial(%q) got pieces %v, want %v", tt.name, m.pieces, tt.wantPieces)
+		}
+	}
+}
+
+func TestTablebaseIndexSymmetry(t *testing.T) {
+	for _, name := range []string{"KQK", "KPK"} {
+		m, err := parseMaterial(name)
+		if err != nil {
+			t.Fatalf("parseMaterial returned error %v", err)
+		}
+		candidates := symmetries
+		if m.hasPawns {
+			candidates = symmetries[:2]
+		}
+		// White king on c3, on the diagonal where more than one symmetry keeps it in its slots.
+		sqs := []int8{18, 60, 13}
+		want := m.index(Black, sqs)
+		for i, symmetry := range candidates {
+			transformed := make([]int8, len(sqs))
+			for j, sq := range sqs {
+				transformed[j] = int8(symmetry(int(sq)))
+			}
+			if got := m.index(Black, transformed); got != want {
+				t.Errorf("%v index with symmetry %v got %v, want %v", name, i, got, want)
+			}
+		}
+		var decoded [maxTablebasePieces]int8
+		if side := m.decode(want, decoded[:]); side != Black || m.index(side, decoded[:len(sqs)]) != want {
+			t.Errorf("%v decode of %v got side %v and squares %v, which do not index to it", name, want, side, decoded)
+		}
+	}
+}
+
+func TestTablebaseProbe(t *testing.T) {
+	tbs := testTablebases(t)
+	var tests = []struct{
+		name string
+		fen string
+		want TablebaseResult
+		wantFound bool
+	}{
+		{"mate in one", "7k/8/6K1/8/8/8/8/R7 w - - 0 1", TablebaseResult{1, 1}, true},
+		{"mated in one", "7k/8/6K1/8/8/8/8/R7 b - - 0 1", TablebaseResult{-1, 2}, true},
+		{"checkmated", "R6k/8/6K1/8/8/8/8/8 b - - 0 1", TablebaseResult{-1, 0}, true},
+		{"stalemate", "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", TablebaseResult{}, true},
+		{"mate in two", "7k/8/5K2/8/8/8/8/R7 w - - 0 1", TablebaseResult{1, 3}, true},
+		{"black queen", "8/8/8/8/8/8/k7/q6K w - - 0 1", TablebaseResult{-1, 0}, false},
+		{"pawn wins", "4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", TablebaseResult{-1, 0}, false},
+		{"pawn draws", "8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", TablebaseResult{}, true},
+		{"bare kings", "8/8/8/4k3/8/8/8/4K3 w - - 0 1", TablebaseResult{}, true},
+		{"castling", "4k3/8/8/8/8/8/8/4K2R w K - 0 1", TablebaseResult{}, false},
+		{"too many pieces", "", TablebaseResult{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame()
+			if tt.fen != "" {
+				var err error
+				if g, err = NewGameFromFEN(tt.fen); err != nil {
+					t.Fatalf("NewGameFromFEN returned error %v", err)
+				}
+			}
+			got, found := tbs.Probe(g)
+			if tt.want.dtm == 0 && tt.want.wdl != 0 {
+				// Only the result is checked, not the distance.
+				if !found || got.wdl != tt.want.wdl {
+					t.Errorf("Probe got %+v, found %v, want result %v", got, found, tt.want.wdl)
+				}
+				return
+			}
+			if found != tt.wantFound || got != tt.want {
+				t.Errorf("Probe got %+v, found %v, want %+v, found %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+// Checks every position of the tables against the results of its moves, generated by the game rather than by the
+// tablebase code.
+func TestTablebaseConsistency(t *testing.T) {
+	tbs := testTablebases(t)
+	for _, name := range []string{"KQK", "KPK"} {
+		tb := tbs.tables[name]
+		m := tb.material
+		var sqs [maxTablebasePieces]int8
+		for idx, v := range tb.values {
+			if v == tbIllegal {
+				continue
+			}
+			side := m.decode(idx, sqs[:])
+			g := &Game{currentPlayer: side, board: m.board(sqs[:])}
+			g.validMoves = computeValidMoves(side, g.board, g.moves, true)
+			if castlingRights(g.board, g.moves) != 0 {
+				continue
+			}
+
+			var want TablebaseResult
+			moves := g.LegalMoves()
+			if len(moves) == 0 && inCheck(side, g.board) {
+				want = TablebaseResult{-1, 0}
+			} else if len(moves) > 0 {
+				best := -infinity
+				for _, move := range moves {
+					childValue, found := tbs.probeBoard(side.Opponent(), move.newBoard)
+					if !found {
+						t.Fatalf("%v position %v has a move to a position not in the tables", name, sqs[:len(m.pieces)])
+					}
+					best = max(best, -tablebaseResult(childValue).score(1))
+				}
+				if best > 0 {
+					want = TablebaseResult{1, mateScore - best}
+				} else if best < 0 {
+					want = TablebaseResult{-1, mateScore + best}
+				}
+			}
+			if got := tablebaseResult(v); got != want {
+				t.Fatalf("%v position %v with %v to move got %+v, want %+v", name, sqs[:len(m.pieces)], side, got, want)
+			}
+		}
+	}
+}
+
+func TestTablebaseSaveAndLoad(t *testing.T) {
+	tbs := testTablebases(t)
+	dir := t.TempDir()
+	if err := tbs.Save(dir); err != nil {
+		t.Fatalf("Save returned error %v", err)
+	}
+	loaded, err := LoadTablebases(dir)
+	if err != nil {
+		t.Fatalf("LoadTablebases returned error %v", err)
+	}
+	if !slices.Equal(loaded.Names(), tbs.Names()) {
+		t.Fatalf("LoadTablebases got tables %v, want %v", loaded.Names(), tbs.Names())
+	}
+	for _, name := range tbs.Names() {
+		if !bytes.Equal(loaded.tables[name].values, tbs.tables[name].values) {
+			t.Errorf("loaded table %v differs from the saved one", name)
+		}
+	}
+
+	if _, err := readTablebase(bytes.NewReader([]byte("not a table"))); !errors.Is(err, errNotTablebase) {
+		t.Errorf("readTablebase of other data got error %v, want %v", err, errNotTablebase)
+	}
+	if _, err := LoadTablebases(dir + "/missing"); err == nil {
+		t.Errorf("LoadTablebases of a missing directory got no error")
+	}
+}
+
+func TestEngineTablebaseMoves(t *testing.T) {
+	e := NewEngine()
+	e.SetTablebases(testTablebases(t))
+
+	g, err := NewGameFromFEN("8/8/8/8/3k4/8/8/K6R w - - 0 1")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	for range 40 {
+		result, err := e.Search(context.Background(), g, SearchLimits{depth: 1})
+		if errors.Is(err, errNoLegalMoves) {
+			break
+		} else if err != nil {
+			t.Fatalf("Search returned error %v", err)
+		}
+		if result.nodes != 0 || result.score < mateThreshold && result.score > -mateThreshold {
+			t.Fatalf("Search got score %v after %v nodes, want a mate score without searching", result.score, result.nodes)
+		}
+		g.ExecuteValidMove(result.move)
+	}
+	if result, _, _ := g.Result(); result != "1-0" {
+		t.Errorf("tablebase play did not mate, position %v", g.FEN())
+	}
+}
@@ -203,6 +203,20 @@ type PieceSet struct {
 	minX int
 	minY int
 	pieces []string
+	// A single character for each piece, to list pieces in text, indexed by Color and then PieceType.
+	glyphs [2][]string
+}
+
+// Unicode chess symbols, which go with drawings of the pieces.
+var unicodeGlyphs = [2][]string{
+	{"♙", "♖", "♘", "♗", "♕", "♔"},
+	{"♟", "♜", "♞", "♝", "♛", "♚"},
+}
+
+// The letters of the pieces in FEN, which go with pieces drawn as letters.
+var letterGlyphs = [2][]string{
+	{"P", "R", "N", "B", "Q", "K"},
+	{"p", "r", "n", "b", "q", "k"},
 }
 
 func generatePieceSets() []*PieceSet {
@@ -217,6 +231,7 @@ func generatePieceSets() []*PieceSet {
 			elevenbytenQueen,
 			elevenbytenKing,
 		},
+		glyphs: unicodeGlyphs,
 	}
 	sevenbyninePieces := PieceSet{
 		minX: 7,
@@ -229,6 +244,7 @@ func generatePieceSets() []*PieceSet {
 			sevenbynineQueen,
 			sevenbynineKing,
 		},
+		glyphs: unicodeGlyphs,
 	}
 	sixbysixPieces := PieceSet{
 		minX: 6,
@@ -241,6 +257,7 @@ func generatePieceSets() []*PieceSet {
 			sixbysixQueen,
 			sixbysixKing,
 		},
+		glyphs: unicodeGlyphs,
 	}
 	threebythreePieces := PieceSet{
 		minX: 3,
@@ -253,6 +270,7 @@ func generatePieceSets() []*PieceSet {
 			threebythreeQueen,
 			threebythreeKing,
 		},
+		glyphs: letterGlyphs,
 	}
 
 	return []*PieceSet{
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+	"unsafe"
+)
+
+const (
+	// Default size of the engine's transposition table.
+	defaultHashSizeMB = 16
+	// Number of locks guarding the entries. Each lock guards every ttLockStripes-th slot, so that searches on several
+	// goroutines rarely wait for each other.
+	ttLockStripes = 1024
+)
+
+// How a stored score relates to the true score of the position.
+type Bound uint8
+const (
+	// The score is exact.
+	boundExact Bound = iota
+	// The search failed high: the true score is at least the stored score.
+	boundLower
+	// The search failed low: the true score is at most the stored score.
+	boundUpper
+)
+
+// A search result for one position. Moves are stored as origin and destination square indexes and promotion type to
+// keep entries small; a zero-valued entry has no move, as a move from a square to itself is never valid.
+type ttEntry struct {
+	key uint64
+	score int32
+	from uint8
+	dest uint8
+	promotion uint8
+	depth int8
+	bound Bound
+	generation uint8
+}
+
+func (te ttEntry) hasMove() bool {
+	return te.from != te.dest
+}
+
+// Reports whether the entry's move is the given move.
+func (te ttEntry) matches(m ValidMove) bool {
+	return te.hasMove() && m.piece.cc.index() == int(te.from) && m.dest.index() == int(te.dest) &&
+		m.promotion == PieceType(te.promotion)
+}
+
+// TranspositionTable stores search results keyed by Zobrist hash, so that positions reached by different move orders
+// are only searched once. Each hash maps to a single slot. A slot is overwritten when the new result was searched at
+// least as deep as the stored one, or when the stored one is left over from an earlier search. Probe and Store may be
+// called from several goroutines at once.
+type TranspositionTable struct {
+	entries []ttEntry
+	locks [ttLockStripes]sync.Mutex
+	mask uint64
+	generation uint8
+}
+
+// Creates a table using at most sizeMB megabytes. The number of entries is rounded down to a power of two.
+func NewTranspositionTable(sizeMB int) *TranspositionTable {
+	n := uint64(sizeMB) * 1024 * 1024 / uint64(unsafe.Sizeof(ttEntry{}))
+	size := uint64(1)
+	for size * 2 <= n {
+		size *= 2
+	}
+	return &TranspositionTable{
+		entries: make([]ttEntry, size),
+		mask: size - 1,
+	}
+}
+
+// Marks the start of a new search, so that entries from earlier searches are replaced first. Neither this nor Clear
+// may be called while a search is using the table.
+func (tt *TranspositionTable) NewSearch() {
+	tt.generation++
+}
+
+// Empties the table, e.g. for a new game.
+func (tt *TranspositionTable) Clear() {
+	clear(tt.entries)
+	tt.generation = 0
+}
+
+// Looks up the entry stored for the hash. Mate scores are stored relative to the position, and are converted back to
+// be relative to the root using the ply the position was reached at.
+func (tt *TranspositionTable) Probe(key uint64, ply int) (ttEntry, bool) {
+	slot := key & tt.mask
+	lock := &tt.locks[slot % ttLockStripes]
+	lock.Lock()
+	entry := tt.entries[slot]
+	lock.Unlock()
+	if entry.key != key {
+		return ttEntry{}, false
+	}
+	entry.score = int32(scoreFromTT(int(entry.score), ply))
+	return entry, true
+}
+
+// Stores a search result, subject to the replacement scheme described on TranspositionTable.
+func (tt *TranspositionTable) Store(key uint64, ply int, depth int, score int, bound Bound, move Move) {
+	lock := &tt.locks[key & tt.mask % ttLockStripes]
+	lock.Lock()
+	defer lock.Unlock()
+	slot := &tt.entries[key & tt.mask]
+	if slot.generation == tt.generation && int(slot.depth) > depth {
+		return
+	}
+	entry := ttEntry{
+		key: key,
+		score: int32(scoreToTT(score, ply)),
+		depth: int8(depth),
+		bound: bound,
+		generation: tt.generation,
+	}
+	if move != (Move{}) {
+		entry.from = uint8(move.piece.cc.index())
+		entry.dest = uint8(move.dest.index())
+		entry.promotion = uint8(move.promotion)
+	} else if slot.key == key {
+		// keep the move of an earlier search of this position for move ordering
+		entry.from, entry.dest, entry.promotion = slot.from, slot.dest, slot.promotion
+	}
+	*slot = entry
+}
+
+// Mate scores count plies from the root. In the table they count plies from the stored position instead, since the
+// same position can be reached at different plies.
+func scoreToTT(score int, ply int) int {
+	if score >= mateThreshold {
+		return score + ply
+	} else if score <= -mateThreshold {
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score int, ply int) int {
+	if score >= mateThreshold {
+		return score - ply
+	} else if score <= -mateThreshold {
+		return score + ply
+	}
+	return score
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestZobristHash(t *testing.T) {
+	var tests = []struct{
+		name string
+		a []string
+		b []string
+		wantEqual bool
+	}{
+		{"transposition", []string{"e2e4", "e7e5", "g1f3"}, []string{"g1f3", "e7e5", "e2e4"}, true},
+		{"side to move", []string{"g1f3", "g8f6", "f3g1", "f6g8"}, []string{}, true},
+		{"different side to move", []string{"g1f3", "g8f6", "f3g1"}, []string{"g1f3"}, false},
+		{"lost castling rights", []string{"g1f3", "g8f6", "h1g1", "f6g8", "g1h1", "g8f6", "f3g1", "f6g8"}, []string{}, false},
+		{
+			"en passant",
+			[]string{"e2e4", "a7a6", "e4e5", "d7d5"},
+			[]string{"e2e4", "a7a6", "e4e5", "d7d5", "g1f3", "g8f6", "f3g1", "f6g8"},
+			false,
+		},
+		{
+			"en passant not possible",
+			[]string{"e2e4", "a7a6", "e4e5", "h7h5"},
+			[]string{"e2e4", "a7a6", "e4e5", "h7h5", "g1f3", "g8f6", "f3g1", "f6g8"},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NewGame(), NewGame()
+			playMoves(t, a, tt.a...)
+			playMoves(t, b, tt.b...)
+			hashA := zobristHash(a.currentPlayer, a.board, a.moves)
+			hashB := zobristHash(b.currentPlayer, b.board, b.moves)
+			if (hashA == hashB) != tt.wantEqual {
+				t.Errorf("hashes %x and %x, want equal %v", hashA, hashB, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestTranspositionTable(t *testing.T) {
+	tt := NewTranspositionTable(1)
+	if len(tt.entries) & (len(tt.entries) - 1) != 0 {
+		t.Errorf("entries got %v, want a power of two", len(tt.entries))
+	}
+	move := Move{piece: Piece{White, Pawn, Coord("e2").AsCartesianCoord()}, dest: Coord("e4").AsCartesianCoord()}
+	other := Move{piece: Piece{White, Knight, Coord("g1").AsCartesianCoord()}, dest: Coord("f3").AsCartesianCoord()}
+	key := uint64(0x1234)
+	collision := key + uint64(len(tt.entries))
+
+	if _, found := tt.Probe(key, 0); found {
+		t.Errorf("Probe of empty table found an entry")
+	}
+
+	tt.Store(key, 0, 4, 35, boundExact, move)
+	entry, found := tt.Probe(key, 0)
+	if !found || entry.depth != 4 || entry.score != 35 || entry.bound != boundExact {
+		t.Errorf("Probe got %+v %v", entry, found)
+	}
+	if _, found := tt.Probe(collision, 0); found {
+		t.Errorf("Probe of colliding key found an entry")
+	}
+
+	// shallower results of the same search do not replace deeper ones
+	tt.Store(collision, 0, 3, 10, boundLower, other)
+	if _, found := tt.Probe(key, 0); !found {
+		t.Errorf("deeper entry replaced by shallower one")
+	}
+	tt.Store(collision, 0, 5, 10, boundLower, other)
+	if _, found := tt.Probe(collision, 0); !found {
+		t.Errorf("shallower entry not replaced by deeper one")
+	}
+
+	// anything replaces results of earlier searches
+	tt.NewSearch()
+	tt.Store(key, 0, 1, 20, boundUpper, Move{})
+	entry, found = tt.Probe(key, 0)
+	if !found || entry.depth != 1 {
+		t.Errorf("entry of earlier search not replaced, got %+v %v", entry, found)
+	}
+
+	// mate scores are stored relative to the position
+	tt.Store(key, 3, 2, mateScore - 5, boundExact, move)
+	entry, _ = tt.Probe(key, 1)
+	if entry.score != mateScore - 3 {
+		t.Errorf("mate score got %v, want %v", entry.score, mateScore - 3)
+	}
+
+	tt.Clear()
+	if _, found := tt.Probe(key, 0); found {
+		t.Errorf("Probe after Clear found an entry")
+	}
+}
+
+func TestTranspositionTableReducesNodes(t *testing.T) {
+	var positions = []struct{
+		name string
+		moves []string
+	}{
+		{"start", []string{}},
+		{"open game", []string{"e2e4", "e7e5", "g1f3", "b8c6"}},
+		{"queen's gambit", []string{"d2d4", "d7d5", "c2c4", "e7e6", "b1c3", "g8f6"}},
+		{"open center", []string{"e2e4", "e7e5", "d2d4", "e5d4", "d1d4", "b8c6", "d4e3", "g8f6"}},
+	}
+	limits := SearchLimits{depth: 4}
+
+	// The table can occasionally cost nodes in a single position, as its cutoffs change the bounds that the rest of the
+	// search sees, so the reduction is measured over all positions together.
+	var totalWith, totalWithout uint64
+	for _, p := range positions {
+		g := NewGame()
+		playMoves(t, g, p.moves...)
+
+		withTable := NewEngine()
+		withResult, err := withTable.Search(context.Background(), g, limits)
+		if err != nil {
+			t.Fatalf("%v: Search returned error %v", p.name, err)
+		}
+		withoutTable := NewEngine()
+		withoutTable.SetHashSize(0)
+		withoutResult, err := withoutTable.Search(context.Background(), g, limits)
+		if err != nil {
+			t.Fatalf("%v: Search returned error %v", p.name, err)
+		}
+
+		t.Logf("%v: nodes with table %v, without %v", p.name, withResult.nodes, withoutResult.nodes)
+		if withResult.score != withoutResult.score {
+			t.Errorf("%v: score with table %v, without %v", p.name, withResult.score, withoutResult.score)
+		}
+		totalWith += withResult.nodes
+		totalWithout += withoutResult.nodes
+	}
+	if totalWith >= totalWithout {
+		t.Errorf("total nodes with table %v, want fewer than without %v", totalWith, totalWithout)
+	}
+}
+
+func TestTranspositionTableConcurrent(t *testing.T) {
+	tt := NewTranspositionTable(1)
+	// Every goroutine stores the same score for a key, so a probe that finds the key must see that score, whichever
+	// goroutine stored it.
+	scoreOf := func(key uint64) int {
+		return int(key % 1000)
+	}
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20000 {
+				key := uint64(i * 7 + g) % 5000 + 1
+				tt.Store(key, 0, i % 10, scoreOf(key), boundExact, Move{})
+				if entry, found := tt.Probe(key ^ 1, 0); found && int(entry.score) != scoreOf(key ^ 1) {
+					t.Errorf("Probe of %v got score %v, want %v", key ^ 1, entry.score, scoreOf(key ^ 1))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -1,53 +1,151 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/rivo/tview"
 	"github.com/gdamore/tcell/v2"
+	"log/slog"
 	"os"
-	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// How long the engine thinks for each of its moves, unless told otherwise.
+const engineMoveTime = 2 * time.Second
+
+// How long the engine thinks for a hint.
+const hintMoveTime = 500 * time.Millisecond
+
+// How long the engine thinks about each position when analysing a game.
+const analysisMoveTime = 300 * time.Millisecond
+
+// Number of best lines the analysis panel shows.
+const analysisPanelLines = 3
+
+// Steepness of the curve that maps evaluations onto the eval bar and graph, which is that of the usual estimate of
+// White's winning chances from centipawns. Small advantages show clearly, while won positions do not push the bar past
+// its ends.
+const evalCurve = 0.00368208
+
 type State struct {
 	app *tview.Application
+	// Runs f on the UI goroutine and redraws the screen, without waiting for it. Searches running in the background
+	// show their results through it, so they never block on the UI goroutine while it waits for them to stop, as in
+	// CancelGameLoop.
+	queueUpdate func(f func())
 	pieceSet *PieceSet
 	squareWidth int
 	squareHeight int
 	game *Game
 	squares [][]*tview.TextView
+	// Black is shown at the bottom of the board if flipped. The labels of the ranks on either side of the board are
+	// indexed by the row of squares they are next to, and those of the files by the column. See boardSquare.
+	flipped bool
+	rankLabels [8][2]*tview.TextView
+	fileLabels [8][2]*tview.TextView
 	currentPlayer *tview.TextView
 	currentPlayerStatus *tview.TextView
 	history *tview.TextView
+	// Lists the book moves of the current position. See UpdateBookUi.
+	bookMoves *tview.TextView
+	// Lists the pieces each side has captured and how far ahead in material it is. See UpdateMaterialUi.
+	material *tview.TextView
+	book *OpeningBook
+	// Endgame tablebases used to show the result of the current position. Nil if there are none.
+	tablebases *Tablebases
+	input *tview.InputField
+	// The square a piece is dragged from with the mouse, while dragging. See PressSquare.
+	dragFrom CartesianCoord
+	dragging bool
+	// The square the keyboard cursor is on, drawn in cursorColor while showCursor. See MoveCursor.
+	cursor CartesianCoord
+	showCursor bool
+	cursorColor tcell.Color
+	// The opponents the engine can be, and the one currently playing. See CycleOpponent.
+	opponents []Searcher
+	engine Searcher
+	// Who plays each side, indexed by Color: the human at the keyboard through human, the engine, or any other
+	// controller, such as a player on the network. engineMoveTime is how long the engine thinks about each move.
+	controllers [2]Controller
+	engineMoveTime time.Duration
+	human *HumanController
+	// Cancels the game loop, if it is running, and loopDone is closed once it has returned. See StartGameLoop.
+	loopCancel context.CancelFunc
+	loopDone chan struct{}
+	// The built-in engine suggests moves to the human player, independently of the opponent, searching within
+	// hintLimits. hint is the move it suggested for the current position, if hasHint. See ShowHint.
+	hintEngine *Engine
+	hintLimits SearchLimits
+	hint ValidMove
+	hasHint bool
+	hintCancel context.CancelFunc
+	hintDone chan struct{}
+	// The analysis of the game, shown in the history once done, or nil. See StartAnalysis.
+	analysis *AnnotatedGame
+	analysisCancel context.CancelFunc
+	analysisDone chan struct{}
+	// Lists the engine's best lines for the position shown, while showLines. The search runs until the position shown
+	// changes, and linesFEN is the position it is for. See UpdateLines.
+	lines *tview.TextView
+	showLines bool
+	linesEngine *Engine
+	linesFEN string
+	linesCancel context.CancelFunc
+	linesDone chan struct{}
+	// Number of moves of the game, after any setup, played out on the board while browsing the history, or -1 to show
+	// the current position. See BrowseTo.
+	shownMoves int
+	// Evaluations of the positions of the game from White's point of view, keyed by the number of moves played to reach
+	// them. They are found by the engine as it plays, by the analysis panel and by analysing the game. See recordEval.
+	evals map[int]int
+	// Shows the evaluation of the position shown, and evalGraph that of every position of the game. See drawEvalBar
+	// and drawEvalGraph.
+	evalBar *tview.Box
+	evalGraph *tview.Box
+	evalWhiteColor tcell.Color
+	evalBlackColor tcell.Color
+	evalShownColor tcell.Color
 	redStatusColor tcell.Color
-	greenBbgColor tcell.Color
-	beigeBgColor tcell.Color
+	darkSquareColor tcell.Color
+	lightSquareColor tcell.Color
 	whitePieceColor tcell.Color
 	blackPieceColor tcell.Color
-	squareDefaultGreenStyle tcell.Style
-	squareDefaultBeigeStyle tcell.Style
+	squareDefaultDarkStyle tcell.Style
+	squareDefaultLightStyle tcell.Style
 	squareHighlightStyle tcell.Style
 	squareValidMoveStyle tcell.Style
-	logger *log.Logger
+	squareWarningStyle tcell.Style
+	squareLastMoveStyle tcell.Style
+	logger *slog.Logger
 }
 
 func UpdateBoardUi (state *State) {
+	game := shownGame(state)
+	for i := range 8 {
+		state.rankLabels[i][0].SetText(string(byte('1' + boardSquare(state, i, 0).Y)))
+		state.rankLabels[i][1].SetText(string(byte('1' + boardSquare(state, i, 0).Y)))
+		state.fileLabels[i][0].SetText(string(byte('a' + boardSquare(state, 0, i).X)))
+		state.fileLabels[i][1].SetText(string(byte('a' + boardSquare(state, 0, i).X)))
+	}
 	for y := range 8 {
 		for x := range 8 {
-			square := state.squares[7-y][x]
-			bgColor := state.beigeBgColor
+			square := squareView(state, CartesianCoord{x, y})
+			bgColor := state.lightSquareColor
 			if y % 2 == x % 2 {
-			bgColor = state.greenBbgColor
+			bgColor = state.darkSquareColor
 			}
 			square.SetBackgroundColor(bgColor)
 
-			p, occupied := GetCoord(CartesianCoord{x, y}, state.game.board)
+			p, occupied := GetCoord(CartesianCoord{x, y}, game.board)
 			if occupied {
 				color := state.blackPieceColor
 				if p.color == White {
 					color = state.whitePieceColor
 				}
-				// tview y=0 corresponds to the top row of the grid, not the bottom. We must transform between tview
-				// coord to game coord where y=0 is the bottom row.
 				square.SetTextColor(color)
 				square.SetText(state.pieceSet.pieces[p.pieceType])
 				topPadding := (state.squareHeight - state.pieceSet.minY) / 2
@@ -59,17 +157,202 @@ func UpdateBoardUi (state *State) {
 		}
 	}
 
-	state.currentPlayer.SetText(state.game.currentPlayer.String())
-	state.currentPlayerStatus.SetText(state.game.currentPlayerStatus)
+	currentPlayerText := game.currentPlayer.String()
+	if !humanPlays(state, game.currentPlayer) {
+		currentPlayerText += fmt.Sprintf(" (%v)", playerName(state, game.currentPlayer))
+	}
+	if state.tablebases != nil {
+		if result, found := state.tablebases.Probe(game); found {
+			currentPlayerText += ", " + result.String()
+		}
+	}
+	state.currentPlayer.SetText(currentPlayerText)
+	state.currentPlayerStatus.SetText(statusText(state, game))
+	UpdateBookUi(state, game)
+	UpdateMaterialUi(state, game)
+	UpdateLines(state, game)
+	GridStateUpdater(state.input.GetText(), state)
+}
+
+// Returns the square of the board shown at a row and column of state.squares. Row 0 is the top of the screen, while
+// rank 1 is y=0 of the board, so this and screenSquare are where the board is turned to either side.
+func boardSquare(state *State, row int, col int) CartesianCoord {
+	if state.flipped {
+		return CartesianCoord{7-col, row}
+	}
+	return CartesianCoord{col, 7-row}
+}
+
+// Returns the row and column of state.squares where a square of the board is shown. See boardSquare.
+func screenSquare(state *State, cc CartesianCoord) (int, int) {
+	if state.flipped {
+		return cc.Y, 7-cc.X
+	}
+	return 7-cc.Y, cc.X
+}
+
+// Returns the view that shows a square of the board.
+func squareView(state *State, cc CartesianCoord) *tview.TextView {
+	row, col := screenSquare(state, cc)
+	return state.squares[row][col]
+}
+
+// Turns the board around, so that the other side is at the bottom.
+func FlipBoard(state *State) {
+	state.flipped = !state.flipped
+	state.logger.Info("board flipped", "flipped", state.flipped)
+	UpdateBoardUi(state)
+}
+
+// Shows the board from the side of the human player when they play one side against the engine, which means Black is
+// at the bottom if the engine plays White. The board stays as it is if the engine plays both sides or neither.
+func orientForHuman(state *State) {
+	if humanPlays(state, White) != humanPlays(state, Black) {
+		state.flipped = humanPlays(state, Black)
+	}
+}
+
+// Returns whether the human at the keyboard plays the color.
+func humanPlays(state *State, color Color) bool {
+	return state.controllers[color] == state.human
+}
+
+// Returns the engine controller of the color, if an engine plays it, built-in or external.
+func engineOf(state *State, color Color) (*EngineController, bool) {
+	switch c := state.controllers[color].(type) {
+	case *EngineController:
+		return c, true
+	case *UCIController:
+		return &c.EngineController, true
+	}
+	return nil, false
+}
+
+// Returns the name of whoever plays the color, e.g. for the players of a saved game.
+func playerName(state *State, color Color) string {
+	if engine, ok := engineOf(state, color); ok {
+		return engine.searcher.Name()
+	}
+	switch state.controllers[color].(type) {
+	case RandomController:
+		return "Random"
+	case *NetworkController:
+		return "Network"
+	}
+	return "Human"
+}
+
+// Returns the game as shown on the board: the game itself, or while browsing the history, a replay of it up to the
+// move browsed to.
+func shownGame(state *State) *Game {
+	if state.shownMoves < 0 {
+		return state.game
+	}
+	return state.game.replayTo(state.shownMoves)
+}
+
+// Returns the number of moves played in the game, not counting the moves that set up the starting position.
+func playedMoves(state *State) int {
+	return len(state.game.moves) - state.game.setupMoves
+}
+
+// Returns the number of moves played to reach the position shown.
+func shownPly(state *State) int {
+	if state.shownMoves < 0 {
+		return playedMoves(state)
+	}
+	return state.shownMoves
+}
+
+// Returns the status of the game shown, which while browsing the history tells how to get back to the current position.
+func statusText(state *State, game *Game) string {
+	if state.shownMoves < 0 {
+		// draws by rule other than stalemate leave the status of the player to move as it is
+		if _, reason, over := game.Result(); over && game.currentPlayerStatus != "CHECKMATE" && game.currentPlayerStatus != "DRAW" {
+			return reason
+		}
+		return game.currentPlayerStatus
+	}
+	return fmt.Sprintf("move %v of %v, PgDn forward", state.shownMoves, playedMoves(state))
+}
+
+// Lists the opening book moves of the position shown with their share of the total weight.
+func UpdateBookUi(state *State, game *Game) {
+	if state.book == nil {
+		state.bookMoves.SetText("no book loaded")
+		return
+	}
+	moves := state.book.Moves(game)
+	if len(moves) == 0 {
+		state.bookMoves.SetText("out of book")
+		return
+	}
+	total := 0
+	for _, m := range moves {
+		total += m.weight
+	}
+	text := ""
+	for _, m := range moves {
+		percent := 0
+		if total > 0 {
+			percent = m.weight * 100 / total
+		}
+		text += fmt.Sprintf("%v %3v%% (%v)\n", m.move.asMove().CoordNotation(), percent, m.weight)
+	}
+	state.bookMoves.SetText(text)
+}
+
+// Lists the pieces each side has captured in the position shown, most valuable first, in the glyphs of the piece set,
+// and after those of the side ahead in material, by how many points.
+func UpdateMaterialUi(state *State, game *Game) {
+	captured := CapturedPieces(game.board)
+	difference := MaterialDifference(game.board)
+	text := ""
+	for _, color := range []Color{White, Black} {
+		// the pieces a side captured are those the other side lost
+		lost := color.Opponent()
+		text += color.String() + ": "
+		for _, pt := range []PieceType{Queen, Rook, Bishop, Knight, Pawn} {
+			text += strings.Repeat(state.pieceSet.glyphs[lost][pt], captured[lost][pt])
+		}
+		if color == White && difference > 0 {
+			text += fmt.Sprintf(" +%v", difference)
+		} else if color == Black && difference < 0 {
+			text += fmt.Sprintf(" +%v", -difference)
+		}
+		text += "\n"
+	}
+	state.material.SetText(text)
 }
 
 // Checks that the positions entered are valid and that they are owned by the current player. textToCheck will contain
-// 1-4 runes, where 1-2 is the piece to move, and 3-4 is the destination. If the 2nd rune does not correspond to a piece
-// owned by the current player, it will be rejected. If the 3rd or 4th rune does not corrrespond to a valid move the
-// selected piece can make, it will be rejected.
+// 1-5 runes, where 1-2 is the piece to move, 3-4 is the destination, and 5 is the piece type to promote a pawn to. If
+// the 2nd rune does not correspond to a piece owned by the current player, it will be rejected. If the 3rd or 4th rune
+// does not corrrespond to a valid move the selected piece can make, it will be rejected. The 5th rune is only accepted
+// for promotions.
 func MoveChecker (textToCheck string, lastChar rune, state *State) bool {
 	var p Piece
 
+	if !humanPlays(state, state.game.currentPlayer) {
+		// someone else is moving for the current player
+		return false
+	}
+	if state.shownMoves >= 0 {
+		// moves can only be entered in the current position, not while browsing the history
+		return false
+	}
+
+	if len(textToCheck) == 5 {
+		promotion, isPromotion := PromotionPieceType(lastChar)
+		if !isPromotion {
+			return false
+		}
+		_, found := state.game.FindValidMove(
+			Coord(textToCheck[0:2]).AsCartesianCoord(), Coord(textToCheck[2:4]).AsCartesianCoord(), promotion,
+		)
+		return found
+	}
+
 	// Quick checks for generally valid input first
 	if len(textToCheck) % 2 == 1 && (lastChar < 'a' || lastChar > 'h') {
 		return false
@@ -84,7 +367,7 @@ func MoveChecker (textToCheck string, lastChar rune, state *State) bool {
 	if len(textToCheck) >= 2 {
 		c := Coord(textToCheck[0:2])
 		if !c.IsValid() {
-			state.logger.Panicf("bug in move checking validation: %+v", c)
+			logBug(state.logger, "bug in move checking validation", "coord", c)
 		}
 		pos := c.AsCartesianCoord()
 		// Check if the position has a piece owned by current player
@@ -109,7 +392,7 @@ func MoveChecker (textToCheck string, lastChar rune, state *State) bool {
 
 		c := Coord(textToCheck[2:4])
 		if !c.IsValid() {
-			state.logger.Panicf("bug in move checking validation: %+v", c)
+			logBug(state.logger, "bug in move checking validation", "coord", c)
 		}
 		pos := c.AsCartesianCoord()
 		for _, v := range validMoves {
@@ -123,142 +406,911 @@ func MoveChecker (textToCheck string, lastChar rune, state *State) bool {
 	return true
 }
 
-// Updates UI with highlights for potential pieces, selected piece, and valid moves for selected piece.
+// Updates the highlights of the board for the position shown and the move being entered. They are drawn in layers
+// from the last move played, through the hint, the selected piece and the squares it can move to, up to a king in
+// check and a piece the entered move leaves hanging, each over the ones before it.
 func GridStateUpdater (text string, state *State) {
-	validMoves := []ValidMove{}
-
-	var px1, py1, px2, py2 int
-	var cc1 CartesianCoord
+	var cc1, cc2 CartesianCoord
 	if len(text) == 1 {
-		px1 = int(text[0]-'a')
+		cc1.X = int(text[0]-'a')
 	}
 	if len(text) > 1 {
 		c := Coord(text[0:2])
 		if !c.IsValid() {
-			state.logger.Panicf("bug in move checking validation: %+v", c)
+			logBug(state.logger, "bug in move checking validation", "coord", c)
 		}
 		cc1 = c.AsCartesianCoord()
-		px1 = cc1.X
-		py1 = 7 - cc1.Y
-	}
-	if len(text) >= 2 && len(text) < 4 {
-		// we need valid moves if only the target piece was selected, or if the first part of the destination was
-		// selected
-		p, _ := GetCoord(cc1, state.game.board)
-		validMoves = state.game.GetValidMovesForPiece(p)
-	}
-	if len(text) == 3 {
-		px2 = int(text[2]-'a')
 	}
 	if len(text) > 3 {
 		c := Coord(text[2:4])
 		if !c.IsValid() {
-			state.logger.Panicf("bug in move checking validation: %+v", c)
+			logBug(state.logger, "bug in move checking validation", "coord", c)
+		}
+		cc2 = c.AsCartesianCoord()
+	}
+
+	// the layers from the bottom up, each drawn over the ones before it
+	game := shownGame(state)
+	var layers []highlightLayer
+	if len(game.moves) > game.setupMoves {
+		last := game.moves[len(game.moves)-1]
+		layers = append(layers, highlightLayer{[]CartesianCoord{last.piece.cc, last.dest}, state.squareLastMoveStyle})
+	}
+	if len(text) == 0 && state.hasHint && state.shownMoves < 0 {
+		// highlight the hinted move until the player starts entering one
+		layers = append(layers, highlightLayer{[]CartesianCoord{state.hint.piece.cc}, state.squareHighlightStyle})
+		layers = append(layers, highlightLayer{[]CartesianCoord{state.hint.dest}, state.squareValidMoveStyle})
+	}
+	if len(text) == 1 {
+		// highlight the chosen column
+		var column []CartesianCoord
+		for y := range 8 {
+			column = append(column, CartesianCoord{cc1.X, y})
+		}
+		layers = append(layers, highlightLayer{column, state.squareHighlightStyle})
+	}
+	if len(text) >= 2 {
+		// highlight the chosen piece, and the squares it can move to until the destination is entered
+		layers = append(layers, highlightLayer{[]CartesianCoord{cc1}, state.squareHighlightStyle})
+		var dests []CartesianCoord
+		if len(text) < 4 {
+			p, _ := GetCoord(cc1, state.game.board)
+			for _, v := range state.game.GetValidMovesForPiece(p) {
+				dests = append(dests, v.dest)
+			}
+		} else {
+			dests = append(dests, cc2)
+		}
+		layers = append(layers, highlightLayer{dests, state.squareValidMoveStyle})
+	}
+	var king CartesianCoord
+	if game.currentPlayerStatus == "CHECK" || game.currentPlayerStatus == "CHECKMATE" {
+		king = BitCoord(game.board.players[game.currentPlayer].pieces[King]).AsCartesianCoord()
+		layers = append(layers, highlightLayer{[]CartesianCoord{king}, state.squareWarningStyle})
+	}
+
+	state.currentPlayerStatus.SetText(statusText(state, game))
+	if len(text) == 0 && state.hasHint && state.shownMoves < 0 {
+		state.currentPlayerStatus.SetText("hint: " + state.hint.asMove().CoordNotation())
+	}
+	if move, found := enteredMove(text, state); found {
+		if hanging, isHanging := state.game.HangingPiece(move); isHanging {
+			layers = append(layers, highlightLayer{[]CartesianCoord{hanging.cc}, state.squareWarningStyle})
+			state.currentPlayerStatus.SetText(fmt.Sprintf("hangs %v on %v", hanging.pieceType, hanging.cc.AsCoord()))
 		}
-		pos := c.AsCartesianCoord()
-		px2 = pos.X
-		py2 = 7 - pos.Y
 	}
 
-	var targetStyle tcell.Style
 	for y := range 8 {
 		for x := range 8 {
-			square := state.squares[y][x]
-
-			// target is default style based on square until we match a specific override style
-			targetStyle = state.squareDefaultGreenStyle
+			square := squareView(state, CartesianCoord{x, y})
+			square.Box.SetBorderStyle(state.squareDefaultLightStyle)
 			if y % 2 == x % 2 {
-				targetStyle = state.squareDefaultBeigeStyle
+				square.Box.SetBorderStyle(state.squareDefaultDarkStyle)
 			}
-
-			if len(text) == 1 && px1 == x {
-				// highlight the chosen column
-				targetStyle = state.squareHighlightStyle
-			} else if len(text) >= 2 && px1 == x && py1 == y {
-				// highlight the chosen piece
-				targetStyle = state.squareHighlightStyle
-			}
-			if len(text) == 4 && px2 == x && py2 == y {
-				targetStyle = state.squareValidMoveStyle
-			}
-
-			square.Box.SetBorderStyle(targetStyle)
+			square.SetTitle("")
+		}
+	}
+	for _, layer := range layers {
+		for _, cc := range layer.squares {
+			squareView(state, cc).Box.SetBorderStyle(layer.style)
 		}
 	}
+	if game.currentPlayerStatus == "CHECKMATE" {
+		// the mated king is marked by name as well, as red alone is also a check or a hanging piece
+		squareView(state, king).SetTitle("mate")
+	}
+	if state.showCursor {
+		// the border is drawn in lines of the cursor color over the background of whatever the square shows
+		squareView(state, state.cursor).Box.SetBorderColor(state.cursorColor)
+	}
+}
 
-	if len(text) >= 2 {
-		// We can now iterate over valid moves and override those specific squares
-		for _, v := range validMoves {
-			square := state.squares[7-v.dest.Y][v.dest.X]
-			square.Box.SetBorderStyle(state.squareValidMoveStyle)
+// A highlight of some squares of the board, drawn as their border in style. See GridStateUpdater.
+type highlightLayer struct {
+	squares []CartesianCoord
+	style tcell.Style
+}
+
+// Returns the valid move entered as text, which has 4 runes, or 5 for a promotion. A promotion entered without the 5th
+// rune is to a queen.
+func enteredMove(text string, state *State) (ValidMove, bool) {
+	if len(text) < 4 {
+		return ValidMove{}, false
+	}
+	from, dest := Coord(text[0:2]), Coord(text[2:4])
+	if !from.IsValid() || !dest.IsValid() {
+		return ValidMove{}, false
+	}
+	if len(text) == 5 {
+		promotion, isPromotion := PromotionPieceType(rune(text[4]))
+		if !isPromotion {
+			return ValidMove{}, false
 		}
+		return state.game.FindValidMove(from.AsCartesianCoord(), dest.AsCartesianCoord(), promotion)
 	}
+	if move, found := state.game.FindValidMove(from.AsCartesianCoord(), dest.AsCartesianCoord(), Pawn); found {
+		return move, true
+	}
+	return state.game.FindValidMove(from.AsCartesianCoord(), dest.AsCartesianCoord(), Queen)
 }
 
 func ProcessMove(key tcell.Key, inputField *tview.InputField, state *State) {
 	if key == tcell.KeyESC {
+		state.showCursor = false
 		inputField.SetText("")
 		return
 	}
 	text := inputField.GetText()
 	if key == tcell.KeyEnter {
-		if len(text) != 4 {
+		if len(text) < 4 {
 			return
 		}
-		p := Coord(text[0:2])
-		d := Coord(text[2:4])
-		if !p.IsValid() || !d.IsValid() {
-			state.logger.Panicf("unexpected coord in entered input %v", text)
+		chosenMove, found := enteredMove(text, state)
+		if !found {
+			logBug(state.logger, "entered move has no matching valid move", "text", text)
 		}
 
-		pcc, dcc := p.AsCartesianCoord(), d.AsCartesianCoord()
-		piece, hasPiece := GetCoord(pcc, state.game.board)
-		if !hasPiece {
-			state.logger.Panicf("unexpected entered starting coord with no piece %v", text)
+		inputField.SetText("")
+		if !state.human.Submit(chosenMove) {
+			state.logger.Warn("dropping move entered while no move was expected", "text", text)
 		}
+	}
 
-		moves, found := state.game.validMoves[piece]
-		if !found {
-			state.logger.Panicf("unexpected piece chosen with no valid moves %v", text)
-		}
-		var chosenMove ValidMove
-		found = false
-		for _, m := range moves {
-			if m.piece == piece && m.dest == dcc {
-				chosenMove = m
-				found = true
-				break
+}
+
+// Returns the square of the board at a screen position, if there is one there.
+func squareAt(state *State, x int, y int) (CartesianCoord, bool) {
+	for row := range 8 {
+		for col := range 8 {
+			if state.squares[row][col].InRect(x, y) {
+				return boardSquare(state, row, col), true
 			}
 		}
+	}
+	return CartesianCoord{}, false
+}
 
-		if !found {
-			state.logger.Panicf("unexpected entered dest coord with no matching move %v", text)
+// Handles a press of the mouse on a square of the board. Pressing a piece of the player to move selects it, as typing
+// its square does, and pressing a square it can move to then plays the move. Pressing any other square clears the
+// selection. A selected piece can also be dragged to its destination, see ReleaseSquare.
+func PressSquare(state *State, cc CartesianCoord) {
+	state.dragging = false
+	if SelectSquare(state, cc) {
+		state.dragFrom, state.dragging = cc, true
+	}
+}
+
+// Selects a square, by mouse or with the keyboard cursor: with a piece selected, a square it can move to plays the
+// move, and a square with another piece of the current player selects that piece instead. Any other square clears
+// the selection. Returns whether a piece is selected afterwards.
+func SelectSquare(state *State, cc CartesianCoord) bool {
+	square := string(cc.AsCoord())
+	text := state.input.GetText()
+	if len(text) == 2 && playEnteredMove(state, text + square) {
+		return false
+	}
+	if MoveChecker(square, rune(square[1]), state) {
+		state.input.SetText(square)
+		return true
+	}
+	state.input.SetText("")
+	return false
+}
+
+// Moves the keyboard cursor by dx squares to the right and dy squares up the screen, staying on the board, and shows
+// it. The first move of a hidden cursor only shows it where it is.
+func MoveCursor(state *State, dx int, dy int) {
+	if state.showCursor {
+		row, col := screenSquare(state, state.cursor)
+		state.cursor = boardSquare(state, min(max(row - dy, 0), 7), min(max(col + dx, 0), 7))
+	}
+	state.showCursor = true
+	GridStateUpdater(state.input.GetText(), state)
+}
+
+// Handles the keys that move the keyboard cursor and select squares with it, which are the arrow keys or the vi keys
+// hjkl, and Enter or Space, returning nil for those and event for any other key. As h is also a file, it only moves a
+// cursor that is shown, and Esc hides the cursor to type it. Enter and Space only select with the cursor shown.
+func cursorKey(state *State, event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyLeft:
+		MoveCursor(state, -1, 0)
+	case tcell.KeyRight:
+		MoveCursor(state, 1, 0)
+	case tcell.KeyUp:
+		MoveCursor(state, 0, 1)
+	case tcell.KeyDown:
+		MoveCursor(state, 0, -1)
+	case tcell.KeyEnter:
+		// a complete move typed in is entered as usual
+		if !state.showCursor || len(state.input.GetText()) >= 4 {
+			return event
+		}
+		SelectSquare(state, state.cursor)
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case 'j':
+			MoveCursor(state, 0, -1)
+		case 'k':
+			MoveCursor(state, 0, 1)
+		case 'l':
+			MoveCursor(state, 1, 0)
+		case 'h':
+			if !state.showCursor {
+				return event
+			}
+			MoveCursor(state, -1, 0)
+		case ' ':
+			if !state.showCursor {
+				return event
+			}
+			SelectSquare(state, state.cursor)
+		default:
+			return event
+		}
+	default:
+		return event
+	}
+	return nil
+}
+
+// Handles the release of the mouse, on a square of the board if onBoard. Releasing a piece dragged from its square onto
+// another square plays the move there, if it is valid; releasing it on its own square leaves it selected.
+func ReleaseSquare(state *State, cc CartesianCoord, onBoard bool) {
+	if !state.dragging {
+		return
+	}
+	state.dragging = false
+	if onBoard && cc != state.dragFrom {
+		playEnteredMove(state, string(state.dragFrom.AsCoord()) + string(cc.AsCoord()))
+	}
+}
+
+// Plays a move given in coordinate notation, e.g. "e2e4", as if it were typed and entered, if it is a move that can be
+// entered now. Pawns are promoted to queens. Returns whether the move was played.
+func playEnteredMove(state *State, text string) bool {
+	if !MoveChecker(text, rune(text[len(text)-1]), state) {
+		return false
+	}
+	state.input.SetText(text)
+	ProcessMove(tcell.KeyEnter, state.input, state)
+	return true
+}
+
+// Executes a valid move for the current player, as played in the game loop, and updates the UI to match.
+func ApplyMove(move ValidMove, state *State) {
+	CancelHint(state)
+	CancelAnalysis(state)
+	state.shownMoves = -1
+	forgetEvals(state, playedMoves(state) + 1)
+	san := state.game.SAN(move)
+	moveText, _ := state.game.ExecuteValidMove(move)
+	state.logger.Info("move played", "color", move.piece.color.String(), "move", move.asMove().CoordNotation(), "san", san,
+		"fen", state.game.FEN())
+	_, err := state.history.Write([]byte(moveText + "\n"))
+	if err != nil {
+		state.logger.Error("writing history failed", "err", err)
+	}
+
+	UpdateBoardUi(state)
+	if _, _, over := state.game.Result(); over {
+		StartAnalysis(state)
+		return
+	}
+	showThinking(state)
+}
+
+// Plays the game on in the background with PlayGame, asking the controllers, indexed by Color, for the moves, so that
+// any pairing is played the same way, and keeps them as the controllers of the game. Each move is applied on the UI
+// goroutine with ApplyMove before the next is asked for. The loop plays a copy of the game, since the UI goroutine
+// changes it outside the loop on an undo or new game, or when who plays which side changes; these cancel the loop and
+// start it again.
+func StartGameLoop(state *State, controllers [2]Controller) {
+	CancelGameLoop(state)
+	state.controllers = controllers
+	if _, _, over := state.game.Result(); over {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	state.loopCancel = cancel
+	state.loopDone = done
+
+	for _, color := range []Color{White, Black} {
+		engine, ok := engineOf(state, color)
+		if !ok {
+			continue
+		}
+		// The previous loop is done, so the report of its searches can be replaced.
+		engine.report = func(g *Game, result SearchResult) {
+			state.logger.Info("engine search done", "engine", engine.searcher.Name(), "fen", g.FEN(),
+				"move", result.move.asMove().CoordNotation(), "depth", result.depth, "score", result.score,
+				"nodes", result.nodes, "elapsed", result.elapsed)
+			if result.depth == 0 {
+				// moves from the book or tablebases come without a searched evaluation
+				return
+			}
+			ply, toMove := len(g.moves) - g.setupMoves, g.currentPlayer
+			state.queueUpdate(func() {
+				if ctx.Err() == nil {
+					recordEval(state, ply, result.score, toMove)
+				}
+			})
+		}
+	}
+	game := state.game.Clone()
+	showThinking(state)
+	state.logger.Info("game loop started", "fen", game.FEN(), "white", playerName(state, White),
+		"black", playerName(state, Black))
+	go func() {
+		defer close(done)
+		err := PlayGame(ctx, game, controllers, func(m ValidMove, text string) {
+			applied := make(chan struct{})
+			state.queueUpdate(func() {
+				if ctx.Err() != nil {
+					state.logger.Debug("discarding move of cancelled game loop", "move", text)
+					return
+				}
+				ApplyMove(m, state)
+				close(applied)
+			})
+			select {
+			case <-applied:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			state.queueUpdate(func() {
+				state.logger.Error("game loop failed", "err", err)
+				UpdateBoardUi(state)
+			})
 		}
+	}()
+}
 
-		state.logger.Printf("Found matching move, executing state change %+v", chosenMove)
-		moveText, _ := state.game.ExecuteValidMove(chosenMove)
+// Stops the game loop, if it is running, and waits for it to return so that the game can be changed and the engine
+// used again.
+func CancelGameLoop(state *State) {
+	if state.loopCancel == nil {
+		return
+	}
+	state.loopCancel()
+	<-state.loopDone
+	state.loopCancel = nil
+	state.loopDone = nil
+}
+
+// Shows that the side to move is thinking, unless the human at the keyboard plays it.
+func showThinking(state *State) {
+	if !humanPlays(state, state.game.currentPlayer) && state.shownMoves < 0 {
+		state.currentPlayerStatus.SetText("thinking...")
+	}
+}
+
+// Toggles whether the human at the keyboard or the engine plays the current player, and if the engine now does, starts
+// it thinking. A player that is neither, such as one on the network, is replaced by the human.
+func ToggleEngine(state *State) {
+	CancelGameLoop(state)
+	CancelHint(state)
+	color := state.game.currentPlayer
+	if humanPlays(state, color) {
+		state.controllers[color] = NewEngineController(state.engine, SearchLimits{moveTime: state.engineMoveTime})
+		state.input.SetText("")
+	} else {
+		state.controllers[color] = state.human
+	}
+	state.logger.Info("engine toggled", "color", color.String(), "player", playerName(state, color))
+	orientForHuman(state)
+	UpdateBoardUi(state)
+	StartGameLoop(state, state.controllers)
+}
+
+// Takes back moves until it is the turn of the human at the keyboard, or only one move if the human plays neither
+// side.
+func UndoMove(state *State) {
+	CancelGameLoop(state)
+	CancelHint(state)
+	CancelAnalysis(state)
+	state.shownMoves = -1
+	var moveTexts []string
+	undone := false
+	for {
+		texts, ok := state.game.Undo()
+		if !ok {
+			break
+		}
+		moveTexts = texts
+		undone = true
+		color := state.game.currentPlayer
+		if humanPlays(state, color) || !humanPlays(state, color.Opponent()) {
+			break
+		}
+	}
+	if !undone {
+		StartGameLoop(state, state.controllers)
+		return
+	}
+	forgetEvals(state, playedMoves(state) + 1)
+	state.logger.Info("moves undone", "moves", len(moveTexts), "fen", state.game.FEN())
+	state.history.Clear()
+	for _, moveText := range moveTexts {
 		_, err := state.history.Write([]byte(moveText + "\n"))
 		if err != nil {
-			state.logger.Printf("Failed to write text to history %v", err)
+			state.logger.Error("writing history failed", "err", err)
 		}
+	}
+	state.input.SetText("")
+	UpdateBoardUi(state)
+	StartGameLoop(state, state.controllers)
+}
 
-		inputField.SetText("")
-		UpdateBoardUi(state)
+// Abandons the current game and starts a new one, keeping who plays which side.
+func NewGameUi(state *State) {
+	CancelGameLoop(state)
+	CancelHint(state)
+	CancelAnalysis(state)
+	state.shownMoves = -1
+	forgetEvals(state, 0)
+	state.logger.Info("new game")
+	*state.game = *NewGame()
+	for _, opponent := range state.opponents {
+		opponent.Clear()
 	}
+	for _, color := range []Color{White, Black} {
+		if engine, ok := engineOf(state, color); ok {
+			engine.searcher.Clear()
+		}
+	}
+	state.history.Clear()
+	state.input.SetText("")
+	UpdateBoardUi(state)
+	StartGameLoop(state, state.controllers)
+}
 
+// Asks the built-in engine for the best move of the current player and highlights it once found, without playing it.
+// The hint search runs in the background like an engine move, but with its own engine so that it does not disturb the
+// opponent.
+func ShowHint(state *State) {
+	if !humanPlays(state, state.game.currentPlayer) || state.shownMoves >= 0 || len(state.game.LegalMoves()) == 0 {
+		return
+	}
+	CancelHint(state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	state.hintCancel = cancel
+	state.hintDone = done
+
+	game := state.game.Clone()
+	state.currentPlayerStatus.SetText("finding hint...")
+	state.logger.Debug("hint search started", "fen", game.FEN())
+	go func() {
+		result, err := state.hintEngine.Search(ctx, game, state.hintLimits)
+		close(done)
+		state.queueUpdate(func() {
+			if ctx.Err() != nil {
+				state.logger.Debug("discarding cancelled hint search")
+				return
+			}
+			cancel()
+			state.hintCancel = nil
+			state.hintDone = nil
+			if err != nil {
+				state.logger.Error("hint search failed", "err", err)
+				return
+			}
+			state.logger.Info("hint found", "fen", game.FEN(), "move", result.move.asMove().CoordNotation(),
+				"depth", result.depth, "score", result.score, "elapsed", result.elapsed)
+			state.hint = result.move
+			state.hasHint = true
+			GridStateUpdater(state.input.GetText(), state)
+		})
+	}()
+}
+
+// Stops the running hint search, if any, and forgets the last hint. Called whenever the position changes.
+func CancelHint(state *State) {
+	state.hasHint = false
+	if state.hintCancel == nil {
+		return
+	}
+	state.hintCancel()
+	<-state.hintDone
+	state.hintCancel = nil
+	state.hintDone = nil
 }
 
-func Start(game *Game) {
-	f, err := os.OpenFile("./log.txt", os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+// Runs the engine over every position of the game in the background, and once done, shows the annotated moves and
+// the engine's preferred moves in the history.
+func StartAnalysis(state *State) {
+	CancelAnalysis(state)
+	if len(state.game.moves) == state.game.setupMoves {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	state.analysisCancel = cancel
+	state.analysisDone = done
+
+	game := state.game.Clone()
+	// A separate engine without the book, since book moves have no evaluation.
+	engine := NewEngine()
+	engine.SetTablebases(state.tablebases)
+	state.logger.Info("analysis started", "moves", len(game.moves) - game.setupMoves)
+	start := time.Now()
+	go func() {
+		analysis, err := AnalyzeGame(ctx, engine, game, SearchLimits{moveTime: analysisMoveTime}, func(done int, total int) {
+			state.queueUpdate(func() {
+				if ctx.Err() == nil {
+					state.currentPlayerStatus.SetText(fmt.Sprintf("analysing %v/%v", done, total))
+				}
+			})
+		})
+		close(done)
+		state.queueUpdate(func() {
+			if ctx.Err() != nil {
+				state.logger.Debug("discarding cancelled analysis")
+				return
+			}
+			cancel()
+			state.analysisCancel = nil
+			state.analysisDone = nil
+			if err != nil {
+				state.logger.Error("analysis failed", "err", err)
+				return
+			}
+			state.logger.Info("analysis done", "elapsed", time.Since(start))
+			state.analysis = analysis
+			for i, m := range analysis.moves {
+				recordEval(state, i, m.before, m.color)
+			}
+			if n := len(analysis.moves); n > 0 {
+				last := analysis.moves[n-1]
+				recordEval(state, n, -last.after, last.color.Opponent())
+			}
+			state.history.Clear()
+			for _, m := range analysis.moves {
+				_, err := state.history.Write([]byte(m.HistoryText() + "\n"))
+				if err != nil {
+					state.logger.Error("writing history failed", "err", err)
+				}
+			}
+			state.currentPlayerStatus.SetText("analysis done, ^W saves it")
+		})
+	}()
+}
+
+// Stops the running analysis, if any, and forgets the last one. Called whenever the game changes.
+func CancelAnalysis(state *State) {
+	state.analysis = nil
+	if state.analysisCancel == nil {
+		return
+	}
+	state.analysisCancel()
+	<-state.analysisDone
+	state.analysisCancel = nil
+	state.analysisDone = nil
+}
+
+// Writes the game to a new PGN file in the working directory, with the annotations if it has been analysed.
+func SavePGN(state *State) {
+	record := state.analysis
+	if record == nil {
+		record = NewAnnotatedGame(state.game)
+	}
+	record.white, record.black = playerName(state, White), playerName(state, Black)
+
+	path := fmt.Sprintf("game-%v.pgn", time.Now().Format("20060102-150405"))
+	f, err := os.Create(path)
+	if err == nil {
+		err = record.WritePGN(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	if err != nil {
-		panic(err)
+		state.logger.Error("saving PGN failed", "path", path, "err", err)
+		state.currentPlayerStatus.SetText("saving failed")
+		return
+	}
+	state.logger.Info("game saved", "path", path)
+	state.currentPlayerStatus.SetText("saved " + path)
+}
+
+// Steps the board back or forward through the moves of the game by delta. See BrowseTo.
+func BrowseHistory(state *State, delta int) {
+	BrowseTo(state, shownPly(state) + delta)
+}
+
+// Shows the position after the first n moves of the game on the board, without changing the game. Showing the last
+// move returns to the current position, the only one in which moves can be entered.
+func BrowseTo(state *State, n int) {
+	played := playedMoves(state)
+	shown := max(0, min(n, played))
+	if shown == played {
+		shown = -1
+	}
+	if shown == state.shownMoves {
+		return
 	}
-	defer f.Close()
-	logger := log.New(f, "tviewui", log.LstdFlags)
+	state.shownMoves = shown
+	state.logger.Debug("browsing history", "shown", shown, "played", played)
+	state.input.SetText("")
+	UpdateBoardUi(state)
+}
+
+// Turns the analysis panel on or off.
+func ToggleLines(state *State) {
+	state.showLines = !state.showLines
+	UpdateLines(state, shownGame(state))
+}
+
+// Keeps the analysis panel searching the position shown, restarting the search whenever that position changes. The
+// search is infinite, so the lines deepen until the position changes or the panel is turned off.
+func UpdateLines(state *State, game *Game) {
+	if !state.showLines {
+		CancelLines(state)
+		state.lines.SetText("^L to analyse")
+		return
+	}
+	fen := game.FEN()
+	if fen == state.linesFEN {
+		return
+	}
+	CancelLines(state)
+	state.linesFEN = fen
+	if len(game.LegalMoves()) == 0 {
+		state.lines.SetText("no legal moves")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	state.linesCancel = cancel
+	state.linesDone = done
+
+	game = game.Clone()
+	ply := len(game.moves) - game.setupMoves
+	state.lines.SetText("searching...")
+	state.logger.Debug("lines search started", "fen", fen)
+	// Iterations can complete faster than the UI redraws, so only the latest result is kept, and only one update is
+	// queued at a time.
+	var latest atomic.Pointer[SearchResult]
+	var queued atomic.Bool
+	state.linesEngine.onIteration = func(result SearchResult) {
+		latest.Store(&result)
+		if queued.Swap(true) {
+			return
+		}
+		state.queueUpdate(func() {
+			queued.Store(false)
+			if ctx.Err() != nil {
+				return
+			}
+			result := latest.Load()
+			state.lines.SetText(linesText(game, *result))
+			recordEval(state, ply, result.score, game.currentPlayer)
+		})
+	}
+	go func() {
+		defer close(done)
+		_, err := state.linesEngine.Search(ctx, game, SearchLimits{infinite: true, multiPV: analysisPanelLines})
+		if err != nil {
+			state.logger.Error("lines search failed", "err", err)
+		}
+	}()
+}
+
+// Formats the lines of a search of the game's current position for the analysis panel: the depth, then each line's
+// number, evaluation from White's point of view and moves in SAN. The number also keeps the text view from joining a
+// line that starts with a negative evaluation onto the line before.
+func linesText(g *Game, result SearchResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "depth %v, %v nodes", result.depth, result.nodes)
+	for i, line := range result.lines {
+		fmt.Fprintf(&sb, "\n%v) %v %v", i + 1, whiteEval(line.score, g.currentPlayer), g.SANLine(line.pv))
+	}
+	return sb.String()
+}
+
+// Stops the running lines search, if any, and waits for it to return so a new one can use the engine.
+func CancelLines(state *State) {
+	state.linesFEN = ""
+	if state.linesCancel == nil {
+		return
+	}
+	state.linesCancel()
+	<-state.linesDone
+	state.linesCancel = nil
+	state.linesDone = nil
+}
+
+// Records the evaluation of the position reached after ply moves of the game, given as a score of the player to move.
+func recordEval(state *State, ply int, score int, toMove Color) {
+	if toMove == Black {
+		score = -score
+	}
+	state.evals[ply] = score
+}
+
+// Forgets the evaluations of the positions from ply moves on, which are no longer part of the game.
+func forgetEvals(state *State, ply int) {
+	for p := range state.evals {
+		if p >= ply {
+			delete(state.evals, p)
+		}
+	}
+}
+
+// Maps an evaluation from White's point of view onto the range -1 to 1, from Black winning to White winning.
+func evalLevel(score int) float64 {
+	return 2 / (1 + math.Exp(-evalCurve * float64(score))) - 1
+}
+
+// Draws the evaluation of the position shown as a bar filled from the bottom with White's share, alongside the ranks
+// of the board, with the evaluation written at the end of the side that is better. The bar is blank until the position
+// has been evaluated.
+func drawEvalBar(state *State, screen tcell.Screen, x int, y int, width int, height int) {
+	// Leave out the rows of file labels above and below the board.
+	y, height = y + 1, height - 2
+	score, known := state.evals[shownPly(state)]
+	whiteRows := 0
+	if known {
+		whiteRows = int(math.Round((evalLevel(score) + 1) / 2 * float64(height)))
+	}
+	for row := range height {
+		style := tcell.StyleDefault.Background(state.evalBlackColor).Foreground(state.evalWhiteColor)
+		if row >= height - whiteRows {
+			style = tcell.StyleDefault.Background(state.evalWhiteColor).Foreground(state.evalBlackColor)
+		}
+		for col := range width {
+			screen.SetContent(x + col, y + row, ' ', nil, style)
+		}
+	}
+	if !known {
+		return
+	}
+	text := whiteEval(score, White)
+	if score >= 0 {
+		tview.Print(screen, text, x, y + height - 1, width, tview.AlignCenter, state.evalBlackColor)
+	} else {
+		tview.Print(screen, text, x, y, width, tview.AlignCenter, state.evalWhiteColor)
+	}
+}
+
+// Draws the evaluation of every position of the game as columns rising above the middle row while White is better and
+// falling below it while Black is, with the column of the position shown highlighted. Games longer than the graph is
+// wide are sampled.
+func drawEvalGraph(state *State, screen tcell.Screen, x int, y int, width int, height int) {
+	points := playedMoves(state) + 1
+	columns := min(points, width)
+	mid := y + height / 2
+	shown := shownPly(state)
+	for col := range columns {
+		ply := col * points / columns
+		background := tcell.ColorDefault
+		if ply == shown || (columns < points && ply < shown && shown < (col + 1) * points / columns) {
+			background = state.evalShownColor
+		}
+		for row := y; row < y + height; row++ {
+			screen.SetContent(x + col, row, ' ', nil, tcell.StyleDefault.Background(background))
+		}
+		score, known := state.evals[ply]
+		if !known {
+			screen.SetContent(x + col, mid, '·', nil, tcell.StyleDefault.Background(background).Foreground(state.evalBlackColor))
+			continue
+		}
+		level := int(math.Round(evalLevel(score) * float64(height / 2)))
+		switch {
+		case level > 0:
+			for row := mid - level; row < mid; row++ {
+				screen.SetContent(x + col, row, '█', nil, tcell.StyleDefault.Background(background).Foreground(state.evalWhiteColor))
+			}
+		case level < 0:
+			for row := mid + 1; row <= mid - level; row++ {
+				screen.SetContent(x + col, row, '█', nil, tcell.StyleDefault.Background(background).Foreground(state.evalBlackColor))
+			}
+		default:
+			screen.SetContent(x + col, mid, '─', nil, tcell.StyleDefault.Background(background).Foreground(state.evalWhiteColor))
+		}
+	}
+}
+
+// Returns the number of moves to the position whose column of the eval graph is at the screen position, if any.
+func graphPly(state *State, x int, y int) (int, bool) {
+	gx, gy, width, height := state.evalGraph.GetInnerRect()
+	points := playedMoves(state) + 1
+	columns := min(points, width)
+	if x < gx || x >= gx + columns || y < gy || y >= gy + height {
+		return 0, false
+	}
+	return (x - gx) * points / columns, true
+}
+
+// Switches the engine to the next opponent, e.g. from the built-in engine to an external UCI engine, for the sides it
+// plays. A search in progress is restarted with the new opponent.
+func CycleOpponent(state *State) {
+	CancelGameLoop(state)
+	previous := state.engine
+	i := slices.Index(state.opponents, previous)
+	state.engine = state.opponents[(i+1) % len(state.opponents)]
+	for _, color := range []Color{White, Black} {
+		if engine, ok := engineOf(state, color); ok && engine.searcher == previous {
+			engine.searcher = state.engine
+		}
+	}
+	state.logger.Info("opponent changed", "opponent", state.engine.Name())
+	UpdateBoardUi(state)
+	StartGameLoop(state, state.controllers)
+}
+
+// Settings of the built-in engine as an opponent.
+type EngineOptions struct {
+	skillLevel int
+	threads int
+}
+
+// Colors of the board and the pieces.
+type Theme struct {
+	darkSquare tcell.Color
+	lightSquare tcell.Color
+	whitePiece tcell.Color
+	blackPiece tcell.Color
+}
+
+// The themes to choose from by name. See ThemeNames.
+var themes = map[string]Theme{
+	"green": {tcell.NewHexColor(0x95B089), tcell.NewHexColor(0xB5A16E), tcell.NewHexColor(0xFFFFFF), tcell.NewHexColor(0x000000)},
+	"brown": {tcell.NewHexColor(0x8B5A2B), tcell.NewHexColor(0xC19A6B), tcell.NewHexColor(0xFFFFFF), tcell.NewHexColor(0x000000)},
+	"blue": {tcell.NewHexColor(0x4B7399), tcell.NewHexColor(0x8CA2AD), tcell.NewHexColor(0xFFFFFF), tcell.NewHexColor(0x000000)},
+	"gray": {tcell.NewHexColor(0x505050), tcell.NewHexColor(0x909090), tcell.NewHexColor(0xFFFFFF), tcell.NewHexColor(0x000000)},
+}
+
+// The theme used unless another is chosen.
+const defaultTheme = "green"
+
+// Returns the names of the themes in alphabetical order.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Settings of the TUI.
+type UIOptions struct {
+	engine EngineOptions
+	// Who plays each side from the start, indexed by Color: human for the human at the keyboard, nil for the built-in
+	// engine, or any other controller. moveTime is how long the engine thinks about each move.
+	controllers [2]Controller
+	human *HumanController
+	moveTime time.Duration
+	// Name of the theme, see themes.
+	theme string
+	logger *slog.Logger
+}
+
+// Runs the TUI for the game, which may already have moves played. The built-in engine is always an opponent, set up
+// with the options, and plays from the book and tablebases if there are any; any others, such as external UCI engines,
+// are offered after it. Returns once the user quits, or with an error if the UI cannot run, e.g. on a screen too small
+// for the board.
+func Start(game *Game, book *OpeningBook, tablebases *Tablebases, options UIOptions, opponents ...Searcher) error {
+	theme, found := themes[options.theme]
+	if !found {
+		theme = themes[defaultTheme]
+	}
+	logger := options.logger
+	// Set if the UI stopped itself because it cannot go on.
+	var fatalErr error
 	pieceSets := generatePieceSets()
 
 	app := tview.NewApplication()
-	app.EnableMouse(false)
+	// The mouse moves pieces on the board and selects positions on the eval graph. See SetMouseCapture below.
+	app.EnableMouse(true)
 	app.EnablePaste(false)
 
 	outer := tview.NewFlex()
@@ -282,31 +1334,87 @@ func Start(game *Game) {
 	currentPlayer := tview.NewTextView()
 	currentPlayerStatus := tview.NewTextView()
 	history := tview.NewTextView()
+	bookMoves := tview.NewTextView()
+	material := tview.NewTextView()
+	engine := NewEngine()
+	engine.SetBook(book)
+	engine.SetTablebases(tablebases)
+	engine.SetSkillLevel(options.engine.skillLevel)
+	engine.SetThreads(options.engine.threads)
+	hintEngine := NewEngine()
+	hintEngine.SetBook(book)
+	hintEngine.SetTablebases(tablebases)
+	lines := tview.NewTextView()
+	evalBar := tview.NewBox()
+	evalGraph := tview.NewBox()
+	// Without the book, since book moves have no evaluation.
+	linesEngine := NewEngine()
+	linesEngine.SetTablebases(tablebases)
+	linesEngine.SetThreads(options.engine.threads)
+	input := tview.NewInputField()
 
 	state := State{
 		app: app,
+		queueUpdate: func(f func()) { go app.QueueUpdateDraw(f) },
 		pieceSet: nil,
 		game: game,
 		squares: squares,
 		currentPlayer: currentPlayer,
 		currentPlayerStatus: currentPlayerStatus,
 		history: history,
+		bookMoves: bookMoves,
+		material: material,
+		book: book,
+		tablebases: tablebases,
+		input: input,
+		opponents: append([]Searcher{engine}, opponents...),
+		engineMoveTime: options.moveTime,
+		human: options.human,
+		hintEngine: hintEngine,
+		hintLimits: SearchLimits{moveTime: hintMoveTime},
+		lines: lines,
+		linesEngine: linesEngine,
+		shownMoves: -1,
+		evals: map[int]int{},
+		evalBar: evalBar,
+		evalGraph: evalGraph,
+		evalWhiteColor: tcell.NewHexColor(0xFFFFFF),
+		evalBlackColor: tcell.NewHexColor(0x606060),
+		evalShownColor: tcell.NewHexColor(0x806000),
 		redStatusColor: tcell.NewHexColor(0xFF0000),
-		greenBbgColor: tcell.NewHexColor(0x95B089),
-		beigeBgColor: tcell.NewHexColor(0xB5A16E),
-		whitePieceColor: tcell.NewHexColor(0xFFFFFF),
-		blackPieceColor: tcell.NewHexColor(0x000000),
-		squareDefaultGreenStyle: tcell.Style{}.Foreground(tview.Styles.PrimaryTextColor).Background(tcell.NewHexColor(0x95B089)),
-		squareDefaultBeigeStyle: tcell.Style{}.Foreground(tview.Styles.PrimaryTextColor).Background(tcell.NewHexColor(0xB5A16E)),
+		darkSquareColor: theme.darkSquare,
+		lightSquareColor: theme.lightSquare,
+		whitePieceColor: theme.whitePiece,
+		blackPieceColor: theme.blackPiece,
+		squareDefaultDarkStyle: tcell.Style{}.Foreground(tview.Styles.PrimaryTextColor).Background(theme.darkSquare),
+		squareDefaultLightStyle: tcell.Style{}.Foreground(tview.Styles.PrimaryTextColor).Background(theme.lightSquare),
 		squareHighlightStyle: tcell.Style{}.Background(tcell.NewHexColor(0xFFFF00)).Foreground(tcell.NewHexColor(0xFFFF00)),
 		squareValidMoveStyle: tcell.Style{}.Background(tcell.NewHexColor(0x008000)).Foreground(tcell.NewHexColor(0x008000)),
+		squareWarningStyle: tcell.Style{}.Background(tcell.NewHexColor(0xFF0000)).Foreground(tcell.NewHexColor(0xFF0000)),
+		squareLastMoveStyle: tcell.Style{}.Background(tcell.NewHexColor(0x6495ED)).Foreground(tcell.NewHexColor(0x6495ED)),
+		cursor: CartesianCoord{4, 1},
+		cursorColor: tcell.NewHexColor(0xFF00FF),
 		logger: logger,
 	}
+	state.engine = engine
+	if state.human == nil {
+		state.human = NewHumanController()
+	}
+	for color, controller := range options.controllers {
+		if controller == nil {
+			controller = NewEngineController(state.engine, SearchLimits{moveTime: state.engineMoveTime})
+		}
+		state.controllers[color] = controller
+	}
+	orientForHuman(&state)
+	for _, m := range NewAnnotatedGame(game).moves {
+		history.Write([]byte(m.text + "\n"))
+	}
 	for y := range 8 {
 
 		rowLabel := tview.NewTextView()
 		rowLabel.SetTextAlign(tview.AlignCenter)
-		rowLabel.SetText(string(byte('8'-y)))
+		state.rankLabels[y][0] = rowLabel
 		flx := tview.NewFlex()
 		flx.SetDirection(tview.FlexRow)
 		flx.SetBorder(false)
@@ -317,7 +1425,7 @@ func Start(game *Game) {
 
 		rowLabel = tview.NewTextView()
 		rowLabel.SetTextAlign(tview.AlignCenter)
-		rowLabel.SetText(string(byte('8'-y)))
+		state.rankLabels[y][1] = rowLabel
 		flx = tview.NewFlex()
 		flx.SetDirection(tview.FlexRow)
 		flx.SetBorder(false)
@@ -331,12 +1439,12 @@ func Start(game *Game) {
 			if y == 0 {
 				colLabel := tview.NewTextView()
 				colLabel.SetTextAlign(tview.AlignCenter)
-				colLabel.SetText(string(byte('a'+x)))
+				state.fileLabels[x][0] = colLabel
 				board.AddItem(colLabel, 0, x+1, 1, 1, 0, 0, false)
 
 				colLabel = tview.NewTextView()
 				colLabel.SetTextAlign(tview.AlignCenter)
-				colLabel.SetText(string(byte('a'+x)))
+				state.fileLabels[x][1] = colLabel
 				board.AddItem(colLabel, 9, x+1, 1, 1, 0, 0, false)
 			}
 
@@ -345,16 +1453,15 @@ func Start(game *Game) {
 			squares[y][x] = square
 
 			square.SetBorder(true)
-			bgColor := state.greenBbgColor
+			bgColor := state.darkSquareColor
 			if y % 2 == x % 2 {
-				bgColor = state.beigeBgColor
+				bgColor = state.lightSquareColor
 			}
 			square.SetBackgroundColor(bgColor)
 		}
 	}
 
 
-	input := tview.NewInputField()
 	input.SetBorder(true)
 	input.SetFieldBackgroundColor(tcell.NewHexColor(0x000000))
 	input.SetTitle("Move:")
@@ -385,14 +1492,54 @@ func Start(game *Game) {
 		app.Draw()
 	})
 
+	bookMoves.SetBorder(true)
+	bookMoves.SetTitle("Book:")
+	bookMoves.SetTitleAlign(tview.AlignLeft)
+
+	material.SetBorder(true)
+	material.SetTitle("Captured:")
+	material.SetTitleAlign(tview.AlignLeft)
+
+	lines.SetBorder(true)
+	lines.SetTitle("Lines:")
+	lines.SetTitleAlign(tview.AlignLeft)
+	lines.SetWrap(false)
+
+	evalBar.SetDrawFunc(func(screen tcell.Screen, x int, y int, width int, height int) (int, int, int, int) {
+		drawEvalBar(&state, screen, x, y, width, height)
+		return x, y, width, height
+	})
+
+	evalGraph.SetBorder(true)
+	evalGraph.SetTitle("Eval:")
+	evalGraph.SetTitleAlign(tview.AlignLeft)
+	evalGraph.SetDrawFunc(func(screen tcell.Screen, x int, y int, width int, height int) (int, int, int, int) {
+		// the draw func gets the whole box, border included
+		drawEvalGraph(&state, screen, x + 1, y + 1, width - 2, height - 2)
+		return x + 1, y + 1, width - 2, height - 2
+	})
+
+	keys := tview.NewTextView()
+	keys.SetBorder(true)
+	keys.SetTitle("Keys:")
+	keys.SetTitleAlign(tview.AlignLeft)
+	keys.SetText("^E engine ^O opponent ^T hint ^L lines ^A analyse ^W save PGN ^Z undo ^N new ^F flip PgUp/PgDn browse ^C quit " +
+		"arrows/hjkl cursor Enter/Space select")
+
 	status := tview.NewFlex()
 	status.SetDirection(tview.FlexRow)
 	status.AddItem(history, 0, 1, false)
+	status.AddItem(bookMoves, 7, 0, false)
+	status.AddItem(material, 4, 0, false)
+	status.AddItem(lines, analysisPanelLines + 3, 0, false)
+	status.AddItem(evalGraph, 7, 0, false)
 	status.AddItem(currentPlayer, 3, 0, false)
 	status.AddItem(currentPlayerStatus, 3, 0, false)
 	status.AddItem(input, 3, 0, false)
+	status.AddItem(keys, 6, 0, false)
 
 	outer.AddItem(board, 0, 1, false)
+	outer.AddItem(evalBar, 6, 0, false)
 	outer.AddItem(status, 40, 0, false)
 
 	var width, height int
@@ -405,12 +1552,12 @@ func Start(game *Game) {
 		if nWidth == width && nHeight == height {
 			return
 		}
-		logger.Printf("detected screen size change from %vx%v to %vx%v", width, height, nWidth, nHeight)
+		logger.Debug("screen size changed", "width", nWidth, "height", nHeight, "old_width", width, "old_height", height)
 		width, height = nWidth, nHeight
 
 		go func() {
 			app.QueueUpdateDraw(func() {
-				logger.Printf("updating layout and pieceSet based on new size %vx%v", width, height)
+				logger.Debug("updating layout", "width", width, "height", height)
 
 				// Assume that if the grid has cells of inconsistent sizes due to the screen size not dividing evenly, the first
 				// cell of the grid will be the smallest of the entire grid.
@@ -430,7 +1577,10 @@ func Start(game *Game) {
 				}
 
 				if (state.pieceSet == nil) {
-					logger.Panicf("No piece set fit your screen size (%vx%v)", width, height)
+					fatalErr = fmt.Errorf("no piece set fits a screen of %vx%v", width, height)
+					logger.Error("layout failed", "width", width, "height", height, "err", fatalErr)
+					app.Stop()
+					return
 				}
 
 				outer.SetTitle(fmt.Sprintf(
@@ -439,14 +1589,83 @@ func Start(game *Game) {
 				))
 
 				UpdateBoardUi(&state)
-				logger.Printf("completed layout update using square size %v,%v and piece size %v,%v", sw, sh, state.pieceSet.minX, state.pieceSet.minY)
+				logger.Info("layout updated", "width", width, "height", height, "square_width", sw, "square_height", sh,
+					"piece_width", state.pieceSet.minX, "piece_height", state.pieceSet.minY)
 			})
 		}()
 	})
 
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyCtrlE:
+			ToggleEngine(&state)
+		case tcell.KeyCtrlO:
+			CycleOpponent(&state)
+		case tcell.KeyCtrlT:
+			ShowHint(&state)
+		case tcell.KeyCtrlA:
+			StartAnalysis(&state)
+		case tcell.KeyCtrlW:
+			SavePGN(&state)
+		case tcell.KeyCtrlZ:
+			UndoMove(&state)
+		case tcell.KeyCtrlN:
+			NewGameUi(&state)
+		case tcell.KeyCtrlL:
+			ToggleLines(&state)
+		case tcell.KeyCtrlF:
+			FlipBoard(&state)
+		case tcell.KeyPgUp:
+			BrowseHistory(&state, -1)
+		case tcell.KeyPgDn:
+			BrowseHistory(&state, 1)
+		case tcell.KeyLeft, tcell.KeyRight, tcell.KeyUp, tcell.KeyDown, tcell.KeyEnter, tcell.KeyRune:
+			return cursorKey(&state, event)
+		default:
+			return event
+		}
+		return nil
+	})
+
+	app.SetMouseCapture(func(event *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
+		// tview fires the move, the button and the click actions of one event in turn, and a nil event returned for one
+		// stops the rest, so moves are passed on and clicks are handled when the button is released.
+		if action == tview.MouseMove {
+			return event, action
+		}
+		x, y := event.Position()
+		switch action {
+		case tview.MouseLeftDown:
+			if cc, found := squareAt(&state, x, y); found {
+				PressSquare(&state, cc)
+			}
+		case tview.MouseLeftUp:
+			cc, found := squareAt(&state, x, y)
+			ReleaseSquare(&state, cc, found)
+			if ply, found := graphPly(&state, x, y); found {
+				BrowseTo(&state, ply)
+			}
+		}
+		// Other mouse events are not passed on, so that clicks do not take the focus from the move input.
+		return nil, action
+	})
+
 	app.SetRoot(outer, true)
 	app.SetFocus(input)
-	if err := app.Run(); err != nil {
-		logger.Panic(err)
+	StartGameLoop(&state, state.controllers)
+	err := app.Run()
+	CancelGameLoop(&state)
+	CancelHint(&state)
+	CancelAnalysis(&state)
+	CancelLines(&state)
+	if err != nil {
+		return err
 	}
+	return fatalErr
+}
+
+// Logs a bug that leaves the UI in a state it cannot go on from, and panics with it.
+func logBug(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	panic(fmt.Sprintf("%v: %v", msg, args))
 }
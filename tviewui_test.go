@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Returns the state of a UI for a new game that is never drawn, with just enough set up to update the board. The
+// human plays both sides, and a move entered waits in its channel, as if the game loop were waiting for one.
+func newTestState() *State {
+	human := &HumanController{moves: make(chan ValidMove, 1)}
+	state := &State{
+		game: NewGame(),
+		controllers: [2]Controller{human, human},
+		human: human,
+		squares: make([][]*tview.TextView, 8),
+		currentPlayer: tview.NewTextView(),
+		currentPlayerStatus: tview.NewTextView(),
+		input: tview.NewInputField(),
+		shownMoves: -1,
+		squareHighlightStyle: tcell.Style{}.Background(tcell.NewHexColor(0xFFFF00)).Foreground(tcell.NewHexColor(0xFFFF00)),
+		squareValidMoveStyle: tcell.Style{}.Background(tcell.NewHexColor(0x008000)).Foreground(tcell.NewHexColor(0x008000)),
+		squareWarningStyle: tcell.Style{}.Background(tcell.NewHexColor(0xFF0000)).Foreground(tcell.NewHexColor(0xFF0000)),
+		squareLastMoveStyle: tcell.Style{}.Background(tcell.NewHexColor(0x6495ED)).Foreground(tcell.NewHexColor(0x6495ED)),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for row := range state.squares {
+		state.squares[row] = make([]*tview.TextView, 8)
+		for col := range state.squares[row] {
+			state.squares[row][col] = tview.NewTextView()
+		}
+	}
+	return state
+}
+
+// Returns whether the square is highlighted in the style, which GridStateUpdater draws as the square's border.
+func highlightedIn(state *State, cc CartesianCoord, style tcell.Style) bool {
+	color, _, _ := style.Decompose()
+	return squareView(state, cc).GetBorderColor() == color
+}
+
+func TestEvalLevel(t *testing.T) {
+	var tests = []struct{
+		score int
+		want float64
+	}{
+		{0, 0},
+		{100, 0.1821},
+		{-100, -0.1821},
+		{400, 0.6270},
+		{mateScore, 1},
+		{-mateScore, -1},
+	}
+
+	for _, tt := range tests {
+		if got := evalLevel(tt.score); math.Abs(got - tt.want) > 0.0001 {
+			t.Errorf("evalLevel(%v) got %v, want %v", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestGraphPly(t *testing.T) {
+	var tests = []struct{
+		name string
+		moves []string
+		// The graph's inner rectangle is 5 columns wide from x=10 and 3 rows high from y=20.
+		x int
+		y int
+		want int
+		wantFound bool
+	}{
+		{"start", nil, 10, 20, 0, true},
+		{"right of the only position", nil, 11, 20, 0, false},
+		{"a column per position", []string{"e2e4", "e7e5"}, 12, 22, 2, true},
+		{"right of the last position", []string{"e2e4", "e7e5"}, 13, 20, 0, false},
+		{"positions share columns", []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "d2d3", "f8c5"}, 14, 21, 7, true},
+		{"first of shared columns", []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "d2d3", "f8c5"}, 11, 21, 1, true},
+		{"left of the graph", []string{"e2e4"}, 9, 20, 0, false},
+		{"above the graph", []string{"e2e4"}, 10, 19, 0, false},
+		{"below the graph", []string{"e2e4"}, 10, 23, 0, false},
+	}
+
+	for _, tt := range tests {
+		state := &State{game: NewGame(), evalGraph: tview.NewBox()}
+		state.evalGraph.SetRect(10, 20, 5, 3)
+		playMoves(t, state.game, tt.moves...)
+		got, found := graphPly(state, tt.x, tt.y)
+		if got != tt.want || found != tt.wantFound {
+			t.Errorf("%v: graphPly(%v, %v) got %v, %v, want %v, %v", tt.name, tt.x, tt.y, got, found, tt.want, tt.wantFound)
+		}
+	}
+}
+
+func TestMoveCursor(t *testing.T) {
+	var tests = []struct{
+		name string
+		flipped bool
+		showCursor bool
+		cursor Coord
+		dx int
+		dy int
+		want Coord
+	}{
+		{"hidden cursor only shown", false, false, "e2", 1, 0, "e2"},
+		{"right", false, true, "e2", 1, 0, "f2"},
+		{"up", false, true, "e2", 0, 1, "e3"},
+		{"down and left", false, true, "e2", -1, -1, "d1"},
+		{"clamped at the top right", false, true, "h8", 1, 1, "h8"},
+		{"clamped at the bottom left", false, true, "a1", -1, -1, "a1"},
+		{"clamped along an edge", false, true, "h4", 1, 1, "h5"},
+		{"right when flipped", true, true, "e2", 1, 0, "d2"},
+		{"up when flipped", true, true, "e2", 0, 1, "e1"},
+		{"clamped at a1 when flipped", true, true, "a1", 1, 1, "a1"},
+		{"clamped at h8 when flipped", true, true, "h8", -1, -1, "h8"},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		state.flipped = tt.flipped
+		state.showCursor = tt.showCursor
+		state.cursor = tt.cursor.AsCartesianCoord()
+		MoveCursor(state, tt.dx, tt.dy)
+		if got := state.cursor.AsCoord(); got != tt.want {
+			t.Errorf("%v: cursor got %v, want %v", tt.name, got, tt.want)
+		}
+		if !state.showCursor {
+			t.Errorf("%v: cursor not shown after moving it", tt.name)
+		}
+	}
+}
+
+func TestCursorKey(t *testing.T) {
+	var tests = []struct{
+		name string
+		showCursor bool
+		input string
+		key tcell.Key
+		r rune
+		// Whether the key is handled rather than passed on, and the cursor and the input afterwards. The cursor starts
+		// on e2.
+		wantHandled bool
+		wantCursor Coord
+		wantShown bool
+		wantInput string
+	}{
+		{"j down", true, "", tcell.KeyRune, 'j', true, "e1", true, ""},
+		{"k up", true, "", tcell.KeyRune, 'k', true, "e3", true, ""},
+		{"l right", true, "", tcell.KeyRune, 'l', true, "f2", true, ""},
+		{"h left", true, "", tcell.KeyRune, 'h', true, "d2", true, ""},
+		{"l shows a hidden cursor", false, "", tcell.KeyRune, 'l', true, "e2", true, ""},
+		{"h typed as a file while the cursor is hidden", false, "", tcell.KeyRune, 'h', false, "e2", false, ""},
+		{"left arrow", true, "", tcell.KeyLeft, 0, true, "d2", true, ""},
+		{"right arrow", true, "", tcell.KeyRight, 0, true, "f2", true, ""},
+		{"up arrow", true, "", tcell.KeyUp, 0, true, "e3", true, ""},
+		{"down arrow", true, "", tcell.KeyDown, 0, true, "e1", true, ""},
+		{"arrow shows a hidden cursor", false, "", tcell.KeyUp, 0, true, "e2", true, ""},
+		{"Space selects", true, "", tcell.KeyRune, ' ', true, "e2", true, "e2"},
+		{"Space typed while the cursor is hidden", false, "", tcell.KeyRune, ' ', false, "e2", false, ""},
+		{"Enter selects", true, "", tcell.KeyEnter, 0, true, "e2", true, "e2"},
+		{"Enter enters a typed move", true, "e2e4", tcell.KeyEnter, 0, false, "e2", true, "e2e4"},
+		{"Enter while the cursor is hidden", false, "", tcell.KeyEnter, 0, false, "e2", false, ""},
+		{"other rune", true, "", tcell.KeyRune, 'x', false, "e2", true, ""},
+		{"other key", true, "", tcell.KeyTab, 0, false, "e2", true, ""},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		state.showCursor = tt.showCursor
+		state.cursor = Coord("e2").AsCartesianCoord()
+		state.input.SetText(tt.input)
+		handled := cursorKey(state, tcell.NewEventKey(tt.key, tt.r, tcell.ModNone)) == nil
+		if handled != tt.wantHandled {
+			t.Errorf("%v: handled got %v, want %v", tt.name, handled, tt.wantHandled)
+		}
+		if got := state.cursor.AsCoord(); got != tt.wantCursor || state.showCursor != tt.wantShown {
+			t.Errorf("%v: cursor got %v shown %v, want %v shown %v", tt.name, got, state.showCursor, tt.wantCursor,
+				tt.wantShown)
+		}
+		if got := state.input.GetText(); got != tt.wantInput {
+			t.Errorf("%v: input got %q, want %q", tt.name, got, tt.wantInput)
+		}
+	}
+}
+
+func TestBoardSquare(t *testing.T) {
+	var tests = []struct{
+		name string
+		flipped bool
+		// Where a1 is shown, and which square is shown at the top left.
+		wantA1Row int
+		wantA1Col int
+		wantTopLeft Coord
+	}{
+		{"White at the bottom", false, 7, 0, "a8"},
+		{"Black at the bottom", true, 0, 7, "h1"},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		state.flipped = tt.flipped
+		for row := range 8 {
+			for col := range 8 {
+				cc := boardSquare(state, row, col)
+				if gotRow, gotCol := screenSquare(state, cc); gotRow != row || gotCol != col {
+					t.Errorf("%v: screenSquare of boardSquare %v,%v got %v,%v", tt.name, row, col, gotRow, gotCol)
+				}
+			}
+		}
+		for y := range 8 {
+			for x := range 8 {
+				cc := CartesianCoord{x, y}
+				row, col := screenSquare(state, cc)
+				if got := boardSquare(state, row, col); got != cc {
+					t.Errorf("%v: boardSquare of screenSquare %v got %v", tt.name, cc.AsCoord(), got.AsCoord())
+				}
+			}
+		}
+		if row, col := screenSquare(state, Coord("a1").AsCartesianCoord()); row != tt.wantA1Row || col != tt.wantA1Col {
+			t.Errorf("%v: a1 shown at %v,%v, want %v,%v", tt.name, row, col, tt.wantA1Row, tt.wantA1Col)
+		}
+		if got := boardSquare(state, 0, 0).AsCoord(); got != tt.wantTopLeft {
+			t.Errorf("%v: top left square got %v, want %v", tt.name, got, tt.wantTopLeft)
+		}
+	}
+}
+
+func TestShowHint(t *testing.T) {
+	// The hint engine searches to a fixed depth, so the hint must be the move another engine finds at that depth.
+	const depth = 3
+	want, err := NewEngine().Search(context.Background(), NewGame(), SearchLimits{depth: depth})
+	if err != nil {
+		t.Fatalf("Search returned error %v", err)
+	}
+	from, dest := want.move.piece.cc, want.move.dest
+
+	var tests = []struct{
+		name string
+		// Whether the hint is cancelled after its search returned, but before the UI received the result.
+		cancel bool
+		wantHint bool
+	}{
+		{"shown once found", false, true},
+		{"discarded once cancelled", true, false},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		updates := make(chan func(), 1)
+		state.queueUpdate = func(f func()) { updates <- f }
+		state.hintEngine = NewEngine()
+		state.hintLimits = SearchLimits{depth: depth}
+		ShowHint(state)
+		update := <-updates
+		if tt.cancel {
+			CancelHint(state)
+		}
+		update()
+		if state.hasHint != tt.wantHint {
+			t.Errorf("%v: hasHint got %v, want %v", tt.name, state.hasHint, tt.wantHint)
+		}
+		if state.hintCancel != nil {
+			t.Errorf("%v: hint search still running", tt.name)
+		}
+		highlighted := highlightedIn(state, from, state.squareHighlightStyle) &&
+			highlightedIn(state, dest, state.squareValidMoveStyle)
+		if highlighted != tt.wantHint {
+			t.Errorf("%v: %v highlighted got %v, want %v", tt.name, want.move.asMove().CoordNotation(), highlighted,
+				tt.wantHint)
+		}
+		if !tt.wantHint {
+			continue
+		}
+		if state.hint.asMove() != want.move.asMove() {
+			t.Errorf("%v: hint got %v, want %v", tt.name, state.hint.asMove().CoordNotation(),
+				want.move.asMove().CoordNotation())
+		}
+		wantStatus := "hint: " + want.move.asMove().CoordNotation()
+		if got := state.currentPlayerStatus.GetText(false); got != wantStatus {
+			t.Errorf("%v: status got %q, want %q", tt.name, got, wantStatus)
+		}
+	}
+}
+
+// Lays the squares out on the screen as 4 columns wide and 2 rows high, from x=10 and y=5.
+func placeSquares(state *State) {
+	for row := range 8 {
+		for col := range 8 {
+			state.squares[row][col].SetRect(10 + col * 4, 5 + row * 2, 4, 2)
+		}
+	}
+}
+
+// Returns a screen position inside the square c as placeSquares lays it out, with White at the bottom or, if flipped,
+// Black.
+func mousePosition(c Coord, flipped bool) (int, int) {
+	cc := c.AsCartesianCoord()
+	row, col := 7 - cc.Y, cc.X
+	if flipped {
+		row, col = cc.Y, 7 - cc.X
+	}
+	return 10 + col * 4 + 1, 5 + row * 2 + 1
+}
+
+func TestSquareAt(t *testing.T) {
+	var tests = []struct{
+		name string
+		flipped bool
+		x int
+		y int
+		want Coord
+		wantFound bool
+	}{
+		{"top left", false, 10, 5, "a8", true},
+		{"bottom right", false, 41, 20, "h1", true},
+		{"inside a square", false, 27, 18, "e2", true},
+		{"top left when flipped", true, 10, 5, "h1", true},
+		{"bottom right when flipped", true, 41, 20, "a8", true},
+		{"inside a square when flipped", true, 27, 18, "d7", true},
+		{"left of the board", false, 9, 5, "", false},
+		{"right of the board", false, 42, 20, "", false},
+		{"above the board", false, 10, 4, "", false},
+		{"below the board", true, 41, 21, "", false},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		state.flipped = tt.flipped
+		placeSquares(state)
+		got, found := squareAt(state, tt.x, tt.y)
+		if found != tt.wantFound || (found && got.AsCoord() != tt.want) {
+			t.Errorf("%v: squareAt(%v, %v) got %v, %v, want %v, %v", tt.name, tt.x, tt.y, got.AsCoord(), found, tt.want,
+				tt.wantFound)
+		}
+	}
+}
+
+func TestPressReleaseSquare(t *testing.T) {
+	var tests = []struct{
+		name string
+		flipped bool
+		// The mouse button going down or up on a square, or up off the board, handled as the app's mouse capture does.
+		events []string
+		// The move entered, if any, and the input afterwards, which holds the selected piece.
+		wantMove string
+		wantInput string
+	}{
+		{"click selects", false, []string{"down e2", "up e2"}, "", "e2"},
+		{"click click", false, []string{"down e2", "up e2", "down e4", "up e4"}, "e2e4", ""},
+		{"drag and drop", false, []string{"down g1", "up f3"}, "g1f3", ""},
+		{"drop off the board", false, []string{"down e2", "up"}, "", "e2"},
+		{"drop on the same square", false, []string{"down e2", "up e2"}, "", "e2"},
+		{"drop on an invalid square", false, []string{"down e2", "up e5"}, "", "e2"},
+		{"click another piece", false, []string{"down e2", "up e2", "down d2", "up d2"}, "", "d2"},
+		{"click an empty square", false, []string{"down e2", "up e2", "down e5", "up e5"}, "", ""},
+		{"click the opponent's piece", false, []string{"down e7", "up e7"}, "", ""},
+		{"release without a press", false, []string{"up e4"}, "", ""},
+		{"click click when flipped", true, []string{"down e2", "up e2", "down e4", "up e4"}, "e2e4", ""},
+		{"drag and drop when flipped", true, []string{"down b1", "up c3"}, "b1c3", ""},
+		{"drop off the board when flipped", true, []string{"down b1", "up"}, "", "b1"},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		state.flipped = tt.flipped
+		placeSquares(state)
+		for _, event := range tt.events {
+			action, square, _ := strings.Cut(event, " ")
+			x, y := 0, 0
+			if square != "" {
+				x, y = mousePosition(Coord(square), tt.flipped)
+			}
+			cc, found := squareAt(state, x, y)
+			switch action {
+			case "down":
+				if found {
+					PressSquare(state, cc)
+				}
+			case "up":
+				ReleaseSquare(state, cc, found)
+			}
+		}
+		var gotMove string
+		select {
+		case m := <-state.human.moves:
+			gotMove = m.asMove().CoordNotation()
+		default:
+		}
+		if gotMove != tt.wantMove {
+			t.Errorf("%v: move got %q, want %q", tt.name, gotMove, tt.wantMove)
+		}
+		if got := state.input.GetText(); got != tt.wantInput {
+			t.Errorf("%v: input got %q, want %q", tt.name, got, tt.wantInput)
+		}
+	}
+}
+
+func TestGridStateUpdaterLayers(t *testing.T) {
+	var tests = []struct{
+		name string
+		moves []string
+		hint string
+		text string
+		// The style of some squares, by the layer it comes from: last, highlight, valid or warning.
+		want map[Coord]string
+		// The square of a mated king, the only one with a title.
+		wantMate Coord
+	}{
+		{"last move", []string{"e2e4"}, "", "", map[Coord]string{"e2": "last", "e4": "last"}, ""},
+		{"hint over the last move", []string{"e2e4", "d7d5"}, "e4d5", "", map[Coord]string{"d7": "last", "e4": "highlight", "d5": "valid"}, ""},
+		{"selection over the last move", []string{"e2e4", "d7d5"}, "", "e4", map[Coord]string{"d7": "last", "e4": "highlight", "d5": "valid", "e5": "valid"}, ""},
+		{"selection hides the hint", []string{"e2e4", "d7d5"}, "e4d5", "g1", map[Coord]string{"d7": "last", "d5": "last", "g1": "highlight", "f3": "valid"}, ""},
+		{"check over the hint", []string{"f2f4", "e7e5", "e1f2", "d8h4"}, "f2f3", "", map[Coord]string{"f2": "warning", "f3": "valid", "h4": "last"}, ""},
+		{"check over the selection", []string{"f2f4", "e7e5", "e1f2", "d8h4"}, "", "f2", map[Coord]string{"f2": "warning", "e3": "valid", "d8": "last"}, ""},
+		{"hanging piece over the destination", []string{"e2e4", "e7e6", "b1c3", "a7a6"}, "", "c3d5", map[Coord]string{"c3": "highlight", "d5": "warning", "a7": "last"}, ""},
+		{"mate over the last move", []string{"f2f3", "e7e5", "g2g4", "d8h4"}, "", "", map[Coord]string{"e1": "warning", "d8": "last", "h4": "last"}, "e1"},
+	}
+
+	for _, tt := range tests {
+		state := newTestState()
+		playMoves(t, state.game, tt.moves...)
+		if tt.hint != "" {
+			from, dest := Coord(tt.hint[0:2]), Coord(tt.hint[2:4])
+			hint, found := state.game.FindValidMove(from.AsCartesianCoord(), dest.AsCartesianCoord(), Pawn)
+			if !found {
+				t.Fatalf("%v: hint %v not found", tt.name, tt.hint)
+			}
+			state.hint, state.hasHint = hint, true
+		}
+		GridStateUpdater(tt.text, state)
+		styles := map[string]tcell.Style{
+			"last": state.squareLastMoveStyle,
+			"highlight": state.squareHighlightStyle,
+			"valid": state.squareValidMoveStyle,
+			"warning": state.squareWarningStyle,
+		}
+		for c, layer := range tt.want {
+			if got := highlightedIn(state, c.AsCartesianCoord(), styles[layer]); !got {
+				t.Errorf("%v: %v not highlighted as %v", tt.name, c, layer)
+			}
+		}
+		for y := range 8 {
+			for x := range 8 {
+				cc := CartesianCoord{x, y}
+				wantTitle := ""
+				if cc.AsCoord() == tt.wantMate {
+					wantTitle = "mate"
+				}
+				if got := squareView(state, cc).GetTitle(); got != wantTitle {
+					t.Errorf("%v: title of %v got %q, want %q", tt.name, cc.AsCoord(), got, wantTitle)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uciEngineName = "go-chess"
+	uciEngineAuthor = "the go-chess authors"
+	maxHashSizeMB = 1024
+	// Rating UCI_Elo starts at.
+	defaultUCIElo = 1500
+)
+
+// State of a UCI session. Commands are read on one goroutine while a search runs on another, so writes to the output
+// go through the mutex.
+type uciSession struct {
+	out io.Writer
+	outMutex sync.Mutex
+
+	engine *Engine
+	game *Game
+	// The Skill Level option, and the UCI_Elo option, which takes its place while UCI_LimitStrength is set.
+	skillLevel int
+	limitStrength bool
+	elo int
+
+	// Set while a search runs; cancel stops it and done is closed once bestmove has been written.
+	cancel context.CancelFunc
+	done chan struct{}
+	infinite bool
+}
+
+// Runs the engine as a Universal Chess Interface engine, reading commands from in and writing responses to out until
+// the quit command or the end of the input. Options set by the GUI replace the engine's book, tablebases and threads.
+func RunUCI(in io.Reader, out io.Writer, engine *Engine) error {
+	s := uciSession{
+		out: out,
+		engine: engine,
+		game: NewGame(),
+		skillLevel: maxSkillLevel,
+		elo: defaultUCIElo,
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			s.send("id name %v", uciEngineName)
+			s.send("id author %v", uciEngineAuthor)
+			s.send("option name Hash type spin default %v min 1 max %v", defaultHashSizeMB, maxHashSizeMB)
+			s.send("option name Threads type spin default 1 min 1 max %v", maxThreads)
+			s.send("option name BookFile type string default <empty>")
+			s.send("option name TablebasePath type string default <empty>")
+			s.send("option name Skill Level type spin default %v min 1 max %v", maxSkillLevel, maxSkillLevel)
+			s.send("option name UCI_LimitStrength type check default false")
+			s.send("option name UCI_Elo type spin default %v min %v max %v", defaultUCIElo, minSkillElo, maxSkillElo)
+			s.send("uciok")
+		case "isready":
+			s.send("readyok")
+		case "ucinewgame":
+			s.stop()
+			s.engine.Clear()
+			s.game = NewGame()
+		case "position":
+			s.stop()
+			s.position(fields[1:])
+		case "go":
+			s.stop()
+			s.goSearch(fields[1:])
+		case "stop":
+			s.stop()
+		case "setoption":
+			s.stop()
+			s.setOption(fields[1:])
+		case "quit":
+			s.stop()
+			return nil
+		default:
+			s.send("info string unknown command %v", fields[0])
+		}
+	}
+
+	// At the end of the input nothing can send stop any more, so stop an infinite search but let others finish.
+	if s.infinite {
+		s.stop()
+	} else if s.done != nil {
+		<-s.done
+	}
+	return scanner.Err()
+}
+
+func (s *uciSession) send(format string, args ...any) {
+	s.outMutex.Lock()
+	defer s.outMutex.Unlock()
+	fmt.Fprintf(s.out, format + "\n", args...)
+}
+
+// Stops a running search and waits for its bestmove.
+func (s *uciSession) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+	s.done = nil
+	s.infinite = false
+}
+
+// Handles "position [startpos | fen <fen>] [moves <move>...]".
+func (s *uciSession) position(args []string) {
+	var g *Game
+	var err error
+	movesStart := len(args)
+	for i, arg := range args {
+		if arg == "moves" {
+			movesStart = i
+			break
+		}
+	}
+	switch {
+	case len(args) > 0 && args[0] == "startpos":
+		g = NewGame()
+	case len(args) > 0 && args[0] == "fen":
+		g, err = NewGameFromFEN(strings.Join(args[1:movesStart], " "))
+		if err != nil {
+			s.send("info string %v", err)
+			return
+		}
+	default:
+		s.send("info string position needs startpos or fen")
+		return
+	}
+
+	if movesStart < len(args) {
+		for _, arg := range args[movesStart+1:] {
+			m, err := g.ParseCoordMove(arg)
+			if err != nil {
+				s.send("info string %v", err)
+				return
+			}
+			g.ExecuteValidMove(m)
+		}
+	}
+	s.game = g
+}
+
+// Handles "go" with its search limits, starting a search that writes bestmove when it finishes. An infinite search
+// only writes bestmove once stopped, as the protocol requires.
+func (s *uciSession) goSearch(args []string) {
+	var limits SearchLimits
+	for i := 0; i < len(args); i++ {
+		if args[i] == "infinite" {
+			limits.infinite = true
+			continue
+		}
+		if i + 1 >= len(args) {
+			break
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			continue
+		}
+		ms := time.Duration(n) * time.Millisecond
+		switch args[i] {
+		case "depth":
+			limits.depth = n
+		case "nodes":
+			limits.nodes = uint64(n)
+		case "movetime":
+			limits.moveTime = ms
+		case "wtime":
+			limits.clock[White] = ms
+		case "btime":
+			limits.clock[Black] = ms
+		case "winc":
+			limits.increment[White] = ms
+		case "binc":
+			limits.increment[Black] = ms
+		case "movestogo":
+			limits.movesToGo = n
+		default:
+			continue
+		}
+		i++
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.cancel = cancel
+	s.done = done
+	s.infinite = limits.infinite
+	g := s.game
+	s.engine.onIteration = func(result SearchResult) {
+		s.send("%v", uciInfo(result))
+	}
+	go func() {
+		defer close(done)
+		result, err := s.engine.Search(ctx, g, limits)
+		if limits.infinite {
+			<-ctx.Done()
+		}
+		if err != nil {
+			s.send("bestmove 0000")
+			return
+		}
+		s.send("bestmove %v", result.move.asMove().CoordNotation())
+	}()
+}
+
+// Formats a search iteration as an info line. Mate scores are given in moves rather than plies.
+func uciInfo(result SearchResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "info depth %v score ", result.depth)
+	switch {
+	case result.score >= mateThreshold:
+		fmt.Fprintf(&sb, "mate %v", (mateScore - result.score + 1) / 2)
+	case result.score <= -mateThreshold:
+		fmt.Fprintf(&sb, "mate %v", -(mateScore + result.score) / 2)
+	default:
+		fmt.Fprintf(&sb, "cp %v", result.score)
+	}
+	ms := result.elapsed.Milliseconds()
+	nps := uint64(0)
+	if result.elapsed > 0 {
+		nps = uint64(float64(result.nodes) / result.elapsed.Seconds())
+	}
+	fmt.Fprintf(&sb, " nodes %v time %v nps %v", result.nodes, ms, nps)
+	if len(result.pv) > 0 {
+		sb.WriteString(" pv")
+		for _, m := range result.pv {
+			sb.WriteString(" " + m.CoordNotation())
+		}
+	}
+	return sb.String()
+}
+
+// Handles "setoption name <name> value <value>". Option names may contain spaces.
+func (s *uciSession) setOption(args []string) {
+	var name, value []string
+	var target *[]string
+	for _, arg := range args {
+		switch arg {
+		case "name":
+			target = &name
+		case "value":
+			target = &value
+		default:
+			if target != nil {
+				*target = append(*target, arg)
+			}
+		}
+	}
+
+	optionName := strings.ToLower(strings.Join(name, " "))
+	n, err := strconv.Atoi(strings.Join(value, " "))
+	switch optionName {
+	case "bookfile":
+		path := strings.Join(value, " ")
+		if path == "" || path == "<empty>" {
+			s.engine.SetBook(nil)
+			return
+		}
+		book, err := LoadOpeningBook(path)
+		if err != nil {
+			s.send("info string %v", err)
+			return
+		}
+		s.engine.SetBook(book)
+	case "tablebasepath":
+		path := strings.Join(value, " ")
+		if path == "" || path == "<empty>" {
+			s.engine.SetTablebases(nil)
+			return
+		}
+		tablebases, err := LoadTablebases(path)
+		if err != nil {
+			s.send("info string %v", err)
+			return
+		}
+		s.engine.SetTablebases(tablebases)
+	case "hash":
+		if err != nil || n < 1 || n > maxHashSizeMB {
+			s.send("info string invalid Hash value")
+			return
+		}
+		s.engine.SetHashSize(n)
+	case "threads":
+		if err != nil || n < 1 || n > maxThreads {
+			s.send("info string invalid Threads value")
+			return
+		}
+		s.engine.SetThreads(n)
+	case "skill level":
+		if err != nil || n < 1 || n > maxSkillLevel {
+			s.send("info string invalid Skill Level value")
+			return
+		}
+		s.skillLevel = n
+		s.updateSkillLevel()
+	case "uci_limitstrength":
+		s.limitStrength = strings.Join(value, " ") == "true"
+		s.updateSkillLevel()
+	case "uci_elo":
+		if err != nil || n < minSkillElo || n > maxSkillElo {
+			s.send("info string invalid UCI_Elo value")
+			return
+		}
+		s.elo = n
+		s.updateSkillLevel()
+	default:
+		s.send("info string unknown option %v", strings.Join(name, " "))
+	}
+}
+
+// Sets the engine's skill level from the options: by rating while UCI_LimitStrength is set, otherwise the Skill Level.
+func (s *uciSession) updateSkillLevel() {
+	if s.limitStrength {
+		s.engine.SetSkillLevel(SkillLevelForElo(s.elo))
+	} else {
+		s.engine.SetSkillLevel(s.skillLevel)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUCI(t *testing.T) {
+	var tests = []struct{
+		name string
+		input string
+		// Every line must appear in the output.
+		wantLines []string
+		// If set, the move following "bestmove" must be one of these.
+		wantBestMoves []string
+	}{
+		{
+			"handshake",
+			"uci\nisready\nquit\n",
+			[]string{
+				"id name " + uciEngineName,
+				"option name Hash type spin default 16 min 1 max 1024",
+				"option name Threads type spin default 1 min 1 max 256",
+				"option name UCI_Elo type spin default 1500 min 800 max 2400",
+				"uciok",
+				"readyok",
+			},
+			nil,
+		},
+		{
+			"start position",
+			"ucinewgame\nposition startpos moves e2e4 e7e5\ngo depth 2\n",
+			[]string{"info depth 2 score cp"},
+			nil,
+		},
+		{
+			"mate in one",
+			"position fen 7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1\ngo depth 3\n",
+			[]string{"score mate 1 "},
+			[]string{"a1a8"},
+		},
+		{
+			"moves after fen",
+			"position fen 7k/5ppp/8/8/8/8/8/R5K1 w - - 0 1 moves a1b1 h8g8\ngo depth 1\n",
+			nil,
+			nil,
+		},
+		{
+			"options",
+			"setoption name Hash value 1\nsetoption name Skill Level value 1\nsetoption name Bogus value 3\ngo wtime 1000 btime 1000\n",
+			[]string{"info string unknown option Bogus", "info depth 1 score"},
+			nil,
+		},
+		{
+			"limit strength",
+			"setoption name UCI_LimitStrength value true\nsetoption name UCI_Elo value 300\nsetoption name UCI_Elo value 800\ngo depth 9\n",
+			[]string{"info string invalid UCI_Elo value", "info depth 1 score"},
+			nil,
+		},
+		{
+			"invalid move",
+			"position startpos moves e2e5\n",
+			[]string{`info string "e2e5" is not a valid move for White`},
+			nil,
+		},
+		{
+			"checkmated",
+			"position startpos moves f2f3 e7e5 g2g4 d8h4\ngo depth 1\n",
+			[]string{"bestmove 0000"},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := RunUCI(strings.NewReader(tt.input), &out, NewEngine()); err != nil {
+				t.Fatalf("RunUCI returned error %v", err)
+			}
+			output := out.String()
+			for _, line := range tt.wantLines {
+				if !strings.Contains(output, line) {
+					t.Errorf("output %q does not contain %q", output, line)
+				}
+			}
+			if strings.Contains(tt.input, "go ") {
+				checkBestMove(t, output, tt.wantBestMoves)
+			}
+		})
+	}
+}
+
+func TestUCIInfiniteSearch(t *testing.T) {
+	in, inWriter := io.Pipe()
+	var out bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- RunUCI(in, &out, NewEngine())
+	}()
+
+	io.WriteString(inWriter, "position startpos\ngo infinite\n")
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(inWriter, "stop\nquit\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RunUCI returned error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("search did not stop")
+	}
+	checkBestMove(t, out.String(), nil)
+}
+
+// Checks that the output ends with exactly one bestmove, which is one of wantMoves if given.
+func checkBestMove(t *testing.T, output string, wantMoves []string) {
+	t.Helper()
+	if strings.Count(output, "bestmove") != 1 {
+		t.Fatalf("output %q does not have exactly one bestmove", output)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) != 2 || fields[0] != "bestmove" {
+		t.Fatalf("last line got %q, want bestmove", lines[len(lines)-1])
+	}
+	if wantMoves == nil {
+		return
+	}
+	for _, m := range wantMoves {
+		if fields[1] == m {
+			return
+		}
+	}
+	t.Errorf("bestmove got %v, want one of %v", fields[1], wantMoves)
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an external engine may take to answer the handshake and isready.
+const uciResponseTimeout = 10 * time.Second
+
+// How long an external engine may take to send bestmove after being told to stop. Tests shorten it.
+var uciStopTimeout = 5 * time.Second
+
+var errEngineExited = errors.New("engine exited")
+
+// Searcher finds moves for a game. The built-in Engine and external UCI engines both implement it, so either can be an
+// opponent.
+type Searcher interface {
+	// Searches the current position of the game within the limits, returning the best move found when the limits are
+	// reached or ctx is cancelled. The game is not changed.
+	Search(ctx context.Context, g *Game, limits SearchLimits) (SearchResult, error)
+	// Forgets what was learned from earlier searches, e.g. for a new game.
+	Clear()
+	Name() string
+}
+
+// Returns the name the engine gives itself.
+func (e *Engine) Name() string {
+	if e.skillLevel < maxSkillLevel {
+		return fmt.Sprintf("%v level %v", uciEngineName, e.skillLevel)
+	}
+	return uciEngineName
+}
+
+// UCIEngine is an external engine that runs as a subprocess and speaks the Universal Chess Interface. Its methods must
+// not be called concurrently.
+type UCIEngine struct {
+	name string
+	cmd *exec.Cmd
+	stdin io.WriteCloser
+	// Lines the engine writes, closed when its output ends.
+	lines chan string
+	closeOnce sync.Once
+}
+
+// Starts the engine binary at path with args and completes the UCI handshake. The engine's name is taken from its id
+// name line, or the binary's name if it gives none.
+func StartUCIEngine(path string, args ...string) (*UCIEngine, error) {
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting engine %v: %w", path, err)
+	}
+
+	e := UCIEngine{
+		name: filepath.Base(path),
+		cmd: cmd,
+		stdin: stdin,
+		lines: make(chan string, 64),
+	}
+	go func() {
+		defer close(e.lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			e.lines <- scanner.Text()
+		}
+	}()
+
+	if err := e.send("uci"); err != nil {
+		e.Close()
+		return nil, err
+	}
+	for {
+		line, err := e.readLine(uciResponseTimeout)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("engine %v handshake: %w", path, err)
+		}
+		if name, found := strings.CutPrefix(line, "id name "); found {
+			e.name = strings.TrimSpace(name)
+		}
+		if line == "uciok" {
+			break
+		}
+	}
+	if err := e.waitReady(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (e *UCIEngine) Name() string {
+	return e.name
+}
+
+func (e *UCIEngine) send(format string, args ...any) error {
+	_, err := fmt.Fprintf(e.stdin, format + "\n", args...)
+	return err
+}
+
+// Returns the next line the engine writes, waiting at most timeout, or forever if timeout is 0.
+func (e *UCIEngine) readLine(timeout time.Duration) (string, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case line, ok := <-e.lines:
+		if !ok {
+			return "", errEngineExited
+		}
+		return line, nil
+	case <-expired:
+		return "", fmt.Errorf("no response from engine within %v", timeout)
+	}
+}
+
+// Sends isready and waits for readyok.
+func (e *UCIEngine) waitReady() error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	for {
+		line, err := e.readLine(uciResponseTimeout)
+		if err != nil {
+			return err
+		}
+		if line == "readyok" {
+			return nil
+		}
+	}
+}
+
+// Sets a UCI option, e.g. "Hash" or "Skill Level".
+func (e *UCIEngine) SetOption(name string, value string) error {
+	if err := e.send("setoption name %v value %v", name, value); err != nil {
+		return err
+	}
+	return e.waitReady()
+}
+
+// Tells the engine a new game starts. Errors are left for the next search to report.
+func (e *UCIEngine) Clear() {
+	if e.send("ucinewgame") == nil {
+		e.waitReady()
+	}
+}
+
+// Sends the game's position and starts the engine searching within the limits. The info lines the engine sends are
+// collected into the result. If ctx is cancelled the engine is told to stop, and its best move so far is returned.
+func (e *UCIEngine) Search(ctx context.Context, g *Game, limits SearchLimits) (SearchResult, error) {
+	if len(g.LegalMoves()) == 0 {
+		return SearchResult{}, errNoLegalMoves
+	}
+	// A search that timed out waiting for bestmove may still send it, so skip whatever the engine sends until it is
+	// ready, rather than take it as the answer to this search.
+	if err := e.waitReady(); err != nil {
+		return SearchResult{}, err
+	}
+	if err := e.send("%v", uciPositionCommand(g)); err != nil {
+		return SearchResult{}, err
+	}
+	if err := e.send("%v", uciGoCommand(limits)); err != nil {
+		return SearchResult{}, err
+	}
+
+	start := time.Now()
+	var result SearchResult
+	stopped := false
+	for {
+		var line string
+		var ok bool
+		if stopped {
+			var err error
+			line, err = e.readLine(uciStopTimeout)
+			if err != nil {
+				return SearchResult{}, err
+			}
+			ok = true
+		} else {
+			select {
+			case line, ok = <-e.lines:
+			case <-ctx.Done():
+				stopped = true
+				if err := e.send("stop"); err != nil {
+					return SearchResult{}, err
+				}
+				continue
+			}
+		}
+		if !ok {
+			return SearchResult{}, errEngineExited
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "info":
+			parseUCIInfo(fields[1:], g, &result)
+		case "bestmove":
+			if len(fields) < 2 || fields[1] == "(none)" || fields[1] == "0000" {
+				return SearchResult{}, errNoLegalMoves
+			}
+			move, err := g.ParseCoordMove(fields[1])
+			if err != nil {
+				return SearchResult{}, fmt.Errorf("engine %v sent bestmove: %w", e.name, err)
+			}
+			result.move = move
+			if result.elapsed == 0 {
+				result.elapsed = time.Since(start)
+			}
+			return result, nil
+		}
+	}
+}
+
+// Stops the engine, killing it if it does not quit by itself.
+func (e *UCIEngine) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		e.send("quit")
+		e.stdin.Close()
+		exited := make(chan error, 1)
+		go func() {
+			exited <- e.cmd.Wait()
+		}()
+		select {
+		case err = <-exited:
+		case <-time.After(uciResponseTimeout):
+			e.cmd.Process.Kill()
+			err = <-exited
+		}
+		// drain output so the reading goroutine can finish
+		for range e.lines {
+		}
+	})
+	return err
+}
+
+// Returns the position command for the game: its starting position followed by the moves played since.
+func uciPositionCommand(g *Game) string {
+	var sb strings.Builder
+	if g.startFEN == "" {
+		sb.WriteString("position startpos")
+	} else {
+		sb.WriteString("position fen " + g.startFEN)
+	}
+	if len(g.moves) > g.setupMoves {
+		sb.WriteString(" moves")
+		for _, m := range g.moves[g.setupMoves:] {
+			sb.WriteString(" " + m.CoordNotation())
+		}
+	}
+	return sb.String()
+}
+
+// Returns the go command for the limits. Without limits the search is infinite, as it is for the built-in engine.
+func uciGoCommand(limits SearchLimits) string {
+	var sb strings.Builder
+	sb.WriteString("go")
+	if limits.infinite {
+		sb.WriteString(" infinite")
+		return sb.String()
+	}
+	if limits.depth > 0 {
+		fmt.Fprintf(&sb, " depth %v", limits.depth)
+	}
+	if limits.nodes > 0 {
+		fmt.Fprintf(&sb, " nodes %v", limits.nodes)
+	}
+	if limits.moveTime > 0 {
+		fmt.Fprintf(&sb, " movetime %v", limits.moveTime.Milliseconds())
+	}
+	if limits.clock[White] > 0 || limits.clock[Black] > 0 {
+		fmt.Fprintf(&sb, " wtime %v btime %v", limits.clock[White].Milliseconds(), limits.clock[Black].Milliseconds())
+		if limits.increment[White] > 0 || limits.increment[Black] > 0 {
+			fmt.Fprintf(&sb, " winc %v binc %v", limits.increment[White].Milliseconds(), limits.increment[Black].Milliseconds())
+		}
+		if limits.movesToGo > 0 {
+			fmt.Fprintf(&sb, " movestogo %v", limits.movesToGo)
+		}
+	}
+	if sb.Len() == len("go") {
+		sb.WriteString(" infinite")
+	}
+	return sb.String()
+}
+
+// Updates the result from the fields of an info line. Lines without a score, such as currmove updates, leave the
+// score and principal variation alone. Mate scores are converted back from moves to plies, see uciInfo.
+func parseUCIInfo(fields []string, g *Game, result *SearchResult) {
+	for i := 0; i < len(fields); i++ {
+		if i + 1 >= len(fields) {
+			break
+		}
+		switch fields[i] {
+		case "depth":
+			if n, err := strconv.Atoi(fields[i+1]); err == nil {
+				result.depth = n
+			}
+			i++
+		case "nodes":
+			if n, err := strconv.ParseUint(fields[i+1], 10, 64); err == nil {
+				result.nodes = n
+			}
+			i++
+		case "time":
+			if n, err := strconv.Atoi(fields[i+1]); err == nil {
+				result.elapsed = time.Duration(n) * time.Millisecond
+			}
+			i++
+		case "score":
+			if i + 2 >= len(fields) {
+				return
+			}
+			n, err := strconv.Atoi(fields[i+2])
+			if err != nil {
+				return
+			}
+			switch fields[i+1] {
+			case "cp":
+				result.score = n
+			case "mate":
+				if n > 0 {
+					result.score = mateScore - (2*n - 1)
+				} else {
+					result.score = -mateScore - 2*n
+				}
+			}
+			i += 2
+		case "pv":
+			// The rest of the line is the principal variation. Moves are replayed on a copy of the game, since a
+			// Move records the piece that moves.
+			replayed := g.Clone()
+			result.pv = result.pv[:0]
+			for _, s := range fields[i+1:] {
+				m, err := replayed.ParseCoordMove(s)
+				if err != nil {
+					break
+				}
+				result.pv = append(result.pv, m.asMove())
+				replayed.ExecuteValidMove(m)
+			}
+			return
+		case "string":
+			return
+		}
+	}
+}
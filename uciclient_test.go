@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Set in the environment of the test binary to make it run as the fake engine instead of the tests, to one of the
+// fakeEngine modes.
+const fakeEngineEnv = "CHESS_FAKE_UCI_ENGINE"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeEngineEnv); mode != "" {
+		fakeEngine(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// A minimal UCI engine standing in for a real one. It always plays the first legal move in LegalMoves order. In
+// "crash" mode it exits instead of searching, and in "late" mode it sends bestmove only well after being told to stop.
+func fakeEngine(mode string) {
+	g := NewGame()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name Fake Engine")
+			fmt.Println("option name Hash type spin default 1 min 1 max 1")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "position":
+			var err error
+			if fields[1] == "startpos" {
+				g = NewGame()
+			} else {
+				end := len(fields)
+				for i, f := range fields {
+					if f == "moves" {
+						end = i
+					}
+				}
+				g, err = NewGameFromFEN(strings.Join(fields[2:end], " "))
+				if err != nil {
+					fmt.Printf("info string %v\n", err)
+				}
+			}
+			for i, f := range fields {
+				if f != "moves" {
+					continue
+				}
+				for _, s := range fields[i+1:] {
+					m, _ := g.ParseCoordMove(s)
+					g.ExecuteValidMove(m)
+				}
+			}
+		case "go":
+			if mode == "crash" {
+				return
+			}
+			moves := g.LegalMoves()
+			if len(moves) == 0 {
+				fmt.Println("bestmove (none)")
+				continue
+			}
+			if fields[len(fields)-1] == "infinite" {
+				for scanner.Scan() && scanner.Text() != "stop" {
+				}
+				if mode == "late" {
+					time.Sleep(300 * time.Millisecond)
+				}
+			}
+			best := moves[0].asMove().CoordNotation()
+			fmt.Println("info currmove " + best)
+			fmt.Printf("info depth 3 seldepth 5 score cp 12 nodes 42 nps 1000 time 7 pv %v\n", best)
+			fmt.Println("info string done")
+			fmt.Println("bestmove " + best)
+		case "quit":
+			return
+		}
+	}
+}
+
+// Starts the test binary as the fake engine in the given mode.
+func startFakeEngine(t *testing.T, mode string) *UCIEngine {
+	t.Helper()
+	t.Setenv(fakeEngineEnv, mode)
+	e, err := StartUCIEngine(os.Args[0])
+	if err != nil {
+		t.Fatalf("StartUCIEngine returned error %v", err)
+	}
+	t.Cleanup(func() {
+		e.Close()
+	})
+	return e
+}
+
+func TestUCIEngine(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+	if e.Name() != "Fake Engine" {
+		t.Errorf("name got %q, want %q", e.Name(), "Fake Engine")
+	}
+	if err := e.SetOption("Hash", "1"); err != nil {
+		t.Errorf("SetOption returned error %v", err)
+	}
+	e.Clear()
+
+	g := NewGame()
+	playMoves(t, g, "e2e4", "e7e5")
+	for _, limits := range []SearchLimits{{depth: 3}, {moveTime: time.Second}, {infinite: true}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+		result, err := e.Search(ctx, g, limits)
+		cancel()
+		if err != nil {
+			t.Fatalf("Search(%+v) returned error %v", limits, err)
+		}
+		wantMove := g.LegalMoves()[0]
+		if result.move.asMove() != wantMove.asMove() {
+			t.Errorf("move got %+v, want %+v", result.move, wantMove)
+		}
+		if result.depth != 3 || result.score != 12 || result.nodes != 42 || result.elapsed != 7 * time.Millisecond {
+			t.Errorf("result got %+v, want depth 3 score 12 nodes 42 time 7ms", result)
+		}
+		if len(result.pv) != 1 || result.pv[0] != wantMove.asMove() {
+			t.Errorf("pv got %+v, want [%+v]", result.pv, wantMove.asMove())
+		}
+	}
+
+	checkmated := NewGame()
+	playMoves(t, checkmated, "f2f3", "e7e5", "g2g4", "d8h4")
+	if _, err := e.Search(context.Background(), checkmated, SearchLimits{depth: 1}); !errors.Is(err, errNoLegalMoves) {
+		t.Errorf("Search of checkmate got error %v, want %v", err, errNoLegalMoves)
+	}
+}
+
+func TestUCIEngineExits(t *testing.T) {
+	e := startFakeEngine(t, "crash")
+	_, err := e.Search(context.Background(), NewGame(), SearchLimits{depth: 1})
+	if !errors.Is(err, errEngineExited) {
+		t.Errorf("Search got error %v, want %v", err, errEngineExited)
+	}
+}
+
+func TestUCIEngineLateBestMove(t *testing.T) {
+	defer func(timeout time.Duration) {
+		uciStopTimeout = timeout
+	}(uciStopTimeout)
+	uciStopTimeout = 50 * time.Millisecond
+
+	e := startFakeEngine(t, "late")
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	if _, err := e.Search(ctx, NewGame(), SearchLimits{infinite: true}); err == nil {
+		t.Fatalf("Search got no error for a bestmove later than the stop timeout")
+	}
+
+	// The late bestmove, for the starting position, must not be taken as the answer in the next one.
+	g := NewGame()
+	playMoves(t, g, "e2e4")
+	result, err := e.Search(context.Background(), g, SearchLimits{depth: 1})
+	if err != nil {
+		t.Fatalf("Search after a late bestmove returned error %v", err)
+	}
+	if want := g.LegalMoves()[0]; result.move.asMove() != want.asMove() {
+		t.Errorf("move got %v, want %v", result.move.asMove().CoordNotation(), want.asMove().CoordNotation())
+	}
+}
+
+func TestUCIEngineNotFound(t *testing.T) {
+	if _, err := StartUCIEngine("./no-such-engine"); err == nil {
+		t.Errorf("got no error")
+	}
+}
+
+func TestUCICommands(t *testing.T) {
+	g, err := NewGameFromFEN("4k3/8/8/8/8/8/4P3/4K3 w - - 0 1")
+	if err != nil {
+		t.Fatalf("NewGameFromFEN returned error %v", err)
+	}
+	playMoves(t, g, "e2e4", "e8d7")
+	var positionTests = []struct{
+		g *Game
+		want string
+	}{
+		{NewGame(), "position startpos"},
+		{g, "position fen 4k3/8/8/8/8/8/4P3/4K3 w - - 0 1 moves e2e4 e8d7"},
+	}
+	for _, tt := range positionTests {
+		if got := uciPositionCommand(tt.g); got != tt.want {
+			t.Errorf("uciPositionCommand got %q, want %q", got, tt.want)
+		}
+	}
+
+	var goTests = []struct{
+		limits SearchLimits
+		want string
+	}{
+		{SearchLimits{}, "go infinite"},
+		{SearchLimits{infinite: true, depth: 3}, "go infinite"},
+		{SearchLimits{depth: 3, nodes: 1000}, "go depth 3 nodes 1000"},
+		{SearchLimits{moveTime: 2 * time.Second}, "go movetime 2000"},
+		{
+			SearchLimits{
+				clock: [2]time.Duration{time.Minute, 30 * time.Second},
+				increment: [2]time.Duration{time.Second, time.Second},
+				movesToGo: 10,
+			},
+			"go wtime 60000 btime 30000 winc 1000 binc 1000 movestogo 10",
+		},
+	}
+	for _, tt := range goTests {
+		if got := uciGoCommand(tt.limits); got != tt.want {
+			t.Errorf("uciGoCommand got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseUCIInfo(t *testing.T) {
+	var tests = []struct{
+		line string
+		wantScore int
+	}{
+		{"info depth 2 score cp -35 nodes 10", -35},
+		{"info depth 2 score cp 20 lowerbound nodes 10", 20},
+		{"info depth 2 score mate 1 nodes 10", mateScore - 1},
+		{"info depth 2 score mate 3 nodes 10", mateScore - 5},
+		{"info depth 2 score mate -2 nodes 10", -mateScore + 4},
+	}
+	for _, tt := range tests {
+		var result SearchResult
+		parseUCIInfo(strings.Fields(tt.line)[1:], NewGame(), &result)
+		if result.score != tt.wantScore || result.depth != 2 || result.nodes != 10 {
+			t.Errorf("parseUCIInfo(%q) got %+v, want score %v", tt.line, result, tt.wantScore)
+		}
+		// Our own info lines must parse back to the same score.
+		if got := strings.Fields(uciInfo(SearchResult{depth: 2, score: tt.wantScore, nodes: 10})); got[4] != strings.Fields(tt.line)[4] {
+			t.Errorf("uciInfo score got %v, want %v", got[4], strings.Fields(tt.line)[4])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/bits"
+	"math/rand/v2"
+)
+
+// Castling rights, combined as a bit set. The rules derive castling from the move history rather than storing rights,
+// so castlingRights works them out from whether the kings and rooks have left their starting squares.
+const (
+	whiteKingside = 1 << iota
+	whiteQueenside
+	blackKingside
+	blackQueenside
+)
+
+// Random keys for Zobrist hashing. A position hashes to the XOR of the keys of every piece on its square, the side to
+// move, its castling rights and the file of a pawn that can be captured en passant.
+var (
+	zobristPieces [2][6][64]uint64
+	zobristBlackToMove uint64
+	zobristCastling [16]uint64
+	zobristEnPassant [8]uint64
+)
+
+func init() {
+	// A fixed seed keeps hashes identical between runs, which keeps searches reproducible.
+	r := rand.New(rand.NewPCG(0x5EED, 0xC4E55))
+	for c := range zobristPieces {
+		for pt := range zobristPieces[c] {
+			for sq := range zobristPieces[c][pt] {
+				zobristPieces[c][pt][sq] = r.Uint64()
+			}
+		}
+	}
+	zobristBlackToMove = r.Uint64()
+	for i := range zobristCastling {
+		zobristCastling[i] = r.Uint64()
+	}
+	for i := range zobristEnPassant {
+		zobristEnPassant[i] = r.Uint64()
+	}
+}
+
+// Returns the Zobrist hash of the position with the given color to move.
+func zobristHash(color Color, b Board, gameMoves []Move) uint64 {
+	var hash uint64
+	for c := range b.players {
+		for pt, pieces := range b.players[c].pieces {
+			for pieces != 0 {
+				hash ^= zobristPieces[c][pt][bits.TrailingZeros64(pieces)]
+				pieces &= pieces - 1
+			}
+		}
+	}
+	if color == Black {
+		hash ^= zobristBlackToMove
+	}
+	hash ^= zobristCastling[castlingRights(b, gameMoves)]
+	if file, found := enPassantFile(color, b, gameMoves); found {
+		hash ^= zobristEnPassant[file]
+	}
+	return hash
+}
+
+// Returns the castling rights of both players. A right is held while the king and the rook on that side are still on
+// their starting squares and have never moved.
+func castlingRights(b Board, gameMoves []Move) int {
+	var rights = []struct{
+		color Color
+		rook Coord
+		right int
+	}{
+		{White, "h1", whiteKingside},
+		{White, "a1", whiteQueenside},
+		{Black, "h8", blackKingside},
+		{Black, "a8", blackQueenside},
+	}
+	kings := [...]Coord{White: "e1", Black: "e8"}
+
+	result := 0
+	for _, r := range rights {
+		king := Piece{r.color, King, kings[r.color].AsCartesianCoord()}
+		if p, found := GetCoord(king.cc, b); !found || p != king || hasPieceMoved(king, gameMoves) {
+			continue
+		}
+		rook := Piece{r.color, Rook, r.rook.AsCartesianCoord()}
+		if p, found := GetCoord(rook.cc, b); !found || p != rook || hasPieceMoved(rook, gameMoves) {
+			continue
+		}
+		result |= r.right
+	}
+	return result
+}
+
+// Returns the file of the pawn that color could capture en passant. Only a pawn that just advanced two squares counts,
+// and only if a pawn of color stands beside it, so that positions without a real en passant capture hash the same.
+func enPassantFile(color Color, b Board, gameMoves []Move) (int, bool) {
+	if len(gameMoves) == 0 {
+		return 0, false
+	}
+	lastMove := gameMoves[len(gameMoves)-1]
+	yDelta := lastMove.dest.Y - lastMove.piece.cc.Y
+	if lastMove.piece.pieceType != Pawn || (yDelta != 2 && yDelta != -2) {
+		return 0, false
+	}
+	pos := lastMove.dest.AsBitCoord()
+	if uint64(pos.To(-1, 0) | pos.To(1, 0)) & b.players[color].pieces[Pawn] == 0 {
+		return 0, false
+	}
+	return lastMove.dest.X, true
+}